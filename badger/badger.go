@@ -0,0 +1,413 @@
+// Package badger provides a cache adapter over Badger, an embedded LSM
+// key-value store, for on-disk caches too large for FileCache's one file
+// per entry.
+//
+// Entries expire through Badger's own per-key TTL, so expired entries are
+// dropped by compaction without a sweeper. Keys are stored under a prefix,
+// which lets several caches share one database and Clear drop only its own.
+package badger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	badgerdb "github.com/dgraph-io/badger/v3"
+	"github.com/pkg6/go-cache"
+)
+
+const (
+	// DefaultPrefix is prepended to every key.
+	DefaultPrefix = "gocache:"
+	// DefaultGCInterval is how often the value log is garbage collected.
+	DefaultGCInterval = 10 * time.Minute
+	// DefaultGCDiscardRatio is the share of stale data a value log file
+	// needs before it is rewritten.
+	DefaultGCDiscardRatio = 0.5
+	// maxConflictRetries bounds the retries of a counter transaction that
+	// conflicted with another writer.
+	maxConflictRetries = 100
+)
+
+var DefaultPath = filepath.Join(os.TempDir(), "gocache-badger")
+
+type Cache struct {
+	// Path of the database directory, used unless DB is set
+	Path string
+	// DB is the database; when nil it is opened from Path on first use
+	DB *badgerdb.DB
+	// Prefix is prepended to every key
+	Prefix string
+	// GCInterval is how often the value log is garbage collected, 0
+	// disables it
+	GCInterval time.Duration
+	// GCDiscardRatio is passed to RunValueLogGC
+	GCDiscardRatio float64
+	CacheItem      cache.ICacheItem
+	// Logger logs the failures of the value log GC; nil logs nothing
+	Logger *cache.Logger
+
+	// l guards CacheItem, which SetCacheItem mutates while encoding
+	l sync.Mutex
+	// dbL guards DB while it is opened and closed
+	dbL     sync.Mutex
+	owned   bool
+	closed  bool
+	janitor *cache.Janitor
+}
+type CacheOptions func(c *Cache)
+
+// CacheWithPath configures the database directory.
+func CacheWithPath(path string) CacheOptions {
+	return func(c *Cache) {
+		c.Path = path
+	}
+}
+
+// CacheWithDB configures an open database. The Cache does not close it.
+func CacheWithDB(db *badgerdb.DB) CacheOptions {
+	return func(c *Cache) {
+		c.DB = db
+	}
+}
+
+// CacheWithPrefix configures the prefix of the keys, so several caches
+// can share one database.
+func CacheWithPrefix(prefix string) CacheOptions {
+	return func(c *Cache) {
+		c.Prefix = prefix
+	}
+}
+
+// CacheWithGCInterval configures how often the value log is garbage
+// collected.
+func CacheWithGCInterval(interval time.Duration) CacheOptions {
+	return func(c *Cache) {
+		c.GCInterval = interval
+	}
+}
+
+// CacheWithGCDiscardRatio configures the discard ratio of value log GC.
+func CacheWithGCDiscardRatio(ratio float64) CacheOptions {
+	return func(c *Cache) {
+		c.GCDiscardRatio = ratio
+	}
+}
+
+func CacheWithCacheItem(cacheItem cache.ICacheItem) CacheOptions {
+	return func(c *Cache) {
+		c.CacheItem = cacheItem
+	}
+}
+
+// CacheWithLogger sets the Logger of the store.
+func CacheWithLogger(logger *cache.Logger) CacheOptions {
+	return func(c *Cache) {
+		c.Logger = logger
+	}
+}
+
+// New creates a new badger cache. Call Close to stop its value log GC and
+// release the database.
+func New(opts ...CacheOptions) cache.Cache {
+	c := &Cache{
+		Path:           DefaultPath,
+		Prefix:         DefaultPrefix,
+		GCInterval:     DefaultGCInterval,
+		GCDiscardRatio: DefaultGCDiscardRatio,
+		CacheItem:      &cache.CacheItem{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.janitor = cache.StartJanitor(c.GCInterval, func() {
+		if err := c.RunValueLogGC(); err != nil {
+			c.Logger.Failure(c.Name(), "RunValueLogGC", err)
+		}
+	})
+	return c
+}
+
+func (c *Cache) Name() string {
+	return cache.BadgerCacheName
+}
+
+func (c *Cache) Set(key string, value any, ttl time.Duration) error {
+	entry, err := c.entry(key, value, ttl)
+	if err != nil {
+		return err
+	}
+	return c.update(func(txn *badgerdb.Txn) error {
+		return txn.SetEntry(entry)
+	})
+}
+
+func (c *Cache) Has(key string) (bool, error) {
+	if _, err := c.Get(key); err != nil {
+		if cache.IsMiss(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// GetMulti gets all keys in one read transaction.
+func (c *Cache) GetMulti(keys []string) ([]any, error) {
+	values := make([]any, len(keys))
+	var keysErr cache.MultiError
+	err := c.view(func(txn *badgerdb.Txn) error {
+		for i, key := range keys {
+			item, err := c.get(txn, key)
+			if cache.IsMiss(err) || errors.Is(err, cache.ErrSerialization) {
+				keysErr = append(keysErr, cache.KeyError{Key: key, Err: err})
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			values[i] = item.GetData()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, keysErr.ErrorOrNil()
+}
+
+func (c *Cache) Get(key string) (any, error) {
+	item, err := c.getItem(key)
+	if err != nil {
+		return nil, err
+	}
+	return item.GetData(), nil
+}
+
+// GetWithTTL gets key with the ttl left in its envelope.
+func (c *Cache) GetWithTTL(ctx context.Context, key string) (any, time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	item, err := c.getItem(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	return cache.ItemWithTTL(item)
+}
+
+func (c *Cache) getItem(key string) (item cache.ICacheItem, err error) {
+	err = c.view(func(txn *badgerdb.Txn) error {
+		item, err = c.get(txn, key)
+		return err
+	})
+	return item, err
+}
+
+func (c *Cache) Delete(key string) error {
+	return c.update(func(txn *badgerdb.Txn) error {
+		return txn.Delete(c.cacheKey(key))
+	})
+}
+
+// Increment increases a key's counter inside one transaction.
+func (c *Cache) Increment(key string, step int) error {
+	return c.counter(key, step, step, cache.Increment)
+}
+
+// Decrement decreases a key's counter inside one transaction.
+func (c *Cache) Decrement(key string, step int) error {
+	return c.counter(key, step, -step, cache.Decrement)
+}
+
+// Clear drops the keys under Prefix, or the whole database when Prefix is
+// empty.
+func (c *Cache) Clear() error {
+	db, err := c.db()
+	if err != nil {
+		return err
+	}
+	if c.Prefix == "" {
+		return cache.WrapBackendError(db.DropAll())
+	}
+	return cache.WrapBackendError(db.DropPrefix([]byte(c.Prefix)))
+}
+
+// Keys returns the unexpired keys of the cache, without Prefix.
+func (c *Cache) Keys() ([]string, error) {
+	var keys []string
+	err := c.view(func(txn *badgerdb.Txn) error {
+		opts := badgerdb.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = []byte(c.Prefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			keys = append(keys, string(it.Item().Key()[len(c.Prefix):]))
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// RunValueLogGC rewrites the value log files holding at least
+// GCDiscardRatio of stale data, until none is left. It runs every
+// GCInterval in the background.
+func (c *Cache) RunValueLogGC() error {
+	db, err := c.db()
+	if err != nil {
+		return err
+	}
+	for {
+		err := db.RunValueLogGC(c.GCDiscardRatio)
+		if errors.Is(err, badgerdb.ErrNoRewrite) || errors.Is(err, badgerdb.ErrRejected) {
+			return nil
+		}
+		if err != nil {
+			return cache.WrapBackendError(err)
+		}
+	}
+}
+
+// Ping checks that the database is open, opening Path if needed.
+func (c *Cache) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return cache.WrapError(cache.ErrUnavailable, err)
+	}
+	db, err := c.db()
+	if err != nil {
+		return err
+	}
+	if db.IsClosed() {
+		return cache.WrapError(cache.ErrUnavailable, badgerdb.ErrDBClosed)
+	}
+	return nil
+}
+
+// Close stops the value log GC and closes the database if the Cache
+// opened it. It is safe to call more than once.
+func (c *Cache) Close() error {
+	c.janitor.Stop()
+	c.dbL.Lock()
+	defer c.dbL.Unlock()
+	c.closed = true
+	if !c.owned || c.DB == nil {
+		return nil
+	}
+	err := c.DB.Close()
+	c.DB, c.owned = nil, false
+	return cache.WrapBackendError(err)
+}
+
+// counter applies fn to the counter under key. A missing key is created
+// holding initial; an existing one keeps its expiration time. The
+// transaction is retried when it conflicts with another writer.
+func (c *Cache) counter(key string, step, initial int, fn func(originVal any, step int) (any, error)) error {
+	var err error
+	for i := 0; i < maxConflictRetries; i++ {
+		err = c.update(func(txn *badgerdb.Txn) error {
+			var (
+				data any = initial
+				ttl  time.Duration
+			)
+			item, err := c.get(txn, key)
+			if err != nil && !cache.IsMiss(err) {
+				return err
+			}
+			if err == nil {
+				if data, err = fn(item.GetData(), step); err != nil {
+					return err
+				}
+				if !item.IsNeverExpires() {
+					ttl = time.Until(item.GetExpirationTime())
+				}
+			}
+			entry, err := c.entry(key, data, ttl)
+			if err != nil {
+				return err
+			}
+			return txn.SetEntry(entry)
+		})
+		if !errors.Is(err, badgerdb.ErrConflict) {
+			return err
+		}
+	}
+	return cache.WrapError(cache.ErrUnavailable, err)
+}
+
+func (c *Cache) get(txn *badgerdb.Txn, key string) (cache.ICacheItem, error) {
+	entry, err := txn.Get(c.cacheKey(key))
+	if errors.Is(err, badgerdb.ErrKeyNotFound) {
+		return nil, cache.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var item cache.ICacheItem
+	err = entry.Value(func(val []byte) error {
+		item, err = c.CacheItem.GetCacheItem(val)
+		return err
+	})
+	return item, err
+}
+
+// entry wraps value in the cache item envelope. Badger keeps expiration
+// times in whole seconds, so the entry outlives the envelope by less than
+// a second rather than expiring early.
+func (c *Cache) entry(key string, value any, ttl time.Duration) (*badgerdb.Entry, error) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	val, err := c.CacheItem.SetCacheItem(value, ttl)
+	if err != nil {
+		return nil, err
+	}
+	entry := badgerdb.NewEntry(c.cacheKey(key), []byte(val))
+	if !c.CacheItem.IsNeverExpires() {
+		expires := c.CacheItem.GetExpirationTime()
+		entry.ExpiresAt = uint64(math.Ceil(float64(expires.UnixNano()) / float64(time.Second)))
+	}
+	return entry, nil
+}
+
+func (c *Cache) cacheKey(key string) []byte {
+	return []byte(c.Prefix + key)
+}
+
+func (c *Cache) view(fn func(txn *badgerdb.Txn) error) error {
+	db, err := c.db()
+	if err != nil {
+		return err
+	}
+	return cache.WrapBackendError(db.View(fn))
+}
+
+func (c *Cache) update(fn func(txn *badgerdb.Txn) error) error {
+	db, err := c.db()
+	if err != nil {
+		return err
+	}
+	return cache.WrapBackendError(db.Update(fn), badgerdb.ErrConflict)
+}
+
+// db returns the database, opening Path on first use.
+func (c *Cache) db() (*badgerdb.DB, error) {
+	c.dbL.Lock()
+	defer c.dbL.Unlock()
+	if c.DB != nil {
+		return c.DB, nil
+	}
+	if c.closed {
+		return nil, cache.WrapError(cache.ErrUnavailable, badgerdb.ErrDBClosed)
+	}
+	db, err := badgerdb.Open(badgerdb.DefaultOptions(c.Path).WithLogger(nil))
+	if err != nil {
+		return nil, cache.WrapError(cache.ErrUnavailable, fmt.Errorf("open %s: %w", c.Path, err))
+	}
+	c.DB, c.owned = db, true
+	return db, nil
+}
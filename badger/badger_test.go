@@ -0,0 +1,158 @@
+package badger
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	badgerdb "github.com/dgraph-io/badger/v3"
+	"github.com/pkg6/go-cache"
+	"github.com/pkg6/go-cache/cachetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCache(tb testing.TB, opts ...CacheOptions) *Cache {
+	opts = append([]CacheOptions{CacheWithPath(filepath.Join(tb.TempDir(), "badger"))}, opts...)
+	c := New(opts...).(*Cache)
+	tb.Cleanup(func() {
+		_ = c.Close()
+	})
+	return c
+}
+
+func TestConformance(t *testing.T) {
+	cachetest.RunConformance(t, func(t *testing.T) cache.Cache {
+		return newTestCache(t)
+	})
+}
+
+func TestCacheLifecycle(t *testing.T) {
+	cachetest.RunLifecycle(t, func(t *testing.T) cache.Cache {
+		return newTestCache(t)
+	})
+}
+
+func TestCacheLogging(t *testing.T) {
+	cachetest.RunLogging(t, func(t *testing.T) cache.Cache {
+		return newTestCache(t)
+	})
+}
+
+func openTestDB(t *testing.T) *badgerdb.DB {
+	db, err := badgerdb.Open(badgerdb.DefaultOptions(t.TempDir()).WithLogger(nil))
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return db
+}
+
+func TestCachePrefixes(t *testing.T) {
+	cachetest.RunSharedBackend(t, func(t *testing.T) (cache.Cache, cache.Cache) {
+		db := openTestDB(t)
+		return New(CacheWithDB(db), CacheWithPrefix("a:")), New(CacheWithDB(db), CacheWithPrefix("b:"))
+	})
+}
+
+func TestCacheKeys(t *testing.T) {
+	db := openTestDB(t)
+	a := newTestCache(t, CacheWithDB(db), CacheWithPrefix("a:"))
+	b := newTestCache(t, CacheWithDB(db), CacheWithPrefix("b:"))
+	for _, key := range []string{"key1", "key2"} {
+		assert.Nil(t, a.Set(key, "a", time.Minute))
+		assert.Nil(t, b.Set(key, "b", time.Minute))
+	}
+	assert.Nil(t, a.Set("expired", "a", 50*time.Millisecond))
+	time.Sleep(time.Second)
+	keys, err := a.Keys()
+	assert.Nil(t, err)
+	sort.Strings(keys)
+	assert.Equal(t, []string{"key1", "key2"}, keys)
+}
+
+func TestCacheNativeTTL(t *testing.T) {
+	c := newTestCache(t)
+	assert.Nil(t, c.Set("key1", "author", 1500*time.Millisecond))
+	assert.Nil(t, c.view(func(txn *badgerdb.Txn) error {
+		item, err := txn.Get(c.cacheKey("key1"))
+		assert.Nil(t, err)
+		// rounded up to whole seconds, never down
+		assert.False(t, time.Unix(int64(item.ExpiresAt()), 0).Before(time.Now().Add(1400*time.Millisecond)))
+		return nil
+	}))
+	assert.Nil(t, c.Set("key2", "author", 0))
+	assert.Nil(t, c.view(func(txn *badgerdb.Txn) error {
+		item, err := txn.Get(c.cacheKey("key2"))
+		assert.Nil(t, err)
+		assert.Zero(t, item.ExpiresAt())
+		return nil
+	}))
+}
+
+func TestCacheRunValueLogGC(t *testing.T) {
+	c := newTestCache(t, CacheWithGCInterval(0))
+	assert.Nil(t, c.Set("key1", "author", time.Minute))
+	assert.Nil(t, c.RunValueLogGC())
+	assert.Nil(t, c.Close())
+	assert.ErrorIs(t, c.RunValueLogGC(), cache.ErrUnavailable)
+}
+
+func benchmarkCaches(b *testing.B) map[string]cache.Cache {
+	return map[string]cache.Cache{
+		"badger": newTestCache(b, CacheWithGCInterval(0)),
+		"file":   cache.NewFileCache(cache.FileCacheWithCachePath(b.TempDir())),
+	}
+}
+
+func BenchmarkSet(b *testing.B) {
+	for name, c := range benchmarkCaches(b) {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := c.Set("key"+strconv.Itoa(i%10000), i, time.Hour); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	for name, c := range benchmarkCaches(b) {
+		for i := 0; i < 10000; i++ {
+			if err := c.Set("key"+strconv.Itoa(i), i, time.Hour); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := c.Get("key" + strconv.Itoa(i%10000)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGetMulti(b *testing.B) {
+	keys := make([]string, 100)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+	for name, c := range benchmarkCaches(b) {
+		for _, key := range keys {
+			if err := c.Set(key, key, time.Hour); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := c.GetMulti(keys); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
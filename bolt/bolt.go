@@ -0,0 +1,464 @@
+// Package bolt provides a cache adapter storing all entries in a single
+// bbolt file, for stores too large or too long-lived for FileCache's one
+// file per entry.
+//
+// Every namespace is a bucket holding a data bucket, keyed by cache key,
+// and a ttl bucket indexing expiring keys by expiration time, so sweeps
+// only visit entries that are due.
+package bolt
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg6/go-cache"
+	bbolt "go.etcd.io/bbolt"
+)
+
+const (
+	// DefaultNamespace is the bucket entries are stored in.
+	DefaultNamespace = "gocache"
+	// DefaultOpenTimeout bounds the wait for the file lock of the database,
+	// which another process or Cache may hold.
+	DefaultOpenTimeout = 10 * time.Second
+	// DefaultSweepInterval is how often expired entries are removed.
+	DefaultSweepInterval = 5 * time.Minute
+)
+
+var (
+	DefaultPath = filepath.Join(os.TempDir(), "gocache.db")
+
+	dataBucket = []byte("data")
+	ttlBucket  = []byte("ttl")
+)
+
+type Cache struct {
+	// Path of the database file, used unless DB is set
+	Path string
+	// DB is the database; when nil it is opened from Path on first use
+	DB *bbolt.DB
+	// Namespace is the top level bucket of the entries
+	Namespace string
+	// OpenTimeout bounds the wait for the file lock when opening Path
+	OpenTimeout time.Duration
+	// SweepInterval is how often expired entries are removed, 0 disables it
+	SweepInterval time.Duration
+	CacheItem     cache.ICacheItem
+	// Logger logs the entries the sweeps evict, and their failures; nil
+	// logs nothing
+	Logger *cache.Logger
+
+	// l guards CacheItem, which SetCacheItem mutates while encoding
+	l sync.Mutex
+	// dbL guards DB while it is opened and closed
+	dbL     sync.Mutex
+	owned   bool
+	closed  bool
+	janitor *cache.Janitor
+}
+type CacheOptions func(c *Cache)
+
+// CacheWithPath configures the database file.
+func CacheWithPath(path string) CacheOptions {
+	return func(c *Cache) {
+		c.Path = path
+	}
+}
+
+// CacheWithDB configures an open database. The Cache does not close it.
+func CacheWithDB(db *bbolt.DB) CacheOptions {
+	return func(c *Cache) {
+		c.DB = db
+	}
+}
+
+// CacheWithNamespace configures the bucket entries are stored in, so
+// several caches can share one database.
+func CacheWithNamespace(namespace string) CacheOptions {
+	return func(c *Cache) {
+		c.Namespace = namespace
+	}
+}
+
+// CacheWithOpenTimeout configures how long opening Path waits for its lock.
+func CacheWithOpenTimeout(timeout time.Duration) CacheOptions {
+	return func(c *Cache) {
+		c.OpenTimeout = timeout
+	}
+}
+
+// CacheWithSweepInterval configures how often expired entries are removed.
+func CacheWithSweepInterval(interval time.Duration) CacheOptions {
+	return func(c *Cache) {
+		c.SweepInterval = interval
+	}
+}
+
+func CacheWithCacheItem(cacheItem cache.ICacheItem) CacheOptions {
+	return func(c *Cache) {
+		c.CacheItem = cacheItem
+	}
+}
+
+// CacheWithLogger sets the Logger of the store.
+func CacheWithLogger(logger *cache.Logger) CacheOptions {
+	return func(c *Cache) {
+		c.Logger = logger
+	}
+}
+
+// New creates a new bbolt cache. Call Close to stop its sweeper and
+// release the database file.
+func New(opts ...CacheOptions) cache.Cache {
+	c := &Cache{
+		Path:          DefaultPath,
+		Namespace:     DefaultNamespace,
+		OpenTimeout:   DefaultOpenTimeout,
+		SweepInterval: DefaultSweepInterval,
+		CacheItem:     &cache.CacheItem{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.janitor = cache.StartJanitor(c.SweepInterval, func() {
+		c.Logger.Sweep(c.Name(), c.Sweep)
+	})
+	return c
+}
+
+func (c *Cache) Name() string {
+	return cache.BoltCacheName
+}
+
+func (c *Cache) Set(key string, value any, ttl time.Duration) error {
+	val, expires, err := c.encode(value, ttl)
+	if err != nil {
+		return err
+	}
+	return c.update(func(b *bbolt.Bucket) error {
+		return c.put(b, key, val, expires)
+	})
+}
+
+func (c *Cache) Has(key string) (bool, error) {
+	if _, err := c.Get(key); err != nil {
+		if cache.IsMiss(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// GetMulti gets all keys in one read transaction.
+func (c *Cache) GetMulti(keys []string) ([]any, error) {
+	values := make([]any, len(keys))
+	var keysErr cache.MultiError
+	err := c.view(func(b *bbolt.Bucket) error {
+		for i, key := range keys {
+			item, err := c.decode(get(b, key))
+			if err != nil {
+				keysErr = append(keysErr, cache.KeyError{Key: key, Err: err})
+				continue
+			}
+			values[i] = item.GetData()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, keysErr.ErrorOrNil()
+}
+
+func (c *Cache) Get(key string) (any, error) {
+	item, err := c.getItem(key)
+	if err != nil {
+		return nil, err
+	}
+	return item.GetData(), nil
+}
+
+// GetWithTTL gets key with the ttl left in its envelope.
+func (c *Cache) GetWithTTL(ctx context.Context, key string) (any, time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	item, err := c.getItem(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	return cache.ItemWithTTL(item)
+}
+
+func (c *Cache) getItem(key string) (item cache.ICacheItem, err error) {
+	err = c.view(func(b *bbolt.Bucket) error {
+		item, err = c.decode(get(b, key))
+		return err
+	})
+	return item, err
+}
+
+func (c *Cache) Delete(key string) error {
+	return c.update(func(b *bbolt.Bucket) error {
+		return c.remove(b, key)
+	})
+}
+
+// Increment increases a key's counter inside one write transaction.
+func (c *Cache) Increment(key string, step int) error {
+	return c.counter(key, step, step, cache.Increment)
+}
+
+// Decrement decreases a key's counter inside one write transaction.
+func (c *Cache) Decrement(key string, step int) error {
+	return c.counter(key, step, -step, cache.Decrement)
+}
+
+// Clear drops the namespace, leaving other namespaces of the database alone.
+func (c *Cache) Clear() error {
+	db, err := c.db()
+	if err != nil {
+		return err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.DeleteBucket([]byte(c.Namespace)); err != nil && !errors.Is(err, bbolt.ErrBucketNotFound) {
+			return err
+		}
+		_, err := createBuckets(tx, c.Namespace)
+		return err
+	})
+	return cache.WrapBackendError(err)
+}
+
+// Sweep removes the entries that have expired and reports how many.
+// It runs every SweepInterval in the background.
+func (c *Cache) Sweep() (int, error) {
+	removed := 0
+	err := c.update(func(b *bbolt.Bucket) error {
+		data, index := b.Bucket(dataBucket), b.Bucket(ttlBucket)
+		now := time.Now()
+		var due [][]byte
+		cur := index.Cursor()
+		for k, _ := cur.First(); k != nil && !expiresAt(k).After(now); k, _ = cur.Next() {
+			due = append(due, append([]byte(nil), k...))
+		}
+		for _, k := range due {
+			if err := index.Delete(k); err != nil {
+				return err
+			}
+			key := k[8:]
+			// the index entry may be stale if the key was set again since
+			if _, err := c.decode(data.Get(key)); errors.Is(err, cache.ErrExpired) {
+				if err := data.Delete(key); err != nil {
+					return err
+				}
+				removed++
+			}
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// Ping checks that the database is open, opening Path if needed.
+func (c *Cache) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return cache.WrapError(cache.ErrUnavailable, err)
+	}
+	db, err := c.db()
+	if err != nil {
+		return err
+	}
+	return cache.WrapBackendError(db.View(func(*bbolt.Tx) error {
+		return nil
+	}))
+}
+
+// Close stops the sweeper and closes the database if the Cache opened it.
+// It is safe to call more than once.
+func (c *Cache) Close() error {
+	c.janitor.Stop()
+	c.dbL.Lock()
+	defer c.dbL.Unlock()
+	c.closed = true
+	if !c.owned || c.DB == nil {
+		return nil
+	}
+	err := c.DB.Close()
+	c.DB, c.owned = nil, false
+	return cache.WrapBackendError(err)
+}
+
+// counter applies fn to the counter under key. A missing key is created
+// holding initial; an existing one keeps its expiration time.
+func (c *Cache) counter(key string, step, initial int, fn func(originVal any, step int) (any, error)) error {
+	return c.update(func(b *bbolt.Bucket) error {
+		item, err := c.decode(get(b, key))
+		if cache.IsMiss(err) {
+			val, expires, err := c.encode(initial, 0)
+			if err != nil {
+				return err
+			}
+			return c.put(b, key, val, expires)
+		}
+		if err != nil {
+			return err
+		}
+		data, err := fn(item.GetData(), step)
+		if err != nil {
+			return err
+		}
+		ttl := time.Duration(0)
+		if !item.IsNeverExpires() {
+			ttl = time.Until(item.GetExpirationTime())
+		}
+		val, expires, err := c.encode(data, ttl)
+		if err != nil {
+			return err
+		}
+		return c.put(b, key, val, expires)
+	})
+}
+
+// encode wraps value in the cache item envelope and reports its
+// expiration time, zero when it never expires.
+func (c *Cache) encode(value any, ttl time.Duration) ([]byte, time.Time, error) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	val, err := c.CacheItem.SetCacheItem(value, ttl)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if c.CacheItem.IsNeverExpires() {
+		return []byte(val), time.Time{}, nil
+	}
+	return []byte(val), c.CacheItem.GetExpirationTime(), nil
+}
+
+// decode reads an envelope. val is only valid inside its transaction,
+// which decode does not outlive.
+func (c *Cache) decode(val []byte) (cache.ICacheItem, error) {
+	if val == nil {
+		return nil, cache.ErrNotFound
+	}
+	return c.CacheItem.GetCacheItem(val)
+}
+
+func (c *Cache) view(fn func(b *bbolt.Bucket) error) error {
+	db, err := c.db()
+	if err != nil {
+		return err
+	}
+	err = db.View(func(tx *bbolt.Tx) error {
+		// b is nil until the namespace is first written to
+		return fn(tx.Bucket([]byte(c.Namespace)))
+	})
+	return cache.WrapBackendError(err)
+}
+
+func (c *Cache) update(fn func(b *bbolt.Bucket) error) error {
+	db, err := c.db()
+	if err != nil {
+		return err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		b, err := createBuckets(tx, c.Namespace)
+		if err != nil {
+			return err
+		}
+		return fn(b)
+	})
+	return cache.WrapBackendError(err)
+}
+
+// db returns the database, opening Path on first use.
+func (c *Cache) db() (*bbolt.DB, error) {
+	c.dbL.Lock()
+	defer c.dbL.Unlock()
+	if c.DB != nil {
+		return c.DB, nil
+	}
+	if c.closed {
+		return nil, cache.WrapError(cache.ErrUnavailable, bbolt.ErrDatabaseNotOpen)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0o700); err != nil {
+		return nil, cache.WrapError(cache.ErrUnavailable, err)
+	}
+	db, err := bbolt.Open(c.Path, 0o600, &bbolt.Options{Timeout: c.OpenTimeout})
+	if err != nil {
+		return nil, cache.WrapError(cache.ErrUnavailable, fmt.Errorf("open %s: %w", c.Path, err))
+	}
+	c.DB, c.owned = db, true
+	return db, nil
+}
+
+func createBuckets(tx *bbolt.Tx, namespace string) (*bbolt.Bucket, error) {
+	b, err := tx.CreateBucketIfNotExists([]byte(namespace))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range [][]byte{dataBucket, ttlBucket} {
+		if _, err := b.CreateBucketIfNotExists(name); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// get returns the envelope under key, nil when the namespace or key
+// does not exist.
+func get(b *bbolt.Bucket, key string) []byte {
+	if b == nil {
+		return nil
+	}
+	return b.Bucket(dataBucket).Get([]byte(key))
+}
+
+// put stores val under key and moves its ttl index entry along.
+func (c *Cache) put(b *bbolt.Bucket, key string, val []byte, expires time.Time) error {
+	if err := c.remove(b, key); err != nil {
+		return err
+	}
+	if err := b.Bucket(dataBucket).Put([]byte(key), val); err != nil {
+		return err
+	}
+	if expires.IsZero() {
+		return nil
+	}
+	return b.Bucket(ttlBucket).Put(indexKey(expires, key), nil)
+}
+
+// remove deletes key and its ttl index entry.
+func (c *Cache) remove(b *bbolt.Bucket, key string) error {
+	data := b.Bucket(dataBucket)
+	old := data.Get([]byte(key))
+	if old == nil {
+		return nil
+	}
+	// an expired envelope still decodes; an undecodable one leaves a stale
+	// index entry behind, which Sweep tolerates
+	if item, err := c.decode(old); item != nil && (err == nil || errors.Is(err, cache.ErrExpired)) && !item.IsNeverExpires() {
+		if err := b.Bucket(ttlBucket).Delete(indexKey(item.GetExpirationTime(), key)); err != nil {
+			return err
+		}
+	}
+	return data.Delete([]byte(key))
+}
+
+// indexKey orders the ttl index by expiration time, then key.
+func indexKey(expires time.Time, key string) []byte {
+	k := make([]byte, 8, 8+len(key))
+	binary.BigEndian.PutUint64(k, uint64(expires.UnixNano()))
+	return append(k, key...)
+}
+
+func expiresAt(indexKey []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(indexKey[:8])))
+}
@@ -0,0 +1,98 @@
+package bolt
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg6/go-cache"
+	"github.com/pkg6/go-cache/cachetest"
+	"github.com/stretchr/testify/assert"
+	bbolt "go.etcd.io/bbolt"
+)
+
+func newTestCache(t *testing.T, opts ...CacheOptions) *Cache {
+	opts = append([]CacheOptions{CacheWithPath(filepath.Join(t.TempDir(), "cache.db"))}, opts...)
+	c := New(opts...).(*Cache)
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+	return c
+}
+
+func TestConformance(t *testing.T) {
+	cachetest.RunConformance(t, func(t *testing.T) cache.Cache {
+		return newTestCache(t)
+	})
+}
+
+func TestCacheLifecycle(t *testing.T) {
+	cachetest.RunLifecycle(t, func(t *testing.T) cache.Cache {
+		return newTestCache(t)
+	})
+}
+
+func TestCacheLogging(t *testing.T) {
+	cachetest.RunLogging(t, func(t *testing.T) cache.Cache {
+		return newTestCache(t)
+	})
+}
+
+func TestCacheSweep(t *testing.T) {
+	var c *Cache
+	cachetest.RunSweep(t, func(t *testing.T) cache.Cache {
+		c = newTestCache(t, CacheWithSweepInterval(0))
+		return c
+	})
+	// the index only holds the entries left to expire
+	assert.Nil(t, c.view(func(b *bbolt.Bucket) error {
+		assert.Equal(t, 2, b.Bucket(ttlBucket).Stats().KeyN)
+		return nil
+	}))
+}
+
+func TestCacheSharedDatabase(t *testing.T) {
+	cachetest.RunSharedBackend(t, func(t *testing.T) (cache.Cache, cache.Cache) {
+		db, err := bbolt.Open(filepath.Join(t.TempDir(), "cache.db"), 0o600, nil)
+		assert.Nil(t, err)
+		t.Cleanup(func() {
+			_ = db.Close()
+		})
+		return New(CacheWithDB(db), CacheWithNamespace("a")), New(CacheWithDB(db), CacheWithNamespace("b"))
+	})
+}
+
+func TestCacheCounterKeepsExpiration(t *testing.T) {
+	c := newTestCache(t)
+	assert.Nil(t, c.Set("counter", 1, 200*time.Millisecond))
+	assert.Nil(t, c.Increment("counter", 2))
+	val, err := c.Get("counter")
+	assert.Nil(t, err)
+	assert.Equal(t, float64(3), val)
+	time.Sleep(300 * time.Millisecond)
+	_, err = c.Get("counter")
+	assert.True(t, cache.IsMiss(err))
+}
+
+func TestCachePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	c := New(CacheWithPath(path))
+	assert.Nil(t, c.Set("key1", "author", time.Minute))
+	assert.Nil(t, c.(*Cache).Close())
+	_, err := c.Get("key1")
+	assert.ErrorIs(t, err, cache.ErrUnavailable)
+
+	c = New(CacheWithPath(path))
+	defer c.(*Cache).Close()
+	val, err := c.Get("key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "author", val)
+}
+
+func TestCacheLogsEvictions(t *testing.T) {
+	logger, buf := cachetest.NewLogger()
+	c := newTestCache(t, CacheWithSweepInterval(20*time.Millisecond), CacheWithLogger(logger))
+	assert.Nil(t, c.Set("expired", "value", time.Millisecond))
+	time.Sleep(100 * time.Millisecond)
+	assert.Contains(t, buf.String(), `msg="cache: entries evicted" store=bolt reason=expired count=1`)
+}
@@ -1,23 +1,34 @@
 package cache
 
 import (
+	"context"
 	"errors"
 	"time"
 )
 
 var (
-	ErrKeyExpired  = errors.New("the key is expired")
-	ErrKeyNotExist = errors.New("the key isn't exist")
+	// Deprecated: use ErrExpired
+	ErrKeyExpired = ErrExpired
+	// Deprecated: use ErrNotFound
+	ErrKeyNotExist = ErrNotFound
 )
 
 const (
 	MinUint32 uint32 = 0
 	MinUint64 uint64 = 0
 
-	FileCacheName     = "file"
-	MemoryCacheName   = "memory"
-	RedisCacheName    = "redis"
-	MemcacheCacheName = "memcache"
+	FileCacheName       = "file"
+	MemoryCacheName     = "memory"
+	RedisCacheName      = "redis"
+	MemcacheCacheName   = "memcache"
+	BoltCacheName       = "bolt"
+	SQLiteCacheName     = "sqlite"
+	SQLCacheName        = "sql"
+	BadgerCacheName     = "badger"
+	NullCacheName       = "null"
+	FallbackCacheName   = "fallback"
+	ShardedCacheName    = "sharded"
+	ReplicatedCacheName = "replicated"
 )
 
 type Cache interface {
@@ -31,3 +42,37 @@ type Cache interface {
 	Decrement(key string, step int) error
 	Clear() error
 }
+
+// ContextCache is a Cache whose operations also take a context, which
+// carries deadlines and trace spans down to the store. The redis, sqlcache
+// and sqlite stores implement it natively; NewContextCache gives one for
+// any other Cache.
+type ContextCache interface {
+	Cache
+	SetContext(ctx context.Context, key string, value any, ttl time.Duration) error
+	HasContext(ctx context.Context, key string) (bool, error)
+	GetMultiContext(ctx context.Context, keys []string) ([]any, error)
+	GetContext(ctx context.Context, key string) (any, error)
+	DeleteContext(ctx context.Context, key string) error
+	IncrementContext(ctx context.Context, key string, step int) error
+	DecrementContext(ctx context.Context, key string, step int) error
+	ClearContext(ctx context.Context) error
+}
+
+// Pinger is implemented by stores that can check their backend is
+// reachable. Stores holding resources also implement io.Closer.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// ErrTTLUnknown is returned by the GetWithTTL of a wrapper whose store
+// cannot tell the remaining ttl of its entries.
+var ErrTTLUnknown = errors.New("cache: remaining ttl unknown")
+
+// TTLGetter is implemented by stores that can tell how long an entry has
+// left, which ReplicatedCache needs to repair it.
+type TTLGetter interface {
+	// GetWithTTL gets key with its remaining ttl, zero when it never
+	// expires.
+	GetWithTTL(ctx context.Context, key string) (any, time.Duration, error)
+}
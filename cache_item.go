@@ -18,7 +18,16 @@ type ICacheItem interface {
 	GetJoinTime() time.Time
 }
 
+// IKeyCacheItem is implemented by cache items that record the original key,
+// so an entry stored under a transformed key can be traced back.
+type IKeyCacheItem interface {
+	SetKey(key string)
+	GetKey() string
+}
+
 type CacheItem struct {
+	// original key, only set when the stored key differs from it
+	Key string `json:"key,omitempty"`
 	// data
 	Data any `json:"data"`
 	//expired ttl
@@ -31,6 +40,13 @@ type CacheItem struct {
 	NeverExpires bool `json:"never_expires"`
 }
 
+func (c *CacheItem) SetKey(key string) {
+	c.Key = key
+}
+func (c *CacheItem) GetKey() string {
+	return c.Key
+}
+
 func (c *CacheItem) GetTTL() time.Duration {
 	return c.TTL
 }
@@ -52,6 +68,7 @@ func (c *CacheItem) SetCacheItem(data any, ttl time.Duration) (string, error) {
 	c.Data = data
 	c.JoinTime = time.Now()
 	c.TTL = ttl
+	c.NeverExpires = false
 	if c.TTL == time.Duration(0) || c.TTL == IndefiniteTime {
 		c.NeverExpires = true
 		c.TTL = IndefiniteTime
@@ -59,22 +76,42 @@ func (c *CacheItem) SetCacheItem(data any, ttl time.Duration) (string, error) {
 	c.ExpirationTime = c.JoinTime.Add(c.TTL)
 	marshal, err := json.Marshal(c)
 	if err != nil {
-		return "", err
+		return "", WrapError(ErrSerialization, err)
 	}
 	return string(marshal), nil
 }
 
 func (c *CacheItem) GetCacheItem(data any) (item ICacheItem, err error) {
 	item = new(CacheItem)
+	if str, ok := data.(string); ok {
+		data = []byte(str)
+	}
+	if data == nil {
+		return item, ErrNotFound
+	}
 	if bytes, ok := data.([]byte); ok {
 		err := json.Unmarshal(bytes, &item)
 		if err != nil {
-			return item, err
+			return item, WrapError(ErrSerialization, err)
 		}
 		if item.GetExpirationTime().Before(time.Now()) && !item.IsNeverExpires() {
-			return item, ErrKeyExpired
+			return item, ErrExpired
 		}
 		return item, nil
 	}
-	return item, fmt.Errorf("data must be []byte")
+	return item, fmt.Errorf("%w: data must be []byte, got %T", ErrSerialization, data)
+}
+
+// ItemWithTTL returns the data of item with the ttl it has left, zero when
+// it never expires, for the GetWithTTL of stores. It returns ErrExpired
+// once no ttl is left.
+func ItemWithTTL(item ICacheItem) (any, time.Duration, error) {
+	if item.IsNeverExpires() || item.GetTTL() == IndefiniteTime {
+		return item.GetData(), 0, nil
+	}
+	ttl := time.Until(item.GetExpirationTime())
+	if ttl <= 0 {
+		return nil, 0, ErrExpired
+	}
+	return item.GetData(), ttl, nil
 }
@@ -0,0 +1,106 @@
+package cachetest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/pkg6/go-cache"
+)
+
+// Sweeper is a store removing its expired entries on demand.
+type Sweeper interface {
+	Sweep() (int, error)
+}
+
+// SharedFactory returns two new, empty stores sharing one backend, e.g.
+// two tables of a database the test opened and closes itself.
+type SharedFactory func(t *testing.T) (a, b cache.Cache)
+
+// RunLifecycle checks the lifecycle of a store owning resources, such as
+// an embedded database: Ping succeeds while it is open, Close can be
+// called twice, and Ping and Get fail with cache.ErrUnavailable once it is
+// closed. The store must implement cache.Pinger and io.Closer.
+func RunLifecycle(t *testing.T, factory Factory) {
+	c := factory(t)
+	pinger, ok := c.(cache.Pinger)
+	if !ok {
+		t.Fatalf("%T does not implement cache.Pinger", c)
+	}
+	closer, ok := c.(io.Closer)
+	if !ok {
+		t.Fatalf("%T does not implement io.Closer", c)
+	}
+	mustSet(t, c, "lifecycle", "value", time.Minute)
+	if err := pinger.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping() = %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := closer.Close(); err != nil {
+			t.Fatalf("Close() #%d = %v", i+1, err)
+		}
+	}
+	if err := pinger.Ping(context.Background()); !errors.Is(err, cache.ErrUnavailable) {
+		t.Errorf("Ping() after Close = %v; want ErrUnavailable", err)
+	}
+	if _, err := c.Get("lifecycle"); !errors.Is(err, cache.ErrUnavailable) {
+		t.Errorf("Get() after Close = %v; want ErrUnavailable", err)
+	}
+}
+
+// RunSweep checks that Sweep removes the expired entries, and only those.
+// The store must implement Sweeper, and sweep in the background rarely
+// enough not to race the check.
+func RunSweep(t *testing.T, factory Factory) {
+	c := factory(t)
+	sweeper, ok := c.(Sweeper)
+	if !ok {
+		t.Fatalf("%T does not implement cachetest.Sweeper", c)
+	}
+	mustSet(t, c, "sweep-expired", "value", 50*time.Millisecond)
+	mustSet(t, c, "sweep-reset", "value", 50*time.Millisecond)
+	mustSet(t, c, "sweep-forever", "value", 0)
+	mustSet(t, c, "sweep-later", "value", time.Minute)
+	time.Sleep(100 * time.Millisecond)
+	mustSet(t, c, "sweep-reset", "value", time.Minute)
+
+	removed, err := sweeper.Sweep()
+	if err != nil {
+		t.Fatalf("Sweep() = %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Sweep() removed %d entries; want 1", removed)
+	}
+	for _, key := range []string{"sweep-reset", "sweep-forever", "sweep-later"} {
+		assertString(t, c, key, "value")
+	}
+	if removed, err = sweeper.Sweep(); err != nil || removed != 0 {
+		t.Errorf("second Sweep() = %d, %v; want 0, nil", removed, err)
+	}
+}
+
+// RunSharedBackend checks that two stores sharing a backend are isolated
+// from each other: Clear only empties its own store, and closing a store
+// that borrowed the backend leaves it open for the other.
+func RunSharedBackend(t *testing.T, factory SharedFactory) {
+	a, b := factory(t)
+	mustSet(t, a, "shared", "a", time.Minute)
+	mustSet(t, b, "shared", "b", time.Minute)
+	assertString(t, a, "shared", "a")
+	if err := a.Clear(); err != nil {
+		t.Fatalf("Clear() = %v", err)
+	}
+	assertMiss(t, a, "shared")
+	assertString(t, b, "shared", "b")
+
+	if closer, ok := a.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			t.Fatalf("Close() = %v", err)
+		}
+	}
+	assertString(t, b, "shared", "b")
+	mustSet(t, b, "shared", "b2", time.Minute)
+	assertString(t, b, "shared", "b2")
+}
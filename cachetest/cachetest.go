@@ -0,0 +1,418 @@
+// Package cachetest provides a conformance suite for cache.Cache
+// implementations. Built-in adapters run it from their own tests and
+// third-party adapters can do the same to prove they honour the contract:
+//
+//	func TestConformance(t *testing.T) {
+//		cachetest.RunConformance(t, func(t *testing.T) cache.Cache {
+//			return mycache.New()
+//		})
+//	}
+package cachetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg6/go-cache"
+)
+
+// Factory returns a new, empty store. It is called at least once per
+// sub-test; two stores returned by the same factory must not share keys.
+type Factory func(t *testing.T) cache.Cache
+
+type config struct {
+	ttlResolution  time.Duration
+	clearIsolation bool
+	atomicWrites   bool
+	concurrency    int
+}
+
+type Option func(c *config)
+
+// WithTTLResolution configures the smallest ttl the store honours,
+// e.g. one second for redis and memcached.
+func WithTTLResolution(d time.Duration) Option {
+	return func(c *config) {
+		c.ttlResolution = d
+	}
+}
+
+// WithoutClearIsolation skips the check that Clear only affects its own
+// store, for backends such as memcached whose Clear flushes the server.
+func WithoutClearIsolation() Option {
+	return func(c *config) {
+		c.clearIsolation = false
+	}
+}
+
+// WithoutAtomicWrites skips reading keys while another goroutine writes
+// them, for stores whose writes can be observed half done.
+func WithoutAtomicWrites() Option {
+	return func(c *config) {
+		c.atomicWrites = false
+	}
+}
+
+// WithConcurrency configures how many goroutines the concurrency checks use.
+func WithConcurrency(n int) Option {
+	return func(c *config) {
+		c.concurrency = n
+	}
+}
+
+// RunConformance runs the whole suite as sub-tests of t.
+func RunConformance(t *testing.T, factory Factory, opts ...Option) {
+	c := &config{
+		ttlResolution:  50 * time.Millisecond,
+		clearIsolation: true,
+		atomicWrites:   true,
+		concurrency:    8,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	s := &suite{config: c, factory: factory}
+	t.Run("Name", s.testName)
+	t.Run("SetGet", s.testSetGet)
+	t.Run("Overwrite", s.testOverwrite)
+	t.Run("Has", s.testHas)
+	t.Run("Delete", s.testDelete)
+	t.Run("TTLExpiry", s.testTTLExpiry)
+	t.Run("NeverExpires", s.testNeverExpires)
+	t.Run("GetWithTTL", s.testGetWithTTL)
+	t.Run("Counters", s.testCounters)
+	t.Run("GetMulti", s.testGetMulti)
+	t.Run("Clear", s.testClear)
+	t.Run("ClearIsolation", s.testClearIsolation)
+	t.Run("Concurrency", s.testConcurrency)
+	t.Run("ConcurrentCounters", s.testConcurrentCounters)
+	t.Run("Errors", s.testErrors)
+}
+
+type suite struct {
+	*config
+	factory Factory
+}
+
+func (s *suite) testName(t *testing.T) {
+	c := s.factory(t)
+	if c.Name() == "" {
+		t.Error("Name() must not be empty")
+	}
+}
+
+func (s *suite) testSetGet(t *testing.T) {
+	c := s.factory(t)
+	mustSet(t, c, "set-get", "value", time.Minute)
+	assertString(t, c, "set-get", "value")
+}
+
+func (s *suite) testOverwrite(t *testing.T) {
+	c := s.factory(t)
+	mustSet(t, c, "overwrite", "first", time.Minute)
+	mustSet(t, c, "overwrite", "second", time.Minute)
+	assertString(t, c, "overwrite", "second")
+}
+
+func (s *suite) testHas(t *testing.T) {
+	c := s.factory(t)
+	mustSet(t, c, "has", "value", time.Minute)
+	ok, err := c.Has("has")
+	if err != nil || !ok {
+		t.Errorf("Has(present) = %v, %v; want true, nil", ok, err)
+	}
+	ok, err = c.Has("has-missing")
+	if err != nil || ok {
+		t.Errorf("Has(missing) = %v, %v; want false, nil", ok, err)
+	}
+}
+
+func (s *suite) testDelete(t *testing.T) {
+	c := s.factory(t)
+	mustSet(t, c, "delete", "value", time.Minute)
+	if err := c.Delete("delete"); err != nil {
+		t.Fatalf("Delete(present) = %v", err)
+	}
+	assertMiss(t, c, "delete")
+	if err := c.Delete("delete-missing"); err != nil {
+		t.Errorf("Delete(missing) = %v; want nil", err)
+	}
+}
+
+func (s *suite) testTTLExpiry(t *testing.T) {
+	c := s.factory(t)
+	// a never-expiring write first must not leak into the next one
+	mustSet(t, c, "ttl-forever", "value", 0)
+	mustSet(t, c, "ttl", "value", s.ttlResolution)
+	mustSet(t, c, "ttl-long", "value", time.Minute)
+	time.Sleep(2*s.ttlResolution + s.ttlResolution/2)
+	assertMiss(t, c, "ttl")
+	if ok, err := c.Has("ttl"); err != nil || ok {
+		t.Errorf("Has(expired) = %v, %v; want false, nil", ok, err)
+	}
+	assertString(t, c, "ttl-long", "value")
+}
+
+func (s *suite) testNeverExpires(t *testing.T) {
+	c := s.factory(t)
+	mustSet(t, c, "forever", "value", 0)
+	time.Sleep(2 * s.ttlResolution)
+	assertString(t, c, "forever", "value")
+}
+
+// testGetWithTTL checks the remaining ttl of the stores that are a
+// cache.TTLGetter.
+func (s *suite) testGetWithTTL(t *testing.T) {
+	c := s.factory(t)
+	getter, ok := c.(cache.TTLGetter)
+	if !ok {
+		t.Skip("not a cache.TTLGetter")
+	}
+	ctx := context.Background()
+	mustSet(t, c, "ttl-left", "value", time.Minute)
+	mustSet(t, c, "ttl-forever", "value", 0)
+	val, ttl, err := getter.GetWithTTL(ctx, "ttl-left")
+	if err != nil || toString(val) != "value" || ttl <= time.Minute-s.ttlResolution-time.Second || ttl > time.Minute {
+		t.Errorf("GetWithTTL(ttl-left) = %v, %v, %v; want value, about 1m, nil", val, ttl, err)
+	}
+	if val, ttl, err = getter.GetWithTTL(ctx, "ttl-forever"); err != nil || toString(val) != "value" || ttl != 0 {
+		t.Errorf("GetWithTTL(ttl-forever) = %v, %v, %v; want value, 0, nil", val, ttl, err)
+	}
+	if _, _, err = getter.GetWithTTL(ctx, "ttl-missing"); !cache.IsMiss(err) {
+		t.Errorf("GetWithTTL(missing) = %v; want a miss", err)
+	}
+}
+
+func (s *suite) testCounters(t *testing.T) {
+	c := s.factory(t)
+	if err := c.Increment("incr", 1); err != nil {
+		t.Fatalf("Increment(missing) = %v", err)
+	}
+	assertNumber(t, c, "incr", 1)
+	if err := c.Increment("incr", 2); err != nil {
+		t.Fatalf("Increment = %v", err)
+	}
+	assertNumber(t, c, "incr", 3)
+	if err := c.Decrement("incr", 1); err != nil {
+		t.Fatalf("Decrement = %v", err)
+	}
+	assertNumber(t, c, "incr", 2)
+
+	mustSet(t, c, "decr", 10, time.Minute)
+	if err := c.Decrement("decr", 3); err != nil {
+		t.Fatalf("Decrement = %v", err)
+	}
+	assertNumber(t, c, "decr", 7)
+}
+
+func (s *suite) testGetMulti(t *testing.T) {
+	c := s.factory(t)
+	mustSet(t, c, "multi-a", "a", time.Minute)
+	mustSet(t, c, "multi-b", "b", time.Minute)
+
+	values, err := c.GetMulti([]string{"multi-a", "multi-b"})
+	if err != nil {
+		t.Fatalf("GetMulti(present) = %v", err)
+	}
+	if len(values) != 2 || toString(values[0]) != "a" || toString(values[1]) != "b" {
+		t.Fatalf("GetMulti(present) = %v; want [a b]", values)
+	}
+
+	values, err = c.GetMulti([]string{"multi-a", "multi-missing", "multi-b"})
+	if !errors.Is(err, cache.ErrNotFound) {
+		t.Errorf("GetMulti(missing) error = %v; want ErrNotFound", err)
+	}
+	if len(values) != 3 || toString(values[0]) != "a" || values[1] != nil || toString(values[2]) != "b" {
+		t.Errorf("GetMulti(missing) = %v; want [a <nil> b]", values)
+	}
+}
+
+func (s *suite) testClear(t *testing.T) {
+	c := s.factory(t)
+	mustSet(t, c, "clear-a", "a", time.Minute)
+	mustSet(t, c, "clear-b", "b", 0)
+	if err := c.Clear(); err != nil {
+		t.Fatalf("Clear() = %v", err)
+	}
+	assertMiss(t, c, "clear-a")
+	assertMiss(t, c, "clear-b")
+	mustSet(t, c, "clear-a", "again", time.Minute)
+	assertString(t, c, "clear-a", "again")
+}
+
+func (s *suite) testClearIsolation(t *testing.T) {
+	if !s.clearIsolation {
+		t.Skip("store does not isolate Clear")
+	}
+	a, b := s.factory(t), s.factory(t)
+	mustSet(t, a, "isolation", "a", time.Minute)
+	mustSet(t, b, "isolation", "b", time.Minute)
+	if err := a.Clear(); err != nil {
+		t.Fatalf("Clear() = %v", err)
+	}
+	assertMiss(t, a, "isolation")
+	assertString(t, b, "isolation", "b")
+}
+
+func (s *suite) testConcurrency(t *testing.T) {
+	c := s.factory(t)
+	var wg sync.WaitGroup
+	errs := make(chan error, s.concurrency)
+	for i := 0; i < s.concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				key := fmt.Sprintf("concurrent-%d-%d", i, j)
+				value := strconv.Itoa(i*100 + j)
+				if err := c.Set(key, value, time.Minute); err != nil {
+					errs <- fmt.Errorf("Set(%s) = %w", key, err)
+					return
+				}
+				got, err := c.Get(key)
+				if err != nil || toString(got) != value {
+					errs <- fmt.Errorf("Get(%s) = %v, %v; want %s", key, got, err, value)
+					return
+				}
+				if s.atomicWrites {
+					if _, err := c.Get(fmt.Sprintf("concurrent-%d-%d", (i+1)%s.concurrency, j)); err != nil && !cache.IsMiss(err) {
+						errs <- fmt.Errorf("Get(neighbour) = %w", err)
+						return
+					}
+				}
+				if err := c.Delete(key); err != nil {
+					errs <- fmt.Errorf("Delete(%s) = %w", key, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func (s *suite) testConcurrentCounters(t *testing.T) {
+	c := s.factory(t)
+	mustSet(t, c, "concurrent-counter", 0, time.Minute)
+	const steps = 10
+	var wg sync.WaitGroup
+	for i := 0; i < s.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < steps; j++ {
+				if err := c.Increment("concurrent-counter", 1); err != nil {
+					t.Errorf("Increment = %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assertNumber(t, c, "concurrent-counter", float64(s.concurrency*steps))
+}
+
+func (s *suite) testErrors(t *testing.T) {
+	c := s.factory(t)
+	_, err := c.Get("errors-missing")
+	if !errors.Is(err, cache.ErrNotFound) {
+		t.Errorf("Get(missing) error = %v; want ErrNotFound", err)
+	}
+	mustSet(t, c, "errors-expired", "value", s.ttlResolution)
+	time.Sleep(2*s.ttlResolution + s.ttlResolution/2)
+	_, err = c.Get("errors-expired")
+	if !cache.IsMiss(err) {
+		t.Errorf("Get(expired) error = %v; want ErrExpired or ErrNotFound", err)
+	}
+	if errors.Is(err, cache.ErrUnavailable) {
+		t.Errorf("Get(expired) error = %v; must not be ErrUnavailable", err)
+	}
+}
+
+func mustSet(t *testing.T, c cache.Cache, key string, value any, ttl time.Duration) {
+	t.Helper()
+	if err := c.Set(key, value, ttl); err != nil {
+		t.Fatalf("Set(%s) = %v", key, err)
+	}
+}
+
+func assertMiss(t *testing.T, c cache.Cache, key string) {
+	t.Helper()
+	val, err := c.Get(key)
+	if !cache.IsMiss(err) {
+		t.Errorf("Get(%s) = %v, %v; want a miss", key, val, err)
+	}
+}
+
+func assertString(t *testing.T, c cache.Cache, key, want string) {
+	t.Helper()
+	val, err := c.Get(key)
+	if err != nil {
+		t.Errorf("Get(%s) = %v", key, err)
+		return
+	}
+	if got := toString(val); got != want {
+		t.Errorf("Get(%s) = %q; want %q", key, got, want)
+	}
+}
+
+func assertNumber(t *testing.T, c cache.Cache, key string, want float64) {
+	t.Helper()
+	val, err := c.Get(key)
+	if err != nil {
+		t.Errorf("Get(%s) = %v", key, err)
+		return
+	}
+	got, ok := toFloat(val)
+	if !ok || got != want {
+		t.Errorf("Get(%s) = %v (%T); want %v", key, val, val, want)
+	}
+}
+
+// toString normalises stores that hand back raw bytes.
+func toString(v any) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case []byte:
+		return string(val)
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// toFloat normalises the numeric types stores return after a round trip,
+// e.g. float64 from JSON or decimal text from memcached.
+func toFloat(v any) (float64, bool) {
+	switch val := v.(type) {
+	case int:
+		return float64(val), true
+	case int32:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	case uint:
+		return float64(val), true
+	case uint32:
+		return float64(val), true
+	case uint64:
+		return float64(val), true
+	case float32:
+		return float64(val), true
+	case float64:
+		return val, true
+	case string, []byte:
+		f, err := strconv.ParseFloat(toString(val), 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,68 @@
+package cachetest
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg6/go-cache"
+)
+
+// LogBuffer is a bytes.Buffer safe for stores logging concurrently, e.g.
+// from their sweepers.
+type LogBuffer struct {
+	buf bytes.Buffer
+	l   sync.Mutex
+}
+
+func (b *LogBuffer) Write(p []byte) (int, error) {
+	b.l.Lock()
+	defer b.l.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *LogBuffer) String() string {
+	b.l.Lock()
+	defer b.l.Unlock()
+	return b.buf.String()
+}
+
+// NewLogger returns a Logger writing every event as text to the returned
+// buffer.
+func NewLogger(opts ...cache.LoggerOptions) (*cache.Logger, *LogBuffer) {
+	buf := &LogBuffer{}
+	handler := slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+	return cache.NewLogger(slog.New(handler), opts...), buf
+}
+
+// RunLogging checks that a store wrapped in cache.WithLogging logs each
+// failure once, with the hash of its key rather than the key, and does
+// not log misses.
+func RunLogging(t *testing.T, factory Factory) {
+	logger, buf := NewLogger()
+	c := cache.WithLogging(logger)(factory(t))
+	mustSet(t, c, "logging-text", "value", time.Minute)
+	assertMiss(t, c, "logging-missing")
+	if err := c.Increment("logging-text", 1); err == nil {
+		t.Fatal("Increment() of a string succeeded")
+	}
+
+	logged := buf.String()
+	if n := strings.Count(logged, "cache: operation failed"); n != 1 {
+		t.Fatalf("logged %d failures; want 1:\n%s", n, logged)
+	}
+	if n := strings.Count(logged, "\n"); n != 1 {
+		t.Errorf("logged %d records; want 1:\n%s", n, logged)
+	}
+	for _, want := range []string{"store=" + c.Name(), "op=Increment", "key_hash="} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("log lacks %s:\n%s", want, logged)
+		}
+	}
+	if strings.Contains(logged, "logging-text") {
+		t.Errorf("log holds the key:\n%s", logged)
+	}
+}
@@ -0,0 +1,27 @@
+package cache_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg6/go-cache"
+	"github.com/pkg6/go-cache/cachetest"
+)
+
+func TestMemoryCacheConformance(t *testing.T) {
+	cachetest.RunConformance(t, func(t *testing.T) cache.Cache {
+		return cache.NewMemoryCache(time.Minute)
+	})
+}
+
+func TestFileCacheConformance(t *testing.T) {
+	cachetest.RunConformance(t, func(t *testing.T) cache.Cache {
+		return cache.NewFileCache(cache.FileCacheWithCachePath(t.TempDir()))
+	})
+}
+
+func TestRecordingCacheConformance(t *testing.T) {
+	cachetest.RunConformance(t, func(t *testing.T) cache.Cache {
+		return cache.NewRecordingCache(cache.NewMemoryCache(time.Minute))
+	})
+}
@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// NewContextCache returns c if it is a ContextCache, or wraps it in one
+// that fails with the context error when ctx is done before the call, and
+// otherwise calls c without the context. The wrapper forwards the
+// GetWithTTL of a TTLGetter.
+func NewContextCache(c Cache) ContextCache {
+	if cc, ok := c.(ContextCache); ok {
+		return cc
+	}
+	if _, ok := c.(TTLGetter); ok {
+		return ttlContextCache{&contextCache{Cache: c}}
+	}
+	return &contextCache{Cache: c}
+}
+
+type contextCache struct {
+	Cache
+}
+
+func (c *contextCache) SetContext(ctx context.Context, key string, value any, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.Set(key, value, ttl)
+}
+
+func (c *contextCache) HasContext(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return c.Has(key)
+}
+
+func (c *contextCache) GetMultiContext(ctx context.Context, keys []string) ([]any, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.GetMulti(keys)
+}
+
+func (c *contextCache) GetContext(ctx context.Context, key string) (any, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Get(key)
+}
+
+func (c *contextCache) DeleteContext(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.Delete(key)
+}
+
+func (c *contextCache) IncrementContext(ctx context.Context, key string, step int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.Increment(key, step)
+}
+
+func (c *contextCache) DecrementContext(ctx context.Context, key string, step int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.Decrement(key, step)
+}
+
+func (c *contextCache) ClearContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.Clear()
+}
+
+// ttlContextCache is the contextCache of a TTLGetter, which it forwards.
+type ttlContextCache struct {
+	*contextCache
+}
+
+func (c ttlContextCache) GetWithTTL(ctx context.Context, key string) (any, time.Duration, error) {
+	return c.Cache.(TTLGetter).GetWithTTL(ctx, key)
+}
+
+// getWithTTL gets key with its remaining ttl from c, or fails with
+// ErrTTLUnknown when c is not a TTLGetter.
+func getWithTTL(ctx context.Context, c Cache, key string) (any, time.Duration, error) {
+	getter, ok := c.(TTLGetter)
+	if !ok {
+		return nil, 0, ErrTTLUnknown
+	}
+	return getter.GetWithTTL(ctx, key)
+}
+
+// Ping pings the wrapped store, or succeeds when it is not a Pinger.
+func (c *contextCache) Ping(ctx context.Context) error {
+	if pinger, ok := c.Cache.(Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// Close closes the wrapped store, if it is an io.Closer.
+func (c *contextCache) Close() error {
+	if closer, ok := c.Cache.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
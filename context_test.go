@@ -0,0 +1,53 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type ctxKey struct{}
+
+func TestNewContextCache(t *testing.T) {
+	memory := NewMemoryCache(time.Minute)
+	c := NewContextCache(memory)
+	assert.Equal(t, MemoryCacheName, c.Name())
+	assert.Nil(t, c.SetContext(context.Background(), "key1", "author", time.Minute))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := c.GetContext(ctx, "key1")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, c.DeleteContext(ctx, "key1"), context.Canceled)
+	ok, err := memory.Has("key1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	hooked := WithHooks(Hooks{})(memory)
+	assert.Equal(t, hooked, NewContextCache(hooked))
+}
+
+func TestGoCacheContext(t *testing.T) {
+	var values []any
+	c := NewCache(NewMemoryCache(time.Minute)).Use(WithHooks(Hooks{
+		After: func(op *Operation) {
+			values = append(values, op.Context.Value(ctxKey{}))
+		},
+	}))
+	ctx := context.WithValue(context.Background(), ctxKey{}, "request")
+	assert.Nil(t, c.SetContext(ctx, "key1", "author", time.Minute))
+	val, err := c.GetContext(ctx, "key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "author", val)
+	_, err = c.Get("key1")
+	assert.Nil(t, err)
+	assert.Equal(t, []any{"request", "request", nil}, values)
+
+	store, err := c.Store(MemoryCacheName)
+	assert.Nil(t, err)
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.ErrorIs(t, store.ClearContext(ctx), context.Canceled)
+}
@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The shared error model. Every adapter wraps its failures with one of these,
+// so callers can branch with errors.Is regardless of the backend in use.
+var (
+	// ErrNotFound the key does not exist in the store
+	ErrNotFound = errors.New("cache: key not found")
+	// ErrExpired the key exists but its ttl has passed
+	ErrExpired = errors.New("cache: key expired")
+	// ErrUnavailable the backend could not be reached or failed to answer
+	ErrUnavailable = errors.New("cache: backend unavailable")
+	// ErrSerialization the value could not be encoded or decoded
+	ErrSerialization = errors.New("cache: serialization failed")
+	// ErrTooLarge the value exceeds the size the backend accepts
+	ErrTooLarge = errors.New("cache: value too large")
+)
+
+// IsMiss reports whether err means the key is absent, either because it
+// never existed or because it has expired.
+func IsMiss(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, ErrExpired)
+}
+
+// WrapError annotates err with kind, one of the shared errors above.
+// errors.Is(result, kind) holds and the root cause stays reachable through
+// errors.Unwrap and errors.As.
+func WrapError(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, kind) {
+		return err
+	}
+	return &wrapError{kind: kind, err: err}
+}
+
+type wrapError struct {
+	kind error
+	err  error
+}
+
+func (e *wrapError) Error() string {
+	return fmt.Sprintf("%s: %s", e.kind.Error(), e.err.Error())
+}
+
+func (e *wrapError) Unwrap() error {
+	return e.err
+}
+
+func (e *wrapError) Is(target error) bool {
+	return target == e.kind
+}
+
+// backendPassthrough are the errors WrapBackendError returns as is.
+var backendPassthrough = []error{
+	ErrNotFound, ErrExpired, ErrUnavailable, ErrSerialization, ErrTooLarge,
+	ErrNotIntegerType, ErrIncrementOverflow, ErrDecrementOverflow,
+}
+
+// WrapBackendError reports a failure of the backend of a store, such as a
+// database driver, as ErrUnavailable. nil, the errors of this package and
+// the errors in passthrough are returned as is.
+func WrapBackendError(err error, passthrough ...error) error {
+	if err == nil {
+		return nil
+	}
+	for _, known := range [][]error{backendPassthrough, passthrough} {
+		for _, kind := range known {
+			if errors.Is(err, kind) {
+				return err
+			}
+		}
+	}
+	return WrapError(ErrUnavailable, err)
+}
+
+// KeyError is the failure of a single key inside a batch operation.
+type KeyError struct {
+	Key string
+	Err error
+}
+
+func (e KeyError) Error() string {
+	return fmt.Sprintf("key [%s] error: %s", e.Key, e.Err.Error())
+}
+
+func (e KeyError) Unwrap() error {
+	return e.Err
+}
+
+// MultiError collects the per-key failures of GetMulti and DeleteMultiple.
+// errors.Is matches when any of the collected errors matches.
+type MultiError []KeyError
+
+func (m MultiError) Error() string {
+	keysErr := make([]string, len(m))
+	for i, e := range m {
+		keysErr[i] = e.Error()
+	}
+	return strings.Join(keysErr, "; ")
+}
+
+func (m MultiError) Is(target error) bool {
+	for _, e := range m {
+		if errors.Is(e.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrorOrNil returns nil when no key failed.
+func (m MultiError) ErrorOrNil() error {
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorConformance(t *testing.T) {
+	dir := t.TempDir()
+	testCases := []struct {
+		name  string
+		cache Cache
+	}{
+		{
+			name:  "memory",
+			cache: NewMemoryCache(time.Minute),
+		},
+		{
+			name:  "file",
+			cache: NewFileCache(FileCacheWithCachePath(dir)),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := tc.cache.Get("missing")
+			assert.ErrorIs(t, err, ErrNotFound)
+			assert.True(t, IsMiss(err))
+
+			assert.Nil(t, tc.cache.Set("short", "value", 10*time.Millisecond))
+			time.Sleep(20 * time.Millisecond)
+			_, err = tc.cache.Get("short")
+			assert.ErrorIs(t, err, ErrExpired)
+			assert.True(t, IsMiss(err))
+
+			assert.Nil(t, tc.cache.Set("present", "value", time.Minute))
+			vals, err := tc.cache.GetMulti([]string{"present", "missing"})
+			assert.ErrorIs(t, err, ErrNotFound)
+			assert.Equal(t, "value", vals[0])
+			var multi MultiError
+			if assert.True(t, errors.As(err, &multi)) {
+				assert.Len(t, multi, 1)
+				assert.Equal(t, "missing", multi[0].Key)
+			}
+		})
+	}
+}
+
+func TestFileCacheErrorUnavailable(t *testing.T) {
+	blocker := filepath.Join(t.TempDir(), "blocker")
+	assert.Nil(t, os.WriteFile(blocker, []byte("x"), 0o600))
+	c := NewFileCache(FileCacheWithCachePath(blocker))
+	err := c.Set("key", "value", time.Minute)
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.False(t, IsMiss(err))
+}
+
+func TestCacheItemErrorSerialization(t *testing.T) {
+	item := &CacheItem{}
+	_, err := item.GetCacheItem([]byte("{not json"))
+	assert.ErrorIs(t, err, ErrSerialization)
+	_, err = item.GetCacheItem(42)
+	assert.ErrorIs(t, err, ErrSerialization)
+	_, err = item.GetCacheItem(nil)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = item.SetCacheItem(make(chan int), time.Minute)
+	assert.ErrorIs(t, err, ErrSerialization)
+}
+
+func TestWrapError(t *testing.T) {
+	root := errors.New("connection refused")
+	err := WrapError(ErrUnavailable, root)
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.ErrorIs(t, err, root)
+	assert.NotErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, "cache: backend unavailable: connection refused", err.Error())
+	assert.Same(t, err, WrapError(ErrUnavailable, err))
+	assert.Nil(t, WrapError(ErrUnavailable, nil))
+}
+
+func TestWrapBackendError(t *testing.T) {
+	assert.Nil(t, WrapBackendError(nil))
+	driverErr := errors.New("driver: connection reset")
+	assert.ErrorIs(t, WrapBackendError(driverErr), ErrUnavailable)
+	assert.ErrorIs(t, WrapBackendError(driverErr), driverErr)
+	for _, err := range []error{ErrNotFound, ErrSerialization, ErrNotIntegerType, WrapError(ErrExpired, driverErr)} {
+		assert.Equal(t, err, WrapBackendError(err))
+	}
+	conflict := errors.New("driver: conflict")
+	assert.Equal(t, conflict, WrapBackendError(conflict, conflict))
+}
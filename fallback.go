@@ -0,0 +1,340 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultFallbackRecheckInterval is how long a FallbackCache skips a store
+// after it failed.
+const DefaultFallbackRecheckInterval = 5 * time.Second
+
+// maxFallbackStaleKeys bounds the keys a FallbackCache remembers to drop
+// from a store; past it the store is cleared instead.
+const maxFallbackStaleKeys = 1024
+
+// ErrNoHealthyStore is the cause of the ErrUnavailable returned by a
+// FallbackCache when every store is skipped or failed.
+var ErrNoHealthyStore = errors.New("cache: no healthy store")
+
+// FallbackCache serves every operation from the first of its stores that
+// is healthy. A store failing with ErrUnavailable, or with the deadline of
+// the context, is skipped for RecheckInterval and the operation moves on
+// to the next one; once the interval has passed the store is tried again
+// first, so traffic returns to the primary as soon as it recovers. Misses
+// are answers, not failures.
+//
+// Writes go to the serving store, and the other stores drop the key they
+// wrote, so none of them serves a value older than the write when traffic
+// moves to it. A store that is skipped or fails to drop the key remembers
+// it and drops it before it serves again; past maxFallbackStaleKeys keys,
+// or after a Clear, it is cleared instead.
+type FallbackCache struct {
+	// RecheckInterval is how long a failed store is skipped
+	RecheckInterval time.Duration
+	// OnServe is called with the store that served each operation
+	OnServe func(method, store string)
+
+	stores []*fallbackStore
+	l      sync.Mutex
+}
+
+type fallbackStore struct {
+	name      string
+	cache     ContextCache
+	downUntil time.Time
+	served    uint64
+	// stale holds the keys written to other stores, to drop before the
+	// store serves again; staleAll clears it instead
+	stale    map[string]struct{}
+	staleAll bool
+	// pinger is the store when it is a Pinger
+	pinger Pinger
+}
+
+// NewFallbackCache returns a FallbackCache trying primary, then the
+// secondaries in order. The stores are known by their Name.
+func NewFallbackCache(primary Cache, secondaries ...Cache) *FallbackCache {
+	caches := append([]Cache{primary}, secondaries...)
+	return newFallbackCache(uniqueNames(caches), caches)
+}
+
+func newFallbackCache(names []string, caches []Cache) *FallbackCache {
+	f := &FallbackCache{RecheckInterval: DefaultFallbackRecheckInterval}
+	for i, c := range caches {
+		s := &fallbackStore{name: names[i], cache: NewContextCache(c)}
+		s.pinger, _ = c.(Pinger)
+		f.stores = append(f.stores, s)
+	}
+	return f
+}
+
+// Fallback returns a FallbackCache over the stores registered under
+// primary and secondaries. It goes through their Store facades, so it
+// follows Replace and the middlewares of Use.
+func (f *GoCache) Fallback(primary string, secondaries ...string) (*FallbackCache, error) {
+	names := append([]string{primary}, secondaries...)
+	caches := make([]Cache, len(names))
+	for i, name := range names {
+		store, err := f.Store(name)
+		if err != nil {
+			return nil, err
+		}
+		caches[i] = store
+	}
+	return newFallbackCache(names, caches), nil
+}
+
+// Served returns how many operations each store served.
+func (f *FallbackCache) Served() map[string]uint64 {
+	f.l.Lock()
+	defer f.l.Unlock()
+	served := make(map[string]uint64, len(f.stores))
+	for _, s := range f.stores {
+		served[s.name] += s.served
+	}
+	return served
+}
+
+// Active returns the name of the store the next operation goes to first,
+// or "" when every store is skipped.
+func (f *FallbackCache) Active() string {
+	if healthy := f.healthy(); len(healthy) > 0 {
+		return healthy[0].name
+	}
+	return ""
+}
+
+// healthy returns the stores not skipped, in order.
+func (f *FallbackCache) healthy() []*fallbackStore {
+	f.l.Lock()
+	defer f.l.Unlock()
+	now := time.Now()
+	var healthy []*fallbackStore
+	for _, s := range f.stores {
+		if !now.Before(s.downUntil) {
+			healthy = append(healthy, s)
+		}
+	}
+	return healthy
+}
+
+// do runs fn on the healthy stores in order until one does not fail, and
+// returns that one, or nil with the last failure.
+func (f *FallbackCache) do(ctx context.Context, method string, fn func(c ContextCache) error) (*fallbackStore, error) {
+	err := WrapError(ErrUnavailable, ErrNoHealthyStore)
+	for _, s := range f.healthy() {
+		ctxErr := ctx.Err()
+		if ctxErr != nil {
+			return nil, ctxErr
+		}
+		if err = f.refresh(ctx, s); err == nil {
+			err = fn(s.cache)
+		}
+		if isFailure(ctxErr, err) {
+			f.l.Lock()
+			s.downUntil = time.Now().Add(f.RecheckInterval)
+			f.l.Unlock()
+			continue
+		}
+		f.l.Lock()
+		s.served++
+		f.l.Unlock()
+		if f.OnServe != nil {
+			f.OnServe(method, s.name)
+		}
+		return s, err
+	}
+	return nil, err
+}
+
+// refresh drops from s the keys written to other stores since it last
+// served, wrapping the failure to do so in ErrUnavailable.
+func (f *FallbackCache) refresh(ctx context.Context, s *fallbackStore) error {
+	f.l.Lock()
+	keys, all := s.stale, s.staleAll
+	s.stale, s.staleAll = nil, false
+	f.l.Unlock()
+	var err error
+	if all {
+		err = s.cache.ClearContext(ctx)
+	} else {
+		for key := range keys {
+			if err = s.cache.DeleteContext(ctx, key); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		return nil
+	}
+	f.l.Lock()
+	defer f.l.Unlock()
+	if all {
+		s.markStale("", true)
+	}
+	for key := range keys {
+		s.markStale(key, false)
+	}
+	return WrapError(ErrUnavailable, err)
+}
+
+// invalidate drops key, or everything when all is set, from the stores
+// besides served, at once when they are healthy and otherwise before they
+// serve again.
+func (f *FallbackCache) invalidate(ctx context.Context, served *fallbackStore, key string, all bool) {
+	for _, s := range f.stores {
+		if s == served {
+			continue
+		}
+		f.l.Lock()
+		down := time.Now().Before(s.downUntil)
+		f.l.Unlock()
+		var err error
+		switch {
+		case down:
+		case all:
+			err = s.cache.ClearContext(ctx)
+		default:
+			err = s.cache.DeleteContext(ctx, key)
+		}
+		if down || err != nil {
+			f.l.Lock()
+			s.markStale(key, all)
+			f.l.Unlock()
+		}
+	}
+}
+
+// markStale records that s must drop key, or everything when all is set,
+// before it serves again. f.l must be held.
+func (s *fallbackStore) markStale(key string, all bool) {
+	if all || s.staleAll || len(s.stale) >= maxFallbackStaleKeys {
+		s.stale, s.staleAll = nil, true
+		return
+	}
+	if s.stale == nil {
+		s.stale = make(map[string]struct{})
+	}
+	s.stale[key] = struct{}{}
+}
+
+// write runs the write fn of method through do and drops key, or
+// everything when all is set, from the other stores.
+func (f *FallbackCache) write(ctx context.Context, method, key string, all bool, fn func(c ContextCache) error) error {
+	served, err := f.do(ctx, method, fn)
+	if served != nil {
+		f.invalidate(ctx, served, key, all)
+	}
+	return err
+}
+
+func (f *FallbackCache) Name() string {
+	return FallbackCacheName
+}
+
+func (f *FallbackCache) Set(key string, value any, ttl time.Duration) error {
+	return f.SetContext(context.Background(), key, value, ttl)
+}
+
+func (f *FallbackCache) Has(key string) (bool, error) {
+	return f.HasContext(context.Background(), key)
+}
+
+func (f *FallbackCache) GetMulti(keys []string) ([]any, error) {
+	return f.GetMultiContext(context.Background(), keys)
+}
+
+func (f *FallbackCache) Get(key string) (any, error) {
+	return f.GetContext(context.Background(), key)
+}
+
+func (f *FallbackCache) Delete(key string) error {
+	return f.DeleteContext(context.Background(), key)
+}
+
+func (f *FallbackCache) Increment(key string, step int) error {
+	return f.IncrementContext(context.Background(), key, step)
+}
+
+func (f *FallbackCache) Decrement(key string, step int) error {
+	return f.DecrementContext(context.Background(), key, step)
+}
+
+func (f *FallbackCache) Clear() error {
+	return f.ClearContext(context.Background())
+}
+
+func (f *FallbackCache) SetContext(ctx context.Context, key string, value any, ttl time.Duration) error {
+	return f.write(ctx, "Set", key, false, func(c ContextCache) error {
+		return c.SetContext(ctx, key, value, ttl)
+	})
+}
+
+func (f *FallbackCache) HasContext(ctx context.Context, key string) (bool, error) {
+	var ok bool
+	_, err := f.do(ctx, "Has", func(c ContextCache) (err error) {
+		ok, err = c.HasContext(ctx, key)
+		return err
+	})
+	return ok, err
+}
+
+func (f *FallbackCache) GetMultiContext(ctx context.Context, keys []string) ([]any, error) {
+	var values []any
+	_, err := f.do(ctx, "GetMulti", func(c ContextCache) (err error) {
+		values, err = c.GetMultiContext(ctx, keys)
+		return err
+	})
+	return values, err
+}
+
+func (f *FallbackCache) GetContext(ctx context.Context, key string) (any, error) {
+	var val any
+	_, err := f.do(ctx, "Get", func(c ContextCache) (err error) {
+		val, err = c.GetContext(ctx, key)
+		return err
+	})
+	return val, err
+}
+
+func (f *FallbackCache) DeleteContext(ctx context.Context, key string) error {
+	return f.write(ctx, "Delete", key, false, func(c ContextCache) error {
+		return c.DeleteContext(ctx, key)
+	})
+}
+
+func (f *FallbackCache) IncrementContext(ctx context.Context, key string, step int) error {
+	return f.write(ctx, "Increment", key, false, func(c ContextCache) error {
+		return c.IncrementContext(ctx, key, step)
+	})
+}
+
+func (f *FallbackCache) DecrementContext(ctx context.Context, key string, step int) error {
+	return f.write(ctx, "Decrement", key, false, func(c ContextCache) error {
+		return c.DecrementContext(ctx, key, step)
+	})
+}
+
+func (f *FallbackCache) ClearContext(ctx context.Context) error {
+	return f.write(ctx, "Clear", "", true, func(c ContextCache) error {
+		return c.ClearContext(ctx)
+	})
+}
+
+// Ping succeeds when any store that is a Pinger answers, or when no store
+// is a Pinger, and fails when none answers.
+func (f *FallbackCache) Ping(ctx context.Context) error {
+	var err error
+	for _, s := range f.stores {
+		if s.pinger == nil {
+			continue
+		}
+		if err = s.pinger.Ping(ctx); err == nil {
+			return nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFallbackCache(t *testing.T) {
+	primary := &flakyCache{Cache: NewMemoryCache(time.Minute)}
+	secondary := NewMemoryCache(time.Minute)
+	c := New().Extend(primary, "primary").Extend(secondary, "secondary")
+	fb, err := c.Fallback("primary", "secondary")
+	assert.Nil(t, err)
+	fb.RecheckInterval = 50 * time.Millisecond
+	var served []string
+	fb.OnServe = func(method, store string) {
+		served = append(served, method+"@"+store)
+	}
+	assert.Equal(t, FallbackCacheName, fb.Name())
+
+	assert.Nil(t, fb.Set("key1", "primary", time.Minute))
+	// the other stores drop the key written
+	_, err = secondary.Get("key1")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	primary.down.Store(true)
+	assert.Nil(t, fb.Set("key1", "secondary", time.Minute))
+	val, err := fb.Get("key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "secondary", val)
+	assert.Equal(t, "secondary", fb.Active())
+	// the primary is skipped until the recheck
+	calls := primary.calls.Load()
+	_, err = fb.Get("missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, calls, primary.calls.Load())
+
+	// the primary drops the key written during the outage before serving
+	primary.down.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, "primary", fb.Active())
+	_, err = fb.Get("key1")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = primary.Cache.Get("key1")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.Equal(t, []string{"Set@primary", "Set@secondary", "Get@secondary", "Get@secondary", "Get@primary"}, served)
+	assert.Equal(t, map[string]uint64{"primary": 2, "secondary": 3}, fb.Served())
+
+	_, err = c.Fallback("primary", "redis")
+	assert.ErrorIs(t, err, ErrStoreNotFound)
+}
+
+func TestFallbackCacheAllDown(t *testing.T) {
+	a := &flakyCache{Cache: NewMemoryCache(time.Minute)}
+	b := &flakyCache{Cache: NewMemoryCache(time.Minute)}
+	a.down.Store(true)
+	b.down.Store(true)
+	fb := NewFallbackCache(a, b)
+	_, err := fb.Get("key1")
+	assert.ErrorIs(t, err, errBroken)
+	_, err = fb.Get("key1")
+	assert.ErrorIs(t, err, ErrNoHealthyStore)
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.Equal(t, "", fb.Active())
+	assert.ErrorIs(t, fb.Ping(context.Background()), errBroken)
+	b.down.Store(false)
+	assert.Nil(t, fb.Ping(context.Background()))
+	assert.Equal(t, map[string]uint64{"memory": 0, "memory-1": 0}, fb.Served())
+
+	// stores that are not Pingers count as healthy, as in the other wrappers
+	assert.Nil(t, NewFallbackCache(plainCache{NewMemoryCache(time.Minute)}).Ping(context.Background()))
+	assert.ErrorIs(t, NewFallbackCache(plainCache{NewMemoryCache(time.Minute)}, a).Ping(context.Background()), errBroken)
+}
+
+func TestFallbackCacheInvalidatesEveryStore(t *testing.T) {
+	primary, secondary := NewMemoryCache(time.Minute), NewMemoryCache(time.Minute)
+	fb := NewFallbackCache(primary, secondary)
+	assert.Nil(t, primary.Set("key1", "primary", time.Minute))
+	assert.Nil(t, secondary.Set("key1", "secondary", time.Minute))
+	assert.Nil(t, fb.Delete("key1"))
+	for _, c := range []Cache{primary, secondary} {
+		ok, err := c.Has("key1")
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	}
+}
+
+func TestFallbackCacheReplaysInvalidations(t *testing.T) {
+	primary := &flakyCache{Cache: NewMemoryCache(time.Minute)}
+	secondary := NewMemoryCache(time.Minute)
+	fb := NewFallbackCache(primary, secondary)
+	fb.RecheckInterval = 20 * time.Millisecond
+	for _, key := range []string{"key1", "key2"} {
+		assert.Nil(t, primary.Cache.Set(key, "primary", time.Minute))
+	}
+
+	primary.down.Store(true)
+	assert.Nil(t, fb.Delete("key1"))
+	// the primary is skipped, and misses the Clear
+	assert.Nil(t, fb.Clear())
+	primary.down.Store(false)
+	time.Sleep(30 * time.Millisecond)
+	_, err := fb.Get("key2")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = primary.Cache.Get("key2")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -1,28 +1,80 @@
 package cache
 
 import (
+	"context"
 	"crypto/md5"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
 	fileCacheSuffix        = ".bin"
+	fileCacheTempSuffix    = ".tmp"
 	fileCacheTempDirAppend = "gcache"
+	// fileCacheMarker marks a directory as a FileCache store; Clear does
+	// nothing in directories without it
+	fileCacheMarker        = ".gocache"
+	fileCacheMarkerContent = "This directory is managed by github.com/pkg6/go-cache.\n"
 )
 
 var (
 	FileCachePath = os.TempDir()
+	// DefaultFileCacheFileMode is the permission of entry files
+	DefaultFileCacheFileMode os.FileMode = 0o600
+	// DefaultFileCacheDirMode is the permission of the store directories
+	DefaultFileCacheDirMode os.FileMode = 0o700
+
+	ErrUnsafePath = errors.New("cache: refusing to use an unsafe file cache path")
 )
 
 type FileCache struct {
 	Path      string
 	CacheItem ICacheItem
+	// Logger logs the entries the collections and quota evict, and their
+	// failures; nil logs nothing
+	Logger *Logger
+	// Sync fsyncs every entry before it is renamed into place
+	Sync bool
+	// DirSync fsyncs the shard directory after the rename, so the new
+	// entry survives a power loss
+	DirSync bool
+	// Locking serialises writers of the same key, across processes
+	// sharing Path, with advisory file locks
+	Locking bool
+	// StoreLock makes Clear exclude every other writer with a lock on
+	// the whole store
+	StoreLock bool
+	// LockTimeout bounds how long a writer waits for a lock
+	LockTimeout time.Duration
+	// GCInterval runs GC in the background every interval when positive
+	GCInterval time.Duration
+	// GCRate limits GC to this many files per second when positive
+	GCRate int
+	// GCReport receives the outcome of every background GC pass
+	GCReport func(stats FileCacheGCStats, err error)
+	// MaxSize is the byte budget of all entries, unlimited when zero
+	MaxSize int64
+	// MaxFiles is the most entries the store holds, unlimited when zero
+	MaxFiles int
+	// EvictionPolicy picks the entries evicted when over quota
+	EvictionPolicy FileCacheEvictionPolicy
+	// FileMode is the permission of entry files
+	FileMode os.FileMode
+	// DirMode is the permission of the store directories
+	DirMode  os.FileMode
+	index    *fileCacheIndex
+	marked   int32
+	janitor  *Janitor
+	// l guards CacheItem, which SetCacheItem mutates while encoding
+	l sync.Mutex
 }
 type FileCacheOptions func(c *FileCache)
 
@@ -37,14 +89,84 @@ func FileCacheWithCacheItem(cacheItem ICacheItem) FileCacheOptions {
 	}
 }
 
+// FileCacheWithLogger sets the Logger of the store.
+func FileCacheWithLogger(logger *Logger) FileCacheOptions {
+	return func(c *FileCache) {
+		c.Logger = logger
+	}
+}
+
+// FileCacheWithSync configures whether entries are fsynced before they
+// replace the previous version.
+func FileCacheWithSync(sync bool) FileCacheOptions {
+	return func(c *FileCache) {
+		c.Sync = sync
+	}
+}
+
+// FileCacheWithDirSync configures whether the shard directory is fsynced
+// after an entry is renamed into it.
+func FileCacheWithDirSync(sync bool) FileCacheOptions {
+	return func(c *FileCache) {
+		c.DirSync = sync
+	}
+}
+
+// FileCacheWithLocking configures whether writers of the same key take
+// an advisory file lock. It is on by default.
+func FileCacheWithLocking(locking bool) FileCacheOptions {
+	return func(c *FileCache) {
+		c.Locking = locking
+	}
+}
+
+// FileCacheWithStoreLock configures whether Clear locks the whole store,
+// waiting for in-flight writers and holding new ones off until it is done.
+func FileCacheWithStoreLock(storeLock bool) FileCacheOptions {
+	return func(c *FileCache) {
+		c.StoreLock = storeLock
+	}
+}
+
+// FileCacheWithLockTimeout configures how long a writer waits for a lock
+// before failing with ErrUnavailable.
+func FileCacheWithLockTimeout(timeout time.Duration) FileCacheOptions {
+	return func(c *FileCache) {
+		c.LockTimeout = timeout
+	}
+}
+
+// FileCacheWithFileMode configures the permission of entry files.
+func FileCacheWithFileMode(mode os.FileMode) FileCacheOptions {
+	return func(c *FileCache) {
+		c.FileMode = mode
+	}
+}
+
+// FileCacheWithDirMode configures the permission of the store directories.
+func FileCacheWithDirMode(mode os.FileMode) FileCacheOptions {
+	return func(c *FileCache) {
+		c.DirMode = mode
+	}
+}
+
 func NewFileCache(opts ...FileCacheOptions) Cache {
 	c := &FileCache{
-		Path:      FileCachePath,
-		CacheItem: &CacheItem{},
+		Path:        FileCachePath,
+		CacheItem:   &CacheItem{},
+		Locking:     true,
+		LockTimeout: DefaultFileLockTimeout,
+		FileMode:    DefaultFileCacheFileMode,
+		DirMode:     DefaultFileCacheDirMode,
 	}
 	for _, opt := range opts {
 		opt(c)
 	}
+	c.adoptStore()
+	if c.hasQuota() {
+		c.scanIndex()
+	}
+	c.janitor = StartJanitor(c.GCInterval, c.runGC)
 	return c
 }
 func (f *FileCache) Name() string {
@@ -58,98 +180,208 @@ func (f *FileCache) Get(key string) (any, error) {
 	return item.GetData(), nil
 }
 
-func (f *FileCache) Set(key string, val any, ttl time.Duration) error {
-	dataStr, err := f.CacheItem.SetCacheItem(val, ttl)
-	if err != nil {
-		return err
+// GetWithTTL gets key with the ttl left in its envelope.
+func (f *FileCache) GetWithTTL(ctx context.Context, key string) (any, time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
 	}
-	filename, err := f.getCacheKey(key)
+	item, err := f.getCacheItem(key)
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
-	return os.WriteFile(filename, []byte(dataStr), os.ModePerm)
+	return ItemWithTTL(item)
+}
+
+func (f *FileCache) Set(key string, val any, ttl time.Duration) error {
+	var written string
+	err := f.withKeyLock(key, func(filename string) error {
+		written = filename
+		return f.write(filename, val, ttl)
+	})
+	if err == nil {
+		f.enforceQuota(written)
+	}
+	return err
 }
 
 func (f *FileCache) Delete(key string) error {
-	filename, err := f.getCacheKey(key)
+	return f.withKeyLock(key, func(filename string) error {
+		if ok, _ := fileExist(filename); ok {
+			err := os.Remove(filename)
+			if err != nil {
+				return WrapError(ErrUnavailable, fmt.Errorf("can not delete this file cache key-value, key is %s and file name is %s: %w", key, filename, err))
+			}
+		}
+		f.untrack(filename)
+		return nil
+	})
+}
+
+// Clear removes the entries of the store, and only those: it leaves alone
+// anything in Path that does not carry the marker and naming of FileCache,
+// so pointing Path at a shared directory is safe.
+func (f *FileCache) Clear() error {
+	root, err := f.root()
 	if err != nil {
 		return err
 	}
-	if ok, _ := fileExist(filename); ok {
-		err = os.Remove(filename)
+	if ok, _ := fileExist(filepath.Join(root, fileCacheMarker)); !ok {
+		return nil
+	}
+	if f.StoreLock {
+		unlock, err := f.lockFile(filepath.Join(root, fileCacheStoreLock), true)
+		if err != nil {
+			return WrapError(ErrUnavailable, err)
+		}
+		defer unlock()
+	}
+	defer f.resetIndex()
+	shards, err := os.ReadDir(root)
+	if err != nil {
+		return WrapError(ErrUnavailable, err)
+	}
+	for _, shard := range shards {
+		if !shard.IsDir() || !isShardName(shard.Name()) {
+			continue
+		}
+		dir := filepath.Join(root, shard.Name())
+		files, err := os.ReadDir(dir)
 		if err != nil {
-			return fmt.Errorf("can not delete this file cache key-value, key is %s and file name is %s", key, filename)
+			return WrapError(ErrUnavailable, err)
+		}
+		for _, file := range files {
+			if file.IsDir() || !isOwnedFile(file.Name()) {
+				continue
+			}
+			if err := os.Remove(filepath.Join(dir, file.Name())); err != nil && !os.IsNotExist(err) {
+				return WrapError(ErrUnavailable, err)
+			}
 		}
+		// fails, and is meant to, when the shard holds foreign files
+		_ = os.Remove(dir)
 	}
 	return nil
 }
 
-func (f *FileCache) Clear() error {
-	return os.RemoveAll(f.savePath())
-}
-
 func (f *FileCache) GetMulti(keys []string) ([]any, error) {
 	values := make([]any, len(keys))
-	keysErr := make([]string, 0)
+	var keysErr MultiError
 	for i, key := range keys {
 		val, err := f.Get(key)
 		if err != nil {
-			keysErr = append(keysErr, fmt.Sprintf("key [%s] error: %s", key, err.Error()))
+			keysErr = append(keysErr, KeyError{Key: key, Err: err})
 			continue
 		}
 		values[i] = val
 	}
-	if len(keysErr) == 0 {
-		return values, nil
-	}
-	return values, errors.New(strings.Join(keysErr, "; "))
+	return values, keysErr.ErrorOrNil()
 }
 
 func (f *FileCache) DeleteMultiple(keys []string) error {
-	keysErr := make([]string, 0)
+	var keysErr MultiError
 	for _, key := range keys {
 		err := f.Delete(key)
 		if err != nil {
-			keysErr = append(keysErr, fmt.Sprintf("key [%s] error: %s", key, err.Error()))
+			keysErr = append(keysErr, KeyError{Key: key, Err: err})
 		}
 	}
-	if len(keysErr) > 0 {
-		return errors.New(strings.Join(keysErr, "; "))
-	}
-	return nil
+	return keysErr.ErrorOrNil()
 }
 
 func (f *FileCache) Increment(key string, step int) error {
-	item, err := f.getCacheItem(key)
-	if err != nil {
-		return f.Set(key, step, 0)
-	}
-	val, err := Increment(item.GetData(), step)
-	if err != nil {
-		return err
-	}
-	return f.Set(key, val, item.GetTTL())
+	return f.update(key, step, step, Increment)
 }
 
 func (f *FileCache) Decrement(key string, step int) error {
-	item, err := f.getCacheItem(key)
-	if err != nil {
-		return f.Set(key, step, 0)
-	}
-	val, err := Decrement(item.GetData(), step)
-	if err != nil {
-		return err
-	}
-	return f.Set(key, val, item.GetTTL())
+	return f.update(key, step, -step, Decrement)
+}
+
+// Update replaces the value under key with the result of fn while holding
+// the key lock, so concurrent updates from other goroutines and processes
+// sharing Path are not lost. exists is false when the key is missing or
+// expired.
+func (f *FileCache) Update(key string, ttl time.Duration, fn func(val any, exists bool) (any, error)) error {
+	var written string
+	defer func() {
+		if written != "" {
+			f.enforceQuota(written)
+		}
+	}()
+	return f.withKeyLock(key, func(filename string) error {
+		written = filename
+		item, err := f.readItem(filename, key)
+		if err != nil && !IsMiss(err) {
+			return err
+		}
+		var old any
+		if err == nil {
+			old = item.GetData()
+		}
+		val, err := fn(old, err == nil)
+		if err != nil {
+			return err
+		}
+		return f.write(filename, val, ttl)
+	})
+}
+
+// update applies fn to the counter under key while holding the key lock.
+// A missing key is created holding initial.
+func (f *FileCache) update(key string, step, initial int, fn func(originVal any, step int) (any, error)) error {
+	var written string
+	defer func() {
+		if written != "" {
+			f.enforceQuota(written)
+		}
+	}()
+	return f.withKeyLock(key, func(filename string) error {
+		written = filename
+		item, err := f.readItem(filename, key)
+		if IsMiss(err) {
+			return f.write(filename, initial, 0)
+		}
+		if err != nil {
+			return err
+		}
+		val, err := fn(item.GetData(), step)
+		if err != nil {
+			return err
+		}
+		return f.write(filename, val, item.GetTTL())
+	})
 }
 
 func (f *FileCache) Has(key string) (bool, error) {
 	if _, err := f.Get(key); err != nil {
+		if IsMiss(err) {
+			return false, nil
+		}
 		return false, err
 	}
 	return true, nil
 }
 
+// Ping checks that the store directory is safe and writable, by creating
+// and removing a temp file in it.
+func (f *FileCache) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return WrapError(ErrUnavailable, err)
+	}
+	root, err := f.root()
+	if err != nil {
+		return err
+	}
+	if err := ensureDirectory(root, f.DirMode); err != nil {
+		return WrapError(ErrUnavailable, err)
+	}
+	probe, err := os.CreateTemp(root, "ping.*"+fileCacheTempSuffix)
+	if err != nil {
+		return WrapError(ErrUnavailable, err)
+	}
+	_ = probe.Close()
+	return WrapError(ErrUnavailable, os.Remove(probe.Name()))
+}
+
 func (f *FileCache) savePath() string {
 	var paths []string
 	if f.Path == "" || f.Path == os.TempDir() {
@@ -160,13 +392,81 @@ func (f *FileCache) savePath() string {
 	return filepath.Join(paths...)
 }
 
+// root returns savePath after checking it is not a directory the cache
+// must never write into or clear.
+func (f *FileCache) root() (string, error) {
+	root := f.savePath()
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return "", WrapError(ErrUnavailable, err)
+	}
+	if filepath.Dir(abs) == abs {
+		return "", fmt.Errorf("%w: %s is a filesystem root", ErrUnsafePath, root)
+	}
+	if home, err := os.UserHomeDir(); err == nil && filepath.Clean(home) == abs {
+		return "", fmt.Errorf("%w: %s is the home directory", ErrUnsafePath, root)
+	}
+	return root, nil
+}
+
+// ensureMarker marks savePath as a FileCache store, once per FileCache.
+func (f *FileCache) ensureMarker() error {
+	if atomic.LoadInt32(&f.marked) == 1 {
+		return nil
+	}
+	root, err := f.root()
+	if err != nil {
+		return err
+	}
+	if err := ensureDirectory(root, f.DirMode); err != nil {
+		return WrapError(ErrUnavailable, err)
+	}
+	marker := filepath.Join(root, fileCacheMarker)
+	if ok, _ := fileExist(marker); !ok {
+		if err := os.WriteFile(marker, []byte(fileCacheMarkerContent), f.FileMode); err != nil {
+			return WrapError(ErrUnavailable, err)
+		}
+	}
+	atomic.StoreInt32(&f.marked, 1)
+	return nil
+}
+
+// adoptStore marks savePath when it holds entries written before the
+// marker existed, so Clear does not leave them in place.
+func (f *FileCache) adoptStore() {
+	root, err := f.root()
+	if err != nil {
+		return
+	}
+	if ok, _ := fileExist(filepath.Join(root, fileCacheMarker)); ok {
+		return
+	}
+	shards, _ := os.ReadDir(root)
+	for _, shard := range shards {
+		if !shard.IsDir() || !isShardName(shard.Name()) {
+			continue
+		}
+		files, _ := os.ReadDir(filepath.Join(root, shard.Name()))
+		for _, file := range files {
+			if isEntryName(shard.Name(), file.Name()) {
+				_ = f.ensureMarker()
+				return
+			}
+		}
+	}
+}
+
 func (f *FileCache) getCacheKey(key string) (string, error) {
+	root, err := f.root()
+	if err != nil {
+		return "", err
+	}
 	m := md5.New()
 	_, _ = io.WriteString(m, key)
 	keyHash := fmt.Sprintf("%x", m.Sum(nil))
-	path := filepath.Join(f.savePath(), keyHash[0:2])
-	if err := ensureDirectory(path); err != nil {
-		return "", err
+	path := filepath.Join(root, keyHash[0:2])
+	if err := ensureDirectory(path, f.DirMode); err != nil {
+		return "", WrapError(ErrUnavailable, err)
 	}
 	return filepath.Join(path, fmt.Sprintf("%s%s", keyHash, fileCacheSuffix)), nil
 }
@@ -176,17 +476,139 @@ func (f *FileCache) getCacheItem(key string) (item ICacheItem, err error) {
 	if err != nil {
 		return item, err
 	}
+	return f.readItem(filename, key)
+}
+
+func (f *FileCache) readItem(filename, key string) (item ICacheItem, err error) {
 	fileData, err := os.ReadFile(filename)
 	if err != nil {
-		return item, err
+		if os.IsNotExist(err) {
+			return item, fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return item, WrapError(ErrUnavailable, err)
+	}
+	item, err = f.CacheItem.GetCacheItem(fileData)
+	// A torn file, left behind by a crash before the rename was durable,
+	// is a miss rather than a failure: the next Set replaces it.
+	if len(fileData) == 0 || isTornEntry(err) {
+		return item, fmt.Errorf("%w: %s: partially written entry", ErrNotFound, key)
 	}
-	return f.CacheItem.GetCacheItem(fileData)
+	if err == nil {
+		f.touch(filename)
+	}
+	return item, err
+}
+
+// write encodes val and stores it in filename.
+func (f *FileCache) write(filename string, val any, ttl time.Duration) error {
+	if err := f.ensureMarker(); err != nil {
+		return err
+	}
+	var expires time.Time
+	f.l.Lock()
+	dataStr, err := f.CacheItem.SetCacheItem(val, ttl)
+	if err == nil && !f.CacheItem.IsNeverExpires() {
+		expires = f.CacheItem.GetExpirationTime()
+	}
+	f.l.Unlock()
+	if err != nil {
+		return err
+	}
+	if f.MaxSize > 0 && int64(len(dataStr)) > f.MaxSize {
+		return fmt.Errorf("%w: entry has %d bytes, MaxSize is %d", ErrTooLarge, len(dataStr), f.MaxSize)
+	}
+	if err := f.writeFile(filename, []byte(dataStr)); err != nil {
+		return WrapError(ErrUnavailable, err)
+	}
+	f.track(filename, int64(len(dataStr)), expires)
+	return nil
+}
+
+// writeFile replaces filename atomically: the data goes to a temp file in
+// the same directory, which is then renamed over filename, so readers see
+// either the old or the new entry but never a partial one.
+func (f *FileCache) writeFile(filename string, data []byte) error {
+	dir, base := filepath.Split(filename)
+	tmp, err := os.CreateTemp(dir, strings.TrimSuffix(base, fileCacheSuffix)+".*"+fileCacheTempSuffix)
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmpName)
+		}
+	}()
+	if err = tmp.Chmod(f.FileMode); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if f.Sync {
+		if err = tmp.Sync(); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpName, filename); err != nil {
+		return err
+	}
+	if f.DirSync {
+		return syncDir(dir)
+	}
+	return nil
+}
+
+// isOwnedFile reports whether name is an entry, lock or temp file of the
+// store, as opposed to a file someone else put into a shard directory.
+func isOwnedFile(name string) bool {
+	return strings.HasSuffix(name, fileCacheSuffix) ||
+		strings.HasSuffix(name, fileCacheLockSuffix) ||
+		strings.HasSuffix(name, fileCacheTempSuffix)
+}
+
+// isEntryName reports whether name is the name getCacheKey gives to an
+// entry of shard.
+func isEntryName(shard, name string) bool {
+	hash := strings.TrimSuffix(name, fileCacheSuffix)
+	if len(hash) != 2*md5.Size || hash == name || !strings.HasPrefix(hash, shard) {
+		return false
+	}
+	for _, c := range hash {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func isTornEntry(err error) bool {
+	var syntaxErr *json.SyntaxError
+	return errors.As(err, &syntaxErr)
+}
+
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = dir.Sync()
+	if closeErr := dir.Close(); err == nil {
+		err = closeErr
+	}
+	return err
 }
 
-func ensureDirectory(path string) error {
+func ensureDirectory(path string, perm os.FileMode) error {
 	var err error
 	if _, err = os.Stat(path); os.IsNotExist(err) {
-		if err = os.MkdirAll(path, os.ModePerm); err != nil {
+		if err = os.MkdirAll(path, perm); err != nil {
 			return fmt.Errorf("create directory %s err=%v", path, err)
 		}
 	}
@@ -0,0 +1,208 @@
+package cache
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// fileCacheOrphanTempAge is how old a temp file must be before GC treats it
+// as left behind by a crashed writer.
+const fileCacheOrphanTempAge = 10 * time.Minute
+
+// FileCacheGCStats reports what a GC pass found and removed.
+type FileCacheGCStats struct {
+	// Scanned is the number of files looked at
+	Scanned int
+	// Expired is the number of expired or torn entries removed
+	Expired int
+	// Temps is the number of orphaned temp files removed
+	Temps int
+	// Locks is the number of lock files of removed entries cleaned up
+	Locks int
+	// ReclaimedBytes is the size of everything removed
+	ReclaimedBytes int64
+}
+
+// FileCacheWithGCInterval starts a janitor goroutine running GC every
+// interval. Close stops it.
+func FileCacheWithGCInterval(interval time.Duration) FileCacheOptions {
+	return func(c *FileCache) {
+		c.GCInterval = interval
+	}
+}
+
+// FileCacheWithGCRate limits GC to files per second, so a large store is
+// swept without saturating the disk.
+func FileCacheWithGCRate(files int) FileCacheOptions {
+	return func(c *FileCache) {
+		c.GCRate = files
+	}
+}
+
+// FileCacheWithGCReport configures a callback receiving the outcome of
+// every background GC pass.
+func FileCacheWithGCReport(report func(stats FileCacheGCStats, err error)) FileCacheOptions {
+	return func(c *FileCache) {
+		c.GCReport = report
+	}
+}
+
+// GC walks the shard directories once and removes expired entries, entries
+// torn by a crash, orphaned temp files and the lock files left behind by
+// removed entries.
+func (f *FileCache) GC() (stats FileCacheGCStats, err error) {
+	root, err := f.root()
+	if err != nil {
+		return stats, err
+	}
+	if f.Locking && f.StoreLock {
+		unlock, err := f.lockFile(filepath.Join(root, fileCacheStoreLock), false)
+		if err != nil {
+			return stats, WrapError(ErrUnavailable, err)
+		}
+		defer unlock()
+	}
+	shards, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return stats, nil
+	}
+	if err != nil {
+		return stats, WrapError(ErrUnavailable, err)
+	}
+	var throttle <-chan time.Time
+	if f.GCRate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(f.GCRate))
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+	for _, shard := range shards {
+		if !shard.IsDir() || !isShardName(shard.Name()) {
+			continue
+		}
+		dir := filepath.Join(root, shard.Name())
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return stats, WrapError(ErrUnavailable, err)
+		}
+		for _, file := range files {
+			if throttle != nil {
+				select {
+				case <-throttle:
+				case <-f.janitor.Done():
+					return stats, nil
+				}
+			}
+			stats.Scanned++
+			f.collect(filepath.Join(dir, file.Name()), &stats)
+		}
+	}
+	return stats, nil
+}
+
+// collect removes path when it is garbage. Failures are skipped: the file
+// is looked at again on the next pass.
+func (f *FileCache) collect(path string, stats *FileCacheGCStats) {
+	name := filepath.Base(path)
+	switch {
+	case strings.HasSuffix(name, fileCacheTempSuffix):
+		info, err := os.Stat(path)
+		if err != nil || time.Since(info.ModTime()) < fileCacheOrphanTempAge {
+			return
+		}
+		if os.Remove(path) == nil {
+			stats.Temps++
+			stats.ReclaimedBytes += info.Size()
+		}
+	case strings.HasSuffix(name, fileCacheSuffix):
+		_ = f.withEntryLock(path, func(filename string) error {
+			data, err := os.ReadFile(filename)
+			if err != nil {
+				return err
+			}
+			_, err = f.CacheItem.GetCacheItem(data)
+			if !isGarbage(data, err) {
+				return nil
+			}
+			if err := os.Remove(filename); err != nil {
+				return err
+			}
+			f.untrack(filename)
+			stats.Expired++
+			stats.ReclaimedBytes += int64(len(data))
+			return nil
+		})
+	case strings.HasSuffix(name, fileCacheLockSuffix):
+		entry := strings.TrimSuffix(path, fileCacheLockSuffix) + fileCacheSuffix
+		if ok, _ := fileExist(entry); ok {
+			return
+		}
+		fh, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			return
+		}
+		defer func() {
+			_ = fh.Close()
+		}()
+		// Only a lock nobody holds is removed; a waiter that opened it
+		// notices the file is gone and retries on a new one.
+		if ok, _ := tryLockFile(fh, true); !ok {
+			return
+		}
+		defer func() {
+			_ = unlockFile(fh)
+		}()
+		if ok, _ := fileExist(entry); ok {
+			return
+		}
+		info, err := fh.Stat()
+		if err == nil && os.Remove(path) == nil {
+			stats.Locks++
+			stats.ReclaimedBytes += info.Size()
+		}
+	}
+}
+
+// Close stops the background GC, if any.
+func (f *FileCache) Close() error {
+	f.janitor.Stop()
+	return nil
+}
+
+// runGC runs GC for the janitor, logging and reporting its outcome.
+func (f *FileCache) runGC() {
+	stats, err := f.GC()
+	if err != nil {
+		f.Logger.Failure(f.Name(), "GC", err)
+	}
+	f.Logger.Evicted(f.Name(), "expired", stats.Expired)
+	if f.GCReport != nil {
+		f.GCReport(stats, err)
+	}
+}
+
+// isGarbage reports whether an entry can be removed: it has expired, or it
+// is a torn write that reads as a miss.
+func isGarbage(data []byte, err error) bool {
+	if errors.Is(err, ErrExpired) {
+		return true
+	}
+	return len(data) == 0 || isTornEntry(err)
+}
+
+func isShardName(name string) bool {
+	if len(name) != 2 {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileCacheGC(t *testing.T) {
+	dir := t.TempDir()
+	c := NewFileCache(FileCacheWithCachePath(dir)).(*FileCache)
+	assert.Nil(t, c.Set("expired1", "value", 10*time.Millisecond))
+	assert.Nil(t, c.Set("expired2", "value", 10*time.Millisecond))
+	assert.Nil(t, c.Set("live", "value", time.Minute))
+	assert.Nil(t, c.Set("forever", "value", 0))
+
+	live, err := c.getCacheKey("live")
+	assert.Nil(t, err)
+	shard := filepath.Dir(live)
+	orphan := filepath.Join(shard, "orphan.123"+fileCacheTempSuffix)
+	assert.Nil(t, os.WriteFile(orphan, []byte("partial"), 0o600))
+	old := time.Now().Add(-time.Hour)
+	assert.Nil(t, os.Chtimes(orphan, old, old))
+	fresh := filepath.Join(shard, "fresh.123"+fileCacheTempSuffix)
+	assert.Nil(t, os.WriteFile(fresh, []byte("partial"), 0o600))
+	unrelated := filepath.Join(dir, "unrelated.txt")
+	assert.Nil(t, os.WriteFile(unrelated, []byte("keep"), 0o600))
+
+	time.Sleep(20 * time.Millisecond)
+	stats, err := c.GC()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, stats.Expired)
+	assert.Equal(t, 1, stats.Temps)
+	assert.Equal(t, 2, stats.Locks)
+	assert.Greater(t, stats.ReclaimedBytes, int64(len("partial")))
+
+	_, err = os.Stat(orphan)
+	assert.True(t, os.IsNotExist(err))
+	for _, path := range []string{fresh, unrelated, live} {
+		_, err = os.Stat(path)
+		assert.Nil(t, err, path)
+	}
+	for _, key := range []string{"live", "forever"} {
+		val, err := c.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, "value", val)
+	}
+
+	stats, err = c.GC()
+	assert.Nil(t, err)
+	assert.Equal(t, FileCacheGCStats{Scanned: 5}, stats)
+}
+
+func TestFileCacheGCRate(t *testing.T) {
+	c := NewFileCache(FileCacheWithCachePath(t.TempDir()), FileCacheWithGCRate(100)).(*FileCache)
+	for _, key := range []string{"key1", "key2", "key3", "key4", "key5"} {
+		assert.Nil(t, c.Set(key, "value", time.Minute))
+	}
+	start := time.Now()
+	stats, err := c.GC()
+	assert.Nil(t, err)
+	assert.Equal(t, 10, stats.Scanned)
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+}
+
+func TestFileCacheJanitor(t *testing.T) {
+	reports := make(chan FileCacheGCStats, 10)
+	c := NewFileCache(
+		FileCacheWithCachePath(t.TempDir()),
+		FileCacheWithGCInterval(20*time.Millisecond),
+		FileCacheWithGCReport(func(stats FileCacheGCStats, err error) {
+			assert.Nil(t, err)
+			reports <- stats
+		}),
+	).(*FileCache)
+	defer c.Close()
+	assert.Nil(t, c.Set("expired", "value", 10*time.Millisecond))
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case stats := <-reports:
+			if stats.Expired == 1 {
+				assert.Nil(t, c.Close())
+				assert.Nil(t, c.Close())
+				return
+			}
+		case <-deadline:
+			t.Fatal("janitor did not collect the expired entry")
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	fileCacheLockSuffix = ".lock"
+	// fileCacheStoreLock is the lock file of the whole store, in Path
+	fileCacheStoreLock = ".lock"
+)
+
+// DefaultFileLockTimeout is how long FileCache waits for a lock by default.
+var DefaultFileLockTimeout = 10 * time.Second
+
+// withKeyLock runs fn with the entry file of key while holding its lock,
+// and a shared lock on the store when StoreLock is set.
+func (f *FileCache) withKeyLock(key string, fn func(filename string) error) error {
+	filename, err := f.getCacheKey(key)
+	if err != nil {
+		return err
+	}
+	return f.withEntryLock(filename, fn)
+}
+
+// withEntryLock is withKeyLock for callers that only know the entry file.
+func (f *FileCache) withEntryLock(filename string, fn func(filename string) error) error {
+	if !f.Locking {
+		return fn(filename)
+	}
+	if f.StoreLock {
+		unlock, err := f.lockFile(filepath.Join(f.savePath(), fileCacheStoreLock), false)
+		if err != nil {
+			return WrapError(ErrUnavailable, err)
+		}
+		defer unlock()
+	}
+	unlock, err := f.lockFile(strings.TrimSuffix(filename, fileCacheSuffix)+fileCacheLockSuffix, true)
+	if err != nil {
+		return WrapError(ErrUnavailable, err)
+	}
+	defer unlock()
+	return fn(filename)
+}
+
+// lockFile takes an advisory lock on path, creating it if needed, and
+// returns the function releasing it.
+//
+// Locks die with the process holding them, so a crashed worker never leaves
+// a stale lock behind. What can go stale is the lock file itself: when it is
+// removed or replaced while we wait, the lock we end up holding guards an
+// orphaned inode, so we let go and start over on the current file.
+func (f *FileCache) lockFile(path string, exclusive bool) (func(), error) {
+	deadline := time.Now().Add(f.LockTimeout)
+	backoff := time.Millisecond
+	for {
+		if err := ensureDirectory(filepath.Dir(path), f.DirMode); err != nil {
+			return nil, err
+		}
+		fh, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, f.FileMode)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := tryLockFile(fh, exclusive)
+		if err != nil {
+			_ = fh.Close()
+			return nil, fmt.Errorf("lock %s: %w", path, err)
+		}
+		if ok {
+			if isCurrentFile(fh, path) {
+				return func() {
+					_ = unlockFile(fh)
+					_ = fh.Close()
+				}, nil
+			}
+			_ = unlockFile(fh)
+			_ = fh.Close()
+			continue
+		}
+		_ = fh.Close()
+		if f.LockTimeout > 0 && time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out after %s waiting for lock %s", f.LockTimeout, path)
+		}
+		time.Sleep(backoff)
+		if backoff < 50*time.Millisecond {
+			backoff *= 2
+		}
+	}
+}
+
+// isCurrentFile reports whether fh is still the file found at path.
+func isCurrentFile(fh *os.File, path string) bool {
+	held, err := fh.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(held, current)
+}
@@ -0,0 +1,40 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package cache
+
+import (
+	"os"
+	"sync"
+)
+
+// Without flock the locks only exclude goroutines of this process.
+var processLocks = struct {
+	sync.Mutex
+	held map[string]int // -1 exclusive, >0 number of shared holders
+}{held: make(map[string]int)}
+
+func tryLockFile(fh *os.File, exclusive bool) (bool, error) {
+	processLocks.Lock()
+	defer processLocks.Unlock()
+	n := processLocks.held[fh.Name()]
+	switch {
+	case exclusive && n == 0:
+		processLocks.held[fh.Name()] = -1
+	case !exclusive && n >= 0:
+		processLocks.held[fh.Name()] = n + 1
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+func unlockFile(fh *os.File) error {
+	processLocks.Lock()
+	defer processLocks.Unlock()
+	if n := processLocks.held[fh.Name()]; n > 1 {
+		processLocks.held[fh.Name()] = n - 1
+	} else {
+		delete(processLocks.held, fh.Name())
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const fileLockHelperEnv = "GOCACHE_FILE_LOCK_HELPER_DIR"
+
+// TestFileCacheLockHelper is the body of the child processes started by
+// TestFileCacheCrossProcessIncrement.
+func TestFileCacheLockHelper(t *testing.T) {
+	dir := os.Getenv(fileLockHelperEnv)
+	if dir == "" {
+		t.Skip("only runs as a helper process")
+	}
+	c := NewFileCache(FileCacheWithCachePath(dir))
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, c.Increment("counter", 1))
+	}
+}
+
+func TestFileCacheCrossProcessIncrement(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("advisory file locks are process local on " + runtime.GOOS)
+	}
+	dir := t.TempDir()
+	c := NewFileCache(FileCacheWithCachePath(dir))
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cmd := exec.Command(os.Args[0], "-test.run=^TestFileCacheLockHelper$")
+			cmd.Env = append(os.Environ(), fileLockHelperEnv+"="+dir)
+			out, err := cmd.CombinedOutput()
+			assert.Nil(t, err, string(out))
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, c.Increment("counter", 1))
+	}
+	wg.Wait()
+	val, err := c.Get("counter")
+	assert.Nil(t, err)
+	assert.Equal(t, float64(200), val)
+}
+
+func TestFileCacheUpdate(t *testing.T) {
+	c := NewFileCache(FileCacheWithCachePath(t.TempDir())).(*FileCache)
+	appendTo := func(val any, exists bool) (any, error) {
+		if !exists {
+			return "a", nil
+		}
+		return val.(string) + "a", nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, c.Update("key1", time.Minute, appendTo))
+		}()
+	}
+	wg.Wait()
+	val, err := c.Get("key1")
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Repeat("a", 20), val)
+}
+
+func TestFileCacheLockTimeout(t *testing.T) {
+	dir := t.TempDir()
+	c := NewFileCache(FileCacheWithCachePath(dir), FileCacheWithLockTimeout(50*time.Millisecond)).(*FileCache)
+	filename, err := c.getCacheKey("key1")
+	assert.Nil(t, err)
+	unlock, err := c.lockFile(strings.TrimSuffix(filename, fileCacheSuffix)+fileCacheLockSuffix, true)
+	assert.Nil(t, err)
+	err = c.Set("key1", "author", time.Minute)
+	assert.ErrorIs(t, err, ErrUnavailable)
+	unlock()
+	assert.Nil(t, c.Set("key1", "author", time.Minute))
+}
+
+func TestFileCacheStoreLock(t *testing.T) {
+	dir := t.TempDir()
+	c := NewFileCache(FileCacheWithCachePath(dir), FileCacheWithStoreLock(true), FileCacheWithLockTimeout(50*time.Millisecond)).(*FileCache)
+	assert.Nil(t, c.Set("key1", "author", time.Minute))
+	unlock, err := c.lockFile(filepath.Join(dir, fileCacheStoreLock), true)
+	assert.Nil(t, err)
+	assert.ErrorIs(t, c.Set("key2", "author", time.Minute), ErrUnavailable)
+	unlock()
+	assert.Nil(t, c.Clear())
+	ok, err := c.Has("key1")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	_, err = os.Stat(filepath.Join(dir, fileCacheStoreLock))
+	assert.Nil(t, err)
+}
+
+func TestFileCacheStaleLockFile(t *testing.T) {
+	dir := t.TempDir()
+	c := NewFileCache(FileCacheWithCachePath(dir)).(*FileCache)
+	path := filepath.Join(dir, "stale"+fileCacheLockSuffix)
+	unlock, err := c.lockFile(path, true)
+	assert.Nil(t, err)
+	// the lock file vanishes while it is held, e.g. removed by hand
+	assert.Nil(t, os.Remove(path))
+	unlock2, err := c.lockFile(path, true)
+	assert.Nil(t, err)
+	unlock2()
+	unlock()
+}
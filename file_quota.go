@@ -0,0 +1,229 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileCacheEvictionPolicy picks which entries go first when FileCache is
+// over its quota.
+type FileCacheEvictionPolicy int
+
+const (
+	// EvictLeastRecentlyUsed removes the entries read or written longest ago
+	EvictLeastRecentlyUsed FileCacheEvictionPolicy = iota
+	// EvictSoonestExpiring removes the entries closest to their expiration,
+	// never-expiring entries last
+	EvictSoonestExpiring
+)
+
+// FileCacheWithMaxSize keeps the entries of the store under bytes in total.
+func FileCacheWithMaxSize(bytes int64) FileCacheOptions {
+	return func(c *FileCache) {
+		c.MaxSize = bytes
+	}
+}
+
+// FileCacheWithMaxFiles keeps the store under files entries.
+func FileCacheWithMaxFiles(files int) FileCacheOptions {
+	return func(c *FileCache) {
+		c.MaxFiles = files
+	}
+}
+
+// FileCacheWithEvictionPolicy configures which entries are evicted first.
+func FileCacheWithEvictionPolicy(policy FileCacheEvictionPolicy) FileCacheOptions {
+	return func(c *FileCache) {
+		c.EvictionPolicy = policy
+	}
+}
+
+// fileCacheIndex tracks the size, last access and expiration of every
+// entry, so the quota can be enforced without walking the disk. It is
+// rebuilt from a scan when the cache is created and only sees the writes of
+// this process; other processes sharing Path are picked up on restart.
+type fileCacheIndex struct {
+	sync.Mutex
+	entries map[string]*fileCacheEntry
+	size    int64
+}
+
+type fileCacheEntry struct {
+	filename string
+	size     int64
+	accessed time.Time
+	// expires is zero for entries that never expire
+	expires time.Time
+}
+
+// Usage returns the bytes and the number of entries the store holds. It is
+// only tracked when a quota is configured and reports zero otherwise.
+func (f *FileCache) Usage() (bytes int64, files int) {
+	if f.index == nil {
+		return 0, 0
+	}
+	f.index.Lock()
+	defer f.index.Unlock()
+	return f.index.size, len(f.index.entries)
+}
+
+func (f *FileCache) hasQuota() bool {
+	return f.MaxSize > 0 || f.MaxFiles > 0
+}
+
+// scanIndex rebuilds the index from the entries on disk.
+func (f *FileCache) scanIndex() {
+	f.index = &fileCacheIndex{entries: make(map[string]*fileCacheEntry)}
+	root, err := f.root()
+	if err != nil {
+		return
+	}
+	shards, _ := os.ReadDir(root)
+	for _, shard := range shards {
+		if !shard.IsDir() || !isShardName(shard.Name()) {
+			continue
+		}
+		dir := filepath.Join(root, shard.Name())
+		files, _ := os.ReadDir(dir)
+		for _, file := range files {
+			if !strings.HasSuffix(file.Name(), fileCacheSuffix) {
+				continue
+			}
+			filename := filepath.Join(dir, file.Name())
+			data, err := os.ReadFile(filename)
+			if err != nil {
+				continue
+			}
+			item, err := f.CacheItem.GetCacheItem(data)
+			if err != nil && !IsMiss(err) {
+				continue
+			}
+			entry := &fileCacheEntry{filename: filename, size: int64(len(data))}
+			if info, err := file.Info(); err == nil {
+				entry.accessed = info.ModTime()
+			}
+			if item != nil && !item.IsNeverExpires() {
+				entry.expires = item.GetExpirationTime()
+			}
+			f.index.entries[filename] = entry
+			f.index.size += entry.size
+		}
+	}
+}
+
+func (f *FileCache) track(filename string, size int64, expires time.Time) {
+	if f.index == nil {
+		return
+	}
+	f.index.Lock()
+	defer f.index.Unlock()
+	if entry, ok := f.index.entries[filename]; ok {
+		f.index.size -= entry.size
+	}
+	f.index.entries[filename] = &fileCacheEntry{filename: filename, size: size, accessed: time.Now(), expires: expires}
+	f.index.size += size
+}
+
+func (f *FileCache) touch(filename string) {
+	if f.index == nil {
+		return
+	}
+	f.index.Lock()
+	defer f.index.Unlock()
+	if entry, ok := f.index.entries[filename]; ok {
+		entry.accessed = time.Now()
+	}
+}
+
+func (f *FileCache) untrack(filename string) {
+	if f.index == nil {
+		return
+	}
+	f.index.Lock()
+	defer f.index.Unlock()
+	if entry, ok := f.index.entries[filename]; ok {
+		f.index.size -= entry.size
+		delete(f.index.entries, filename)
+	}
+}
+
+func (f *FileCache) resetIndex() {
+	if f.index == nil {
+		return
+	}
+	f.index.Lock()
+	defer f.index.Unlock()
+	f.index.entries = make(map[string]*fileCacheEntry)
+	f.index.size = 0
+}
+
+// enforceQuota evicts entries until the store fits its quota again. keep is
+// the entry just written, which is never evicted: write already refused it
+// when it alone is over MaxSize. It must be called without holding any
+// entry lock.
+func (f *FileCache) enforceQuota(keep string) {
+	if f.index == nil {
+		return
+	}
+	var evicted int
+	for _, victim := range f.victims(keep) {
+		err := f.withEntryLock(victim, func(filename string) error {
+			err := os.Remove(filename)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			f.untrack(filename)
+			return nil
+		})
+		if err != nil {
+			f.Logger.Failure(f.Name(), "Evict", err)
+			continue
+		}
+		evicted++
+	}
+	f.Logger.Evicted(f.Name(), "quota", evicted)
+}
+
+// victims returns the entries to evict, in eviction order.
+func (f *FileCache) victims(keep string) []string {
+	f.index.Lock()
+	defer f.index.Unlock()
+	size, files := f.index.size, len(f.index.entries)
+	if !f.overQuota(size, files) {
+		return nil
+	}
+	entries := make([]*fileCacheEntry, 0, files)
+	for _, entry := range f.index.entries {
+		if entry.filename != keep {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if f.EvictionPolicy == EvictSoonestExpiring && !a.expires.Equal(b.expires) {
+			if a.expires.IsZero() || b.expires.IsZero() {
+				return b.expires.IsZero()
+			}
+			return a.expires.Before(b.expires)
+		}
+		return a.accessed.Before(b.accessed)
+	})
+	var victims []string
+	for _, entry := range entries {
+		if !f.overQuota(size, files) {
+			return victims
+		}
+		victims = append(victims, entry.filename)
+		size -= entry.size
+		files--
+	}
+	return victims
+}
+
+func (f *FileCache) overQuota(size int64, files int) bool {
+	return (f.MaxSize > 0 && size > f.MaxSize) || (f.MaxFiles > 0 && files > f.MaxFiles)
+}
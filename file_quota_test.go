@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileCacheMaxFilesLRU(t *testing.T) {
+	c := NewFileCache(FileCacheWithCachePath(t.TempDir()), FileCacheWithMaxFiles(3)).(*FileCache)
+	for _, key := range []string{"key1", "key2", "key3"} {
+		assert.Nil(t, c.Set(key, "value", time.Minute))
+		time.Sleep(5 * time.Millisecond)
+	}
+	_, err := c.Get("key1")
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set("key4", "value", time.Minute))
+
+	_, files := c.Usage()
+	assert.Equal(t, 3, files)
+	for key, exists := range map[string]bool{"key1": true, "key2": false, "key3": true, "key4": true} {
+		ok, err := c.Has(key)
+		assert.Nil(t, err)
+		assert.Equal(t, exists, ok, key)
+	}
+}
+
+func TestFileCacheMaxSizeSoonestExpiring(t *testing.T) {
+	dir := t.TempDir()
+	probe := NewFileCache(FileCacheWithCachePath(t.TempDir()), FileCacheWithMaxFiles(100)).(*FileCache)
+	assert.Nil(t, probe.Set("probe", "value", time.Minute))
+	entrySize, _ := probe.Usage()
+
+	c := NewFileCache(
+		FileCacheWithCachePath(dir),
+		FileCacheWithMaxSize(3*entrySize+entrySize/2),
+		FileCacheWithEvictionPolicy(EvictSoonestExpiring),
+	).(*FileCache)
+	assert.Nil(t, c.Set("forever", "value", 0))
+	assert.Nil(t, c.Set("late", "value", time.Hour))
+	assert.Nil(t, c.Set("soon", "value", time.Minute))
+	assert.Nil(t, c.Set("new", "value", 2*time.Hour))
+
+	size, files := c.Usage()
+	assert.Equal(t, 3, files)
+	assert.LessOrEqual(t, size, c.MaxSize)
+	ok, err := c.Has("soon")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	for _, key := range []string{"forever", "late", "new"} {
+		ok, err := c.Has(key)
+		assert.Nil(t, err)
+		assert.True(t, ok, key)
+	}
+}
+
+func TestFileCacheUsageRebuiltAtStartup(t *testing.T) {
+	dir := t.TempDir()
+	c := NewFileCache(FileCacheWithCachePath(dir), FileCacheWithMaxFiles(10)).(*FileCache)
+	assert.Nil(t, c.Set("key1", "value", time.Minute))
+	assert.Nil(t, c.Set("key2", "value", 0))
+	size, files := c.Usage()
+
+	reopened := NewFileCache(FileCacheWithCachePath(dir), FileCacheWithMaxFiles(10)).(*FileCache)
+	reopenedSize, reopenedFiles := reopened.Usage()
+	assert.Equal(t, 2, reopenedFiles)
+	assert.Equal(t, files, reopenedFiles)
+	assert.Equal(t, size, reopenedSize)
+
+	assert.Nil(t, reopened.Delete("key1"))
+	_, files = reopened.Usage()
+	assert.Equal(t, 1, files)
+	assert.Nil(t, reopened.Clear())
+	size, files = reopened.Usage()
+	assert.Equal(t, int64(0), size)
+	assert.Equal(t, 0, files)
+
+	unlimited := NewFileCache(FileCacheWithCachePath(dir)).(*FileCache)
+	size, files = unlimited.Usage()
+	assert.Equal(t, int64(0), size)
+	assert.Equal(t, 0, files)
+}
+
+func TestFileCacheMaxSizeRejectsOversizedEntry(t *testing.T) {
+	c := NewFileCache(FileCacheWithCachePath(t.TempDir()), FileCacheWithMaxSize(4096)).(*FileCache)
+	assert.Nil(t, c.Set("key1", "value", time.Minute))
+	assert.Nil(t, c.Set("key2", "value", time.Minute))
+	size, files := c.Usage()
+
+	err := c.Set("big", strings.Repeat("x", 8192), time.Minute)
+	assert.ErrorIs(t, err, ErrTooLarge)
+	ok, err := c.Has("big")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	// nothing was evicted for it
+	bigSize, bigFiles := c.Usage()
+	assert.Equal(t, size, bigSize)
+	assert.Equal(t, files, bigFiles)
+	for _, key := range []string{"key1", "key2"} {
+		ok, err := c.Has(key)
+		assert.Nil(t, err)
+		assert.True(t, ok, key)
+	}
+}
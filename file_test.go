@@ -151,3 +151,144 @@ func TestFileGetContents(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, "text", string(data))
 }
+
+func TestFileCacheAtomicWrite(t *testing.T) {
+	dir := t.TempDir()
+	c := NewFileCache(FileCacheWithCachePath(dir), FileCacheWithSync(true), FileCacheWithDirSync(true))
+	assert.Nil(t, c.Set("key1", "author", time.Minute))
+	assert.Nil(t, c.Set("key1", "author2", time.Minute))
+
+	tmps, err := filepath.Glob(filepath.Join(dir, "*", "*"+fileCacheTempSuffix))
+	assert.Nil(t, err)
+	assert.Empty(t, tmps)
+
+	filename, err := c.(*FileCache).getCacheKey("key1")
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(filename, []byte(`{"data":"auth`), 0o600))
+	_, err = c.Get("key1")
+	assert.ErrorIs(t, err, ErrNotFound)
+	ok, err := c.Has("key1")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	assert.Nil(t, os.WriteFile(filename, nil, 0o600))
+	_, err = c.Get("key1")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestFileCacheConcurrentOverwrite(t *testing.T) {
+	c := NewFileCache(FileCacheWithCachePath(t.TempDir()))
+	assert.Nil(t, c.Set("key1", "value", time.Minute))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			_ = c.Set("key1", "value", time.Minute)
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		val, err := c.Get("key1")
+		assert.Nil(t, err)
+		assert.Equal(t, "value", val)
+	}
+	<-done
+}
+
+func TestFileCacheClearOwnedFiles(t *testing.T) {
+	dir := t.TempDir()
+	foreign := filepath.Join(dir, "notes.txt")
+	assert.Nil(t, os.WriteFile(foreign, []byte("keep"), 0o600))
+	foreignDir := filepath.Join(dir, "ab")
+	assert.Nil(t, os.MkdirAll(foreignDir, 0o700))
+	foreignShard := filepath.Join(foreignDir, "readme.md")
+	assert.Nil(t, os.WriteFile(foreignShard, []byte("keep"), 0o600))
+
+	c := NewFileCache(FileCacheWithCachePath(dir))
+	assert.Nil(t, c.Set("key1", "author", time.Minute))
+	filename, err := c.(*FileCache).getCacheKey("key1")
+	assert.Nil(t, err)
+	assert.Nil(t, c.Clear())
+
+	_, err = os.Stat(filename)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Dir(filename))
+	assert.True(t, os.IsNotExist(err) || filepath.Dir(filename) == foreignDir)
+	for _, path := range []string{foreign, foreignShard, filepath.Join(dir, fileCacheMarker)} {
+		_, err := os.Stat(path)
+		assert.Nil(t, err, path)
+	}
+}
+
+func TestFileCacheClearWithoutMarker(t *testing.T) {
+	dir := t.TempDir()
+	shard := filepath.Join(dir, "ab")
+	assert.Nil(t, os.MkdirAll(shard, 0o700))
+	entry := filepath.Join(shard, "abcdef"+fileCacheSuffix)
+	assert.Nil(t, os.WriteFile(entry, []byte("{}"), 0o600))
+
+	assert.Nil(t, NewFileCache(FileCacheWithCachePath(dir)).Clear())
+	_, err := os.Stat(entry)
+	assert.Nil(t, err)
+}
+
+func TestFileCacheClearStoreWrittenBeforeMarker(t *testing.T) {
+	dir := t.TempDir()
+	c := NewFileCache(FileCacheWithCachePath(dir))
+	assert.Nil(t, c.Set("key1", "author", time.Minute))
+	filename, err := c.(*FileCache).getCacheKey("key1")
+	assert.Nil(t, err)
+	assert.Nil(t, os.Remove(filepath.Join(dir, fileCacheMarker)))
+
+	assert.Nil(t, NewFileCache(FileCacheWithCachePath(dir)).Clear())
+	_, err = os.Stat(filename)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestFileCacheModes(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "store")
+	c := NewFileCache(FileCacheWithCachePath(dir), FileCacheWithFileMode(0o640), FileCacheWithDirMode(0o750))
+	assert.Nil(t, c.Set("key1", "author", time.Minute))
+	filename, err := c.(*FileCache).getCacheKey("key1")
+	assert.Nil(t, err)
+
+	info, err := os.Stat(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0o640), info.Mode().Perm())
+	info, err = os.Stat(filepath.Dir(filename))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0o750)&^umask(), info.Mode().Perm())
+}
+
+func TestFileCacheUnsafePath(t *testing.T) {
+	paths := []string{string(filepath.Separator)}
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, home)
+	}
+	for _, path := range paths {
+		c := NewFileCache(FileCacheWithCachePath(path))
+		assert.ErrorIs(t, c.Set("key1", "author", time.Minute), ErrUnsafePath, path)
+		_, err := c.Get("key1")
+		assert.ErrorIs(t, err, ErrUnsafePath, path)
+		assert.ErrorIs(t, c.Clear(), ErrUnsafePath, path)
+		_, err = c.(*FileCache).GC()
+		assert.ErrorIs(t, err, ErrUnsafePath, path)
+	}
+}
+
+// umask reports the process umask, which MkdirAll is subject to.
+func umask() os.FileMode {
+	dir, err := os.MkdirTemp("", "umask")
+	if err != nil {
+		return 0
+	}
+	defer os.RemoveAll(dir)
+	probe := filepath.Join(dir, "probe")
+	if err := os.Mkdir(probe, 0o777); err != nil {
+		return 0
+	}
+	info, err := os.Stat(probe)
+	if err != nil {
+		return 0
+	}
+	return 0o777 &^ info.Mode().Perm()
+}
@@ -1,20 +1,56 @@
 package cache
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 )
 
+var (
+	ErrStoreNotFound  = errors.New("cache: store not found")
+	ErrDuplicateStore = errors.New("cache: duplicate store name")
+)
+
+// GoCache is a registry of named stores. It is safe for concurrent use,
+// including registering and removing stores while others are in use.
+//
+// Its Cache methods act on the default store, the first one registered
+// unless SetDefault picks another; Store reaches the others.
 type GoCache struct {
-	name  string
-	Maps  map[string]Cache
+	// Maps is a snapshot of the registered stores by name, without the
+	// middlewares of Use, replaced whenever the registry changes.
+	//
+	// Deprecated: read-only and racy while stores are registered
+	// concurrently; use Cache and StoreNames.
+	Maps map[string]Cache
+	// Names is a snapshot of the names of the registered stores in
+	// registration order, replaced whenever the registry changes.
+	//
+	// Deprecated: read-only and racy while stores are registered
+	// concurrently; use StoreNames.
 	Names []string
-	l     sync.RWMutex
+
+	stores      map[string]*store
+	names       []string
+	defaultName string
+	middlewares []Middleware
+	logger      *Logger
+	l           sync.RWMutex
+}
+
+type store struct {
+	// base is the registered store, cache the same wrapped in the
+	// middlewares of Use
+	base  Cache
+	cache Cache
+	// remember serialises the loaders of Remember on this store
+	remember sync.Mutex
 }
 
 func New() *GoCache {
-	return &GoCache{Maps: make(map[string]Cache)}
+	return &GoCache{Maps: make(map[string]Cache), stores: make(map[string]*store)}
 }
 
 func NewCache(caches ...Cache) *GoCache {
@@ -26,49 +62,329 @@ func NewCache(caches ...Cache) *GoCache {
 }
 
 // Extend 扩展
+//
+// Extend registers cache under names[0], or its Name when names is empty.
+// A store already registered under the name is replaced, as by Replace;
+// use Register to be told about the duplicate instead.
 func (f *GoCache) Extend(cache Cache, names ...string) *GoCache {
-	name := cache.Name()
+	f.l.Lock()
+	defer f.l.Unlock()
+	name := storeName(cache, names)
+	if s, ok := f.stores[name]; ok {
+		s.base, s.cache = cache, f.wrap(cache)
+		f.refreshMaps()
+		return f
+	}
+	f.add(name, cache)
+	return f
+}
+
+// Register registers cache under names[0], or its Name when names is
+// empty, and fails with ErrDuplicateStore if the name is taken.
+func (f *GoCache) Register(cache Cache, names ...string) error {
+	f.l.Lock()
+	defer f.l.Unlock()
+	name := storeName(cache, names)
+	if _, ok := f.stores[name]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateStore, name)
+	}
+	f.add(name, cache)
+	return nil
+}
+
+func storeName(cache Cache, names []string) string {
 	if len(names) > 0 {
-		name = names[0]
+		return names[0]
+	}
+	return cache.Name()
+}
+
+// add registers cache under name, which is free. f.l must be held.
+func (f *GoCache) add(name string, cache Cache) {
+	f.stores[name] = &store{base: cache, cache: f.wrap(cache)}
+	f.names = append(f.names, name)
+	if f.defaultName == "" {
+		f.defaultName = name
+	}
+	f.refreshMaps()
+}
+
+// refreshMaps replaces Maps and Names with copies of the registry. f.l must
+// be held.
+func (f *GoCache) refreshMaps() {
+	maps := make(map[string]Cache, len(f.stores))
+	for name, s := range f.stores {
+		maps[name] = s.base
+	}
+	f.Maps, f.Names = maps, append([]string(nil), f.names...)
+}
+
+// Replace swaps the store registered under name for cache. Store facades
+// already handed out use cache from their next call.
+func (f *GoCache) Replace(name string, cache Cache) error {
+	f.l.Lock()
+	defer f.l.Unlock()
+	s, ok := f.stores[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrStoreNotFound, name)
 	}
-	f.Maps[name] = cache
-	f.Names = append(f.Names, name)
+	s.base, s.cache = cache, f.wrap(cache)
+	f.refreshMaps()
+	return nil
+}
+
+// Use appends mws to the middlewares wrapping every store, the first one
+// outermost, and applies them to the stores already registered as well
+// as to those registered later.
+func (f *GoCache) Use(mws ...Middleware) *GoCache {
+	f.l.Lock()
+	defer f.l.Unlock()
+	f.middlewares = append(f.middlewares, mws...)
+	f.rewrap()
 	return f
 }
 
-func (f *GoCache) Cache(name string) (Cache, error) {
-	f.name = name
-	if f.name == "" {
-		if len(f.Names) > 0 {
-			f.name = f.Names[0]
+// SetLogger makes every store log its slow operations and failures on
+// logger, outside the middlewares of Use, along with the failures GoCache
+// itself does not return, like the Delete of Pull. A nil logger stops it.
+func (f *GoCache) SetLogger(logger *Logger) *GoCache {
+	f.l.Lock()
+	defer f.l.Unlock()
+	f.logger = logger
+	f.rewrap()
+	return f
+}
+
+// wrap wraps cache in the logging and the middlewares of Use. f.l must be
+// held.
+func (f *GoCache) wrap(cache Cache) Cache {
+	if f.logger != nil {
+		return Chain(cache, append([]Middleware{WithLogging(f.logger)}, f.middlewares...)...)
+	}
+	return Chain(cache, f.middlewares...)
+}
+
+// rewrap applies wrap to every registered store. f.l must be held.
+func (f *GoCache) rewrap() {
+	for _, s := range f.stores {
+		s.cache = f.wrap(s.base)
+	}
+}
+
+func (f *GoCache) getLogger() *Logger {
+	f.l.RLock()
+	defer f.l.RUnlock()
+	return f.logger
+}
+
+// Remove unregisters the store under name and returns it, without the
+// middlewares of Use. When it was the
+// default, the first remaining store becomes the default.
+func (f *GoCache) Remove(name string) (Cache, error) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	s, ok := f.stores[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrStoreNotFound, name)
+	}
+	delete(f.stores, name)
+	for i, n := range f.names {
+		if n == name {
+			f.names = append(f.names[:i:i], f.names[i+1:]...)
+			break
 		}
 	}
-	if cache, ok := f.Maps[f.name]; ok {
-		return cache, nil
+	if f.defaultName == name {
+		f.defaultName = ""
+		if len(f.names) > 0 {
+			f.defaultName = f.names[0]
+		}
+	}
+	f.refreshMaps()
+	return s.base, nil
+}
+
+// SetDefault makes the store under name the default.
+func (f *GoCache) SetDefault(name string) error {
+	f.l.Lock()
+	defer f.l.Unlock()
+	if _, ok := f.stores[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrStoreNotFound, name)
+	}
+	f.defaultName = name
+	return nil
+}
+
+// StoreNames returns the names of the registered stores in registration
+// order.
+func (f *GoCache) StoreNames() []string {
+	f.l.RLock()
+	defer f.l.RUnlock()
+	return append([]string(nil), f.names...)
+}
+
+// Cache returns the store registered under name, the default one when
+// name is empty, wrapped in the middlewares of Use.
+func (f *GoCache) Cache(name string) (Cache, error) {
+	cache, _, err := f.lookup(name)
+	return cache, err
+}
+
+// Store returns a facade over the store registered under name, the
+// default one when name is empty. The store is looked up on every call,
+// so the facade follows Replace and SetDefault, and fails with
+// ErrStoreNotFound once the store is removed.
+func (f *GoCache) Store(name string) (*Store, error) {
+	if _, _, err := f.lookup(name); err != nil {
+		return nil, err
+	}
+	return &Store{name: name, manager: f}, nil
+}
+
+// lookup returns the store under name, the default one when name is empty.
+func (f *GoCache) lookup(name string) (Cache, *store, error) {
+	f.l.RLock()
+	defer f.l.RUnlock()
+	if name == "" {
+		name = f.defaultName
 	}
-	return nil, fmt.Errorf("unable to find %s cache", name)
+	if s, ok := f.stores[name]; ok {
+		return s.cache, s, nil
+	}
+	if name == "" {
+		return nil, nil, fmt.Errorf("%w: no store registered", ErrStoreNotFound)
+	}
+	return nil, nil, fmt.Errorf("%w: %s", ErrStoreNotFound, name)
+}
+
+func (f *GoCache) defaultStore() *Store {
+	return &Store{manager: f}
 }
 
 // Pull 读取缓存并删除
 func (f *GoCache) Pull(key string) (any, error) {
-	adapter, err := f.Cache("")
+	return f.defaultStore().Pull(key)
+}
+
+func (f *GoCache) Remember(key string, value any, ttl time.Duration) (any, error) {
+	return f.defaultStore().Remember(key, value, ttl)
+}
+
+func (f *GoCache) Set(key string, value any, ttl time.Duration) error {
+	return f.defaultStore().Set(key, value, ttl)
+}
+
+func (f *GoCache) Has(key string) (bool, error) {
+	return f.defaultStore().Has(key)
+}
+
+func (f *GoCache) GetMulti(keys []string) ([]any, error) {
+	return f.defaultStore().GetMulti(keys)
+}
+
+func (f *GoCache) Get(key string) (any, error) {
+	return f.defaultStore().Get(key)
+}
+
+func (f *GoCache) Delete(key string) error {
+	return f.defaultStore().Delete(key)
+}
+
+func (f *GoCache) Increment(key string, step int) error {
+	return f.defaultStore().Increment(key, step)
+}
+
+func (f *GoCache) Decrement(key string, step int) error {
+	return f.defaultStore().Decrement(key, step)
+}
+
+func (f *GoCache) Clear() error {
+	return f.defaultStore().Clear()
+}
+
+func (f *GoCache) SetContext(ctx context.Context, key string, value any, ttl time.Duration) error {
+	return f.defaultStore().SetContext(ctx, key, value, ttl)
+}
+
+func (f *GoCache) HasContext(ctx context.Context, key string) (bool, error) {
+	return f.defaultStore().HasContext(ctx, key)
+}
+
+func (f *GoCache) GetMultiContext(ctx context.Context, keys []string) ([]any, error) {
+	return f.defaultStore().GetMultiContext(ctx, keys)
+}
+
+func (f *GoCache) GetContext(ctx context.Context, key string) (any, error) {
+	return f.defaultStore().GetContext(ctx, key)
+}
+
+func (f *GoCache) DeleteContext(ctx context.Context, key string) error {
+	return f.defaultStore().DeleteContext(ctx, key)
+}
+
+func (f *GoCache) IncrementContext(ctx context.Context, key string, step int) error {
+	return f.defaultStore().IncrementContext(ctx, key, step)
+}
+
+func (f *GoCache) DecrementContext(ctx context.Context, key string, step int) error {
+	return f.defaultStore().DecrementContext(ctx, key, step)
+}
+
+func (f *GoCache) ClearContext(ctx context.Context) error {
+	return f.defaultStore().ClearContext(ctx)
+}
+
+// Store is the facade of one store of a GoCache, returned by
+// GoCache.Store. It implements ContextCache and adds Pull and Remember.
+type Store struct {
+	// name is empty for the default store
+	name    string
+	manager *GoCache
+}
+
+// adapter returns the current store behind the facade.
+func (s *Store) adapter() (Cache, *store, error) {
+	return s.manager.lookup(s.name)
+}
+
+// Name returns the name the store is registered under.
+func (s *Store) Name() string {
+	if s.name != "" {
+		return s.name
+	}
+	s.manager.l.RLock()
+	defer s.manager.l.RUnlock()
+	return s.manager.defaultName
+}
+
+// Pull 读取缓存并删除
+func (s *Store) Pull(key string) (any, error) {
+	adapter, _, err := s.adapter()
 	if err != nil {
 		return nil, err
 	}
 	if val, err := adapter.Get(key); err != nil {
 		return val, err
 	} else {
-		_ = adapter.Delete(key)
+		if err := adapter.Delete(key); err != nil {
+			s.manager.getLogger().Failure(s.Name(), "Pull", err)
+		}
 		return val, nil
 	}
 }
 
-func (f *GoCache) Remember(key string, value any, ttl time.Duration) (any, error) {
-	adapter, err := f.Cache("")
+// Remember returns the value under key, storing value first when it is
+// missing. A value of type func() any is only called then, and never by
+// two goroutines of the process at once for the same store.
+func (s *Store) Remember(key string, value any, ttl time.Duration) (any, error) {
+	adapter, st, err := s.adapter()
 	if err != nil {
 		return nil, err
 	}
-	has, _ := adapter.Has(key)
+	has, err := adapter.Has(key)
+	if err != nil {
+		s.manager.getLogger().Failure(s.Name(), "Remember", err)
+	}
 	if has {
 		if val, err := adapter.Get(key); err != nil {
 			return val, err
@@ -76,8 +392,12 @@ func (f *GoCache) Remember(key string, value any, ttl time.Duration) (any, error
 			return val, nil
 		}
 	}
-	f.l.Lock()
-	defer f.l.Unlock()
+	st.remember.Lock()
+	defer st.remember.Unlock()
+	// another goroutine may have stored it while this one waited
+	if val, err := adapter.Get(key); err == nil {
+		return val, nil
+	}
 	if valFun, ok := value.(func() any); ok {
 		value = valFun()
 	}
@@ -87,66 +407,152 @@ func (f *GoCache) Remember(key string, value any, ttl time.Duration) (any, error
 	return value, nil
 }
 
-func (f *GoCache) Set(key string, value any, ttl time.Duration) error {
-	adapter, err := f.Cache("")
+func (s *Store) Set(key string, value any, ttl time.Duration) error {
+	adapter, _, err := s.adapter()
 	if err != nil {
 		return err
 	}
 	return adapter.Set(key, value, ttl)
 }
 
-func (f *GoCache) Has(key string) (bool, error) {
-	adapter, err := f.Cache("")
+func (s *Store) Has(key string) (bool, error) {
+	adapter, _, err := s.adapter()
 	if err != nil {
 		return false, err
 	}
 	return adapter.Has(key)
 }
 
-func (f *GoCache) GetMulti(keys []string) ([]any, error) {
-	adapter, err := f.Cache("")
+func (s *Store) GetMulti(keys []string) ([]any, error) {
+	adapter, _, err := s.adapter()
 	if err != nil {
 		return nil, err
 	}
 	return adapter.GetMulti(keys)
 }
 
-func (f *GoCache) Get(key string) (any, error) {
-	adapter, err := f.Cache("")
+func (s *Store) Get(key string) (any, error) {
+	adapter, _, err := s.adapter()
 	if err != nil {
 		return nil, err
 	}
 	return adapter.Get(key)
 }
 
-func (f *GoCache) Delete(key string) error {
-	adapter, err := f.Cache("")
+func (s *Store) Delete(key string) error {
+	adapter, _, err := s.adapter()
 	if err != nil {
 		return err
 	}
 	return adapter.Delete(key)
 }
 
-func (f *GoCache) Increment(key string, step int) error {
-	adapter, err := f.Cache("")
+func (s *Store) Increment(key string, step int) error {
+	adapter, _, err := s.adapter()
 	if err != nil {
 		return err
 	}
 	return adapter.Increment(key, step)
 }
 
-func (f *GoCache) Decrement(key string, step int) error {
-	adapter, err := f.Cache("")
+func (s *Store) Decrement(key string, step int) error {
+	adapter, _, err := s.adapter()
 	if err != nil {
 		return err
 	}
 	return adapter.Decrement(key, step)
 }
 
-func (f *GoCache) Clear() error {
-	adapter, err := f.Cache("")
+func (s *Store) Clear() error {
+	adapter, _, err := s.adapter()
 	if err != nil {
 		return err
 	}
 	return adapter.Clear()
 }
+
+func (s *Store) SetContext(ctx context.Context, key string, value any, ttl time.Duration) error {
+	adapter, _, err := s.adapter()
+	if err != nil {
+		return err
+	}
+	return NewContextCache(adapter).SetContext(ctx, key, value, ttl)
+}
+
+func (s *Store) HasContext(ctx context.Context, key string) (bool, error) {
+	adapter, _, err := s.adapter()
+	if err != nil {
+		return false, err
+	}
+	return NewContextCache(adapter).HasContext(ctx, key)
+}
+
+func (s *Store) GetMultiContext(ctx context.Context, keys []string) ([]any, error) {
+	adapter, _, err := s.adapter()
+	if err != nil {
+		return nil, err
+	}
+	return NewContextCache(adapter).GetMultiContext(ctx, keys)
+}
+
+func (s *Store) GetContext(ctx context.Context, key string) (any, error) {
+	adapter, _, err := s.adapter()
+	if err != nil {
+		return nil, err
+	}
+	return NewContextCache(adapter).GetContext(ctx, key)
+}
+
+func (s *Store) DeleteContext(ctx context.Context, key string) error {
+	adapter, _, err := s.adapter()
+	if err != nil {
+		return err
+	}
+	return NewContextCache(adapter).DeleteContext(ctx, key)
+}
+
+func (s *Store) IncrementContext(ctx context.Context, key string, step int) error {
+	adapter, _, err := s.adapter()
+	if err != nil {
+		return err
+	}
+	return NewContextCache(adapter).IncrementContext(ctx, key, step)
+}
+
+func (s *Store) DecrementContext(ctx context.Context, key string, step int) error {
+	adapter, _, err := s.adapter()
+	if err != nil {
+		return err
+	}
+	return NewContextCache(adapter).DecrementContext(ctx, key, step)
+}
+
+func (s *Store) ClearContext(ctx context.Context) error {
+	adapter, _, err := s.adapter()
+	if err != nil {
+		return err
+	}
+	return NewContextCache(adapter).ClearContext(ctx)
+}
+
+// GetWithTTL gets key with its remaining ttl, or fails with ErrTTLUnknown
+// when the store is not a TTLGetter.
+func (s *Store) GetWithTTL(ctx context.Context, key string) (any, time.Duration, error) {
+	adapter, _, err := s.adapter()
+	if err != nil {
+		return nil, 0, err
+	}
+	return getWithTTL(ctx, adapter, key)
+}
+
+// Ping pings the store, or succeeds when it is not a Pinger.
+func (s *Store) Ping(ctx context.Context) error {
+	adapter, _, err := s.adapter()
+	if err != nil {
+		return err
+	}
+	if pinger, ok := adapter.(Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGoCacheStores(t *testing.T) {
+	memory := NewMemoryCache(time.Minute)
+	file := NewFileCache(FileCacheWithCachePath(t.TempDir()))
+	c := NewCache(memory, file)
+	assert.Equal(t, []string{MemoryCacheName, FileCacheName}, c.StoreNames())
+
+	store, err := c.Store(FileCacheName)
+	assert.Nil(t, err)
+	assert.Equal(t, FileCacheName, store.Name())
+	assert.Nil(t, store.Set("key1", "file", time.Minute))
+	assert.Nil(t, c.Set("key1", "memory", time.Minute))
+
+	val, err := store.Pull("key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "file", val)
+	ok, err := store.Has("key1")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	val, err = c.Get("key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "memory", val)
+
+	val, err = store.Remember("key2", func() any { return "loaded" }, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, "loaded", val)
+	_, err = c.Get("key2")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = c.Store("redis")
+	assert.ErrorIs(t, err, ErrStoreNotFound)
+}
+
+func TestGoCacheDefault(t *testing.T) {
+	c := New()
+	assert.ErrorIs(t, c.Set("key1", "value", time.Minute), ErrStoreNotFound)
+
+	c.Extend(NewMemoryCache(time.Minute), "a").Extend(NewMemoryCache(time.Minute), "b")
+	def, err := c.Store("")
+	assert.Nil(t, err)
+	assert.Equal(t, "a", def.Name())
+
+	assert.Nil(t, c.SetDefault("b"))
+	assert.Equal(t, "b", def.Name())
+	assert.Nil(t, c.Set("key1", "b", time.Minute))
+	b, err := c.Cache("b")
+	assert.Nil(t, err)
+	val, err := b.Get("key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "b", val)
+	assert.ErrorIs(t, c.SetDefault("c"), ErrStoreNotFound)
+
+	removed, err := c.Remove("b")
+	assert.Nil(t, err)
+	assert.Equal(t, b, removed)
+	assert.Equal(t, "a", def.Name())
+	assert.Equal(t, []string{"a"}, c.StoreNames())
+	_, err = c.Remove("b")
+	assert.ErrorIs(t, err, ErrStoreNotFound)
+}
+
+func TestGoCacheReplaceAndDuplicates(t *testing.T) {
+	c := New()
+	assert.Nil(t, c.Register(NewMemoryCache(time.Minute)))
+	assert.ErrorIs(t, c.Register(NewMemoryCache(time.Minute)), ErrDuplicateStore)
+	// Extend replaces the store instead, as NewCache(redisA, redisB) did
+	replacement := NewMemoryCache(time.Minute)
+	assert.NotPanics(t, func() {
+		c.Extend(replacement)
+	})
+	assert.Equal(t, []string{MemoryCacheName}, c.StoreNames())
+	assert.Equal(t, []string{MemoryCacheName}, c.Names)
+	assert.Same(t, replacement, c.Maps[MemoryCacheName])
+
+	store, err := c.Store(MemoryCacheName)
+	assert.Nil(t, err)
+	assert.Nil(t, store.Set("key1", "old", time.Minute))
+	assert.Nil(t, c.Replace(MemoryCacheName, NewNullCache()))
+	_, err = store.Get("key1")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, c.Replace("redis", NewNullCache()), ErrStoreNotFound)
+
+	_, err = c.Remove(MemoryCacheName)
+	assert.Nil(t, err)
+	_, err = store.Get("key1")
+	assert.ErrorIs(t, err, ErrStoreNotFound)
+	assert.Empty(t, c.Maps)
+	assert.Empty(t, c.Names)
+}
+
+func TestStoreRememberLoadsOnce(t *testing.T) {
+	c := NewCache(NewMemoryCache(time.Minute))
+	var (
+		wg    sync.WaitGroup
+		loads atomic.Int32
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, err := c.Remember("key1", func() any {
+				loads.Add(1)
+				time.Sleep(10 * time.Millisecond)
+				return "loaded"
+			}, time.Minute)
+			assert.Nil(t, err)
+			assert.Equal(t, "loaded", val)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), loads.Load())
+}
+
+func TestGoCacheConcurrentRegistry(t *testing.T) {
+	c := NewCache(NewMemoryCache(time.Minute))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("store%d", i)
+			for j := 0; j < 50; j++ {
+				assert.Nil(t, c.Register(NewNullCache(), name))
+				store, err := c.Store(name)
+				assert.Nil(t, err)
+				assert.Nil(t, store.Set("key", j, time.Minute))
+				assert.Nil(t, c.Set(name, j, time.Minute))
+				_, err = c.Remove(name)
+				assert.Nil(t, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, []string{MemoryCacheName}, c.StoreNames())
+}
@@ -0,0 +1,168 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHealthTimeout bounds the pings of HealthHandler.
+const DefaultHealthTimeout = 5 * time.Second
+
+// HealthStatus is the state of a store, or of all stores, in a HealthReport.
+type HealthStatus string
+
+const (
+	HealthUp   HealthStatus = "up"
+	HealthDown HealthStatus = "down"
+	// HealthUnknown marks a store that does not implement Pinger; it does
+	// not make the report unhealthy.
+	HealthUnknown HealthStatus = "unknown"
+)
+
+// StoreHealth is the result of pinging one store.
+type StoreHealth struct {
+	Name    string        `json:"name"`
+	Status  HealthStatus  `json:"status"`
+	Error   string        `json:"error,omitempty"`
+	Latency time.Duration `json:"latency_ns"`
+}
+
+// HealthReport is the result of GoCache.Health, in registration order.
+type HealthReport struct {
+	Status HealthStatus  `json:"status"`
+	Stores []StoreHealth `json:"stores"`
+}
+
+// Healthy reports whether no store is down.
+func (r HealthReport) Healthy() bool {
+	return r.Status == HealthUp
+}
+
+// StoreError is the failure of one store of a GoCache.
+type StoreError struct {
+	Store string
+	Err   error
+}
+
+func (e StoreError) Error() string {
+	return fmt.Sprintf("store [%s] error: %s", e.Store, e.Err.Error())
+}
+
+func (e StoreError) Unwrap() error {
+	return e.Err
+}
+
+// StoreErrors collects the per-store failures of GoCache.Close.
+// errors.Is matches when any of the collected errors matches.
+type StoreErrors []StoreError
+
+func (s StoreErrors) Error() string {
+	storesErr := make([]string, len(s))
+	for i, e := range s {
+		storesErr[i] = e.Error()
+	}
+	return strings.Join(storesErr, "; ")
+}
+
+func (s StoreErrors) Is(target error) bool {
+	for _, e := range s {
+		if errors.Is(e.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Health pings every registered store concurrently and reports their
+// status. ctx bounds the pings.
+func (f *GoCache) Health(ctx context.Context) HealthReport {
+	names, caches := f.snapshot()
+	report := HealthReport{Status: HealthUp, Stores: make([]StoreHealth, len(names))}
+	var wg sync.WaitGroup
+	for i := range names {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			report.Stores[i] = ping(ctx, names[i], caches[i])
+		}(i)
+	}
+	wg.Wait()
+	for _, store := range report.Stores {
+		if store.Status == HealthDown {
+			report.Status = HealthDown
+		}
+	}
+	return report
+}
+
+// HealthHandler serves the HealthReport as JSON, with status 200 when it
+// is healthy and 503 otherwise, for use as a readiness probe.
+func (f *GoCache) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), DefaultHealthTimeout)
+		defer cancel()
+		report := f.Health(ctx)
+		w.Header().Set("Content-Type", "application/json")
+		if !report.Healthy() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(report)
+	})
+}
+
+// Close closes every registered store that implements io.Closer, in
+// registration order, and reports the ones that failed. The stores stay
+// registered.
+func (f *GoCache) Close() error {
+	names, caches := f.snapshot()
+	var storesErr StoreErrors
+	for i, cache := range caches {
+		closer, ok := cache.(io.Closer)
+		if !ok {
+			continue
+		}
+		if err := closer.Close(); err != nil {
+			storesErr = append(storesErr, StoreError{Store: names[i], Err: err})
+		}
+	}
+	if len(storesErr) == 0 {
+		return nil
+	}
+	return storesErr
+}
+
+// snapshot returns the registered stores in registration order, without
+// their middlewares.
+func (f *GoCache) snapshot() ([]string, []Cache) {
+	f.l.RLock()
+	defer f.l.RUnlock()
+	caches := make([]Cache, len(f.names))
+	for i, name := range f.names {
+		caches[i] = f.stores[name].base
+	}
+	return append([]string(nil), f.names...), caches
+}
+
+func ping(ctx context.Context, name string, cache Cache) StoreHealth {
+	health := StoreHealth{Name: name, Status: HealthUnknown}
+	pinger, ok := cache.(Pinger)
+	if !ok {
+		return health
+	}
+	start := time.Now()
+	err := pinger.Ping(ctx)
+	health.Latency = time.Since(start)
+	health.Status = HealthUp
+	if err != nil {
+		health.Status = HealthDown
+		health.Error = err.Error()
+	}
+	return health
+}
@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errBroken = errors.New("broken")
+
+// brokenCache fails to ping and to close.
+type brokenCache struct {
+	Cache
+}
+
+func (b brokenCache) Ping(ctx context.Context) error {
+	return WrapError(ErrUnavailable, errBroken)
+}
+
+func (b brokenCache) Close() error {
+	return errBroken
+}
+
+// plainCache hides the Pinger and io.Closer of the cache it wraps.
+type plainCache struct {
+	Cache
+}
+
+func TestGoCacheHealth(t *testing.T) {
+	c := New().
+		Extend(NewMemoryCache(time.Minute)).
+		Extend(NewFileCache(FileCacheWithCachePath(t.TempDir()))).
+		Extend(plainCache{NewNullCache()}, "plain")
+	report := c.Health(context.Background())
+	assert.True(t, report.Healthy())
+	assert.Equal(t, []HealthStatus{HealthUp, HealthUp, HealthUnknown}, []HealthStatus{
+		report.Stores[0].Status, report.Stores[1].Status, report.Stores[2].Status,
+	})
+	assert.Equal(t, FileCacheName, report.Stores[1].Name)
+
+	c.Extend(brokenCache{NewNullCache()}, "broken")
+	report = c.Health(context.Background())
+	assert.False(t, report.Healthy())
+	assert.Equal(t, HealthDown, report.Stores[3].Status)
+	assert.Contains(t, report.Stores[3].Error, "broken")
+
+	rec := httptest.NewRecorder()
+	c.HealthHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	var served HealthReport
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&served))
+	assert.Equal(t, HealthDown, served.Status)
+	assert.Len(t, served.Stores, 4)
+
+	_, err := c.Remove("broken")
+	assert.Nil(t, err)
+	rec = httptest.NewRecorder()
+	c.HealthHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestGoCacheClose(t *testing.T) {
+	recording := NewRecordingCache(NewMemoryCache(time.Minute))
+	c := New().
+		Extend(recording).
+		Extend(brokenCache{NewNullCache()}, "broken").
+		Extend(plainCache{NewNullCache()}, "plain")
+	err := c.Close()
+	assert.ErrorIs(t, err, errBroken)
+	var storesErr StoreErrors
+	assert.ErrorAs(t, err, &storesErr)
+	assert.Equal(t, "broken", storesErr[0].Store)
+	assert.Len(t, recording.(*RecordingCache).CallsTo("Close"), 1)
+
+	_, _ = c.Remove("broken")
+	assert.Nil(t, c.Close())
+}
@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// Janitor runs the background maintenance of a store, such as sweeping
+// expired entries, until it is stopped.
+type Janitor struct {
+	stop chan struct{}
+	once sync.Once
+}
+
+// StartJanitor calls task every interval in a new goroutine. It returns
+// nil, which Stop accepts, when interval is not positive.
+func StartJanitor(interval time.Duration, task func()) *Janitor {
+	if interval <= 0 {
+		return nil
+	}
+	j := &Janitor{stop: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-j.stop:
+				return
+			case <-ticker.C:
+				task()
+			}
+		}
+	}()
+	return j
+}
+
+// Done returns a channel closed once the janitor is stopped, for tasks to
+// give up early. It is nil, never ready, on a nil Janitor.
+func (j *Janitor) Done() <-chan struct{} {
+	if j == nil {
+		return nil
+	}
+	return j.stop
+}
+
+// Stop stops the janitor. It is safe to call more than once, and on nil.
+func (j *Janitor) Stop() {
+	if j == nil {
+		return
+	}
+	j.once.Do(func() {
+		close(j.stop)
+	})
+}
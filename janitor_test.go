@@ -0,0 +1,28 @@
+package cache
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJanitor(t *testing.T) {
+	assert.Nil(t, StartJanitor(0, func() {}))
+	(*Janitor)(nil).Stop()
+	assert.Nil(t, (*Janitor)(nil).Done())
+
+	var runs atomic.Int32
+	j := StartJanitor(10*time.Millisecond, func() {
+		runs.Add(1)
+	})
+	time.Sleep(55 * time.Millisecond)
+	j.Stop()
+	j.Stop()
+	<-j.Done()
+	stopped := runs.Load()
+	assert.GreaterOrEqual(t, stopped, int32(2))
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, stopped, runs.Load())
+}
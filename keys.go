@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// KeyTransformer maps a user key onto a key the backend accepts.
+// It must be deterministic so the same key always lands on the same entry.
+type KeyTransformer func(key string) string
+
+// SafeKey returns a KeyTransformer for backends that reject control
+// characters, spaces and keys longer than maxLen bytes, such as memcached.
+//
+// Spaces, control characters and '%' are percent-escaped, so distinct keys
+// stay distinct. When the escaped key is still longer than maxLen, it is cut
+// and suffixed with the sha256 of the original key.
+func SafeKey(maxLen int) KeyTransformer {
+	return func(key string) string {
+		escaped := escapeKey(key)
+		if maxLen <= 0 || len(escaped) <= maxLen {
+			return escaped
+		}
+		sum := sha256.Sum256([]byte(key))
+		hash := hex.EncodeToString(sum[:])
+		if maxLen <= len(hash)+1 {
+			return hash[:maxLen]
+		}
+		return escaped[:maxLen-len(hash)-1] + "#" + hash
+	}
+}
+
+func escapeKey(key string) string {
+	if !needsEscape(key) {
+		return key
+	}
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	b.Grow(len(key) + 8)
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c <= ' ' || c == 0x7f || c == '%' {
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&0x0f])
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+func needsEscape(key string) bool {
+	for i := 0; i < len(key); i++ {
+		if c := key[i]; c <= ' ' || c == 0x7f || c == '%' {
+			return true
+		}
+	}
+	return false
+}
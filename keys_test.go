@@ -0,0 +1,36 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSafeKey(t *testing.T) {
+	transform := SafeKey(250)
+	long := strings.Repeat("k", 300)
+	testCases := []struct {
+		name string
+		key  string
+		want string
+	}{
+		{name: "unchanged", key: "user:42", want: "user:42"},
+		{name: "unicode", key: "用户:42", want: "用户:42"},
+		{name: "space", key: "user 42", want: "user%2042"},
+		{name: "control", key: "user\n42\x7f", want: "user%0A42%7F"},
+		{name: "percent", key: "user%2042", want: "user%252042"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, transform(tc.key))
+		})
+	}
+
+	hashed := transform(long)
+	assert.Len(t, hashed, 250)
+	assert.Equal(t, hashed, transform(long))
+	assert.True(t, strings.HasPrefix(hashed, strings.Repeat("k", 185)+"#"))
+	assert.NotEqual(t, hashed, transform(long+"k"))
+	assert.Len(t, SafeKey(16)(long), 16)
+}
@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// DefaultSlowThreshold is the duration over which a Logger reports an
+// operation as slow.
+const DefaultSlowThreshold = 100 * time.Millisecond
+
+// Logger logs the events of a store on a slog.Logger: operations slower
+// than SlowThreshold and failures other than misses with their root cause,
+// through WithLogging, and entries evicted by the sweeps of the stores. A
+// nil *Logger logs nothing, so stores call it unconditionally.
+//
+// Keys may hold personal data, so operations are logged with a key_hash
+// attribute rather than the key unless LogKeys is set.
+type Logger struct {
+	Logger *slog.Logger
+	// LogKeys logs the keys of operations as they are
+	LogKeys bool
+	// SlowThreshold of zero disables the slow operation events
+	SlowThreshold time.Duration
+	SlowLevel     slog.Level
+	FailureLevel  slog.Level
+	EvictionLevel slog.Level
+}
+
+// LoggerOptions configures a Logger.
+type LoggerOptions func(l *Logger)
+
+// LoggerWithSlowThreshold sets SlowThreshold; zero disables the slow
+// operation events.
+func LoggerWithSlowThreshold(threshold time.Duration) LoggerOptions {
+	return func(l *Logger) {
+		l.SlowThreshold = threshold
+	}
+}
+
+// LoggerWithLevels sets the levels of the slow operation, failure and
+// eviction events, by default Warn, Error and Debug.
+func LoggerWithLevels(slow, failure, eviction slog.Level) LoggerOptions {
+	return func(l *Logger) {
+		l.SlowLevel = slow
+		l.FailureLevel = failure
+		l.EvictionLevel = eviction
+	}
+}
+
+// LoggerWithKeys sets LogKeys, logging keys in the clear instead of
+// their hash.
+func LoggerWithKeys(logKeys bool) LoggerOptions {
+	return func(l *Logger) {
+		l.LogKeys = logKeys
+	}
+}
+
+// NewLogger returns a Logger logging on logger, slog.Default() when nil.
+func NewLogger(logger *slog.Logger, opts ...LoggerOptions) *Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	l := &Logger{
+		Logger:        logger,
+		SlowThreshold: DefaultSlowThreshold,
+		SlowLevel:     slog.LevelWarn,
+		FailureLevel:  slog.LevelError,
+		EvictionLevel: slog.LevelDebug,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Track logs the operation op of store on key, started at start, when it
+// was slow or failed with *err. WithLogging calls it after every operation.
+func (l *Logger) Track(store, op, key string, start time.Time, err *error) {
+	if l == nil || l.Logger == nil {
+		return
+	}
+	elapsed := time.Since(start)
+	attrs := []slog.Attr{slog.String("store", store), slog.String("op", op)}
+	if key != "" {
+		attrs = append(attrs, l.keyAttr(key))
+	}
+	attrs = append(attrs, slog.Duration("elapsed", elapsed))
+	if err != nil && *err != nil && !IsMiss(*err) {
+		l.Failure(store, op, *err, attrs...)
+		return
+	}
+	if l.SlowThreshold > 0 && elapsed > l.SlowThreshold {
+		l.Logger.LogAttrs(context.Background(), l.SlowLevel, "cache: slow operation", attrs...)
+	}
+}
+
+// Failure logs err, the failure of op on store, with its root cause.
+// attrs replace the store and op attributes when given.
+func (l *Logger) Failure(store, op string, err error, attrs ...slog.Attr) {
+	if l == nil || l.Logger == nil || err == nil {
+		return
+	}
+	if len(attrs) == 0 {
+		attrs = []slog.Attr{slog.String("store", store), slog.String("op", op)}
+	}
+	attrs = append(attrs, slog.String("error", err.Error()))
+	if cause := rootCause(err); cause != err {
+		attrs = append(attrs, slog.String("cause", cause.Error()))
+	}
+	l.Logger.LogAttrs(context.Background(), l.FailureLevel, "cache: operation failed", attrs...)
+}
+
+// Evicted logs that a sweep of store removed count entries for reason,
+// e.g. "expired" or "quota". Nothing is logged when count is zero.
+func (l *Logger) Evicted(store, reason string, count int) {
+	if l == nil || l.Logger == nil || count == 0 {
+		return
+	}
+	l.Logger.LogAttrs(context.Background(), l.EvictionLevel, "cache: entries evicted",
+		slog.String("store", store), slog.String("reason", reason), slog.Int("count", count))
+}
+
+// Sweep runs sweep, the removal of the expired entries of store, and logs
+// its failure and the entries it evicted. Janitors of stores call it.
+func (l *Logger) Sweep(store string, sweep func() (int, error)) {
+	removed, err := sweep()
+	if err != nil {
+		l.Failure(store, "Sweep", err)
+	}
+	l.Evicted(store, "expired", removed)
+}
+
+// keyAttr returns the key attribute of key: the key itself with LogKeys,
+// otherwise the first 8 bytes of its sha256, enough to correlate events.
+func (l *Logger) keyAttr(key string) slog.Attr {
+	if l.LogKeys {
+		return slog.String("key", key)
+	}
+	sum := sha256.Sum256([]byte(key))
+	return slog.String("key_hash", hex.EncodeToString(sum[:8]))
+}
+
+// rootCause follows the single error chain of err to its end.
+func rootCause(err error) error {
+	for {
+		next := errors.Unwrap(err)
+		if next == nil {
+			return err
+		}
+		err = next
+	}
+}
+
+// WithLogging returns a Middleware tracking every operation on l.
+func WithLogging(l *Logger) Middleware {
+	return WithHooks(Hooks{After: func(op *Operation) {
+		l.Track(op.Store, op.Method, op.Key, op.Start, &op.Err)
+	}})
+}
@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// syncBuffer is a bytes.Buffer safe for the sweepers logging concurrently.
+type syncBuffer struct {
+	buf bytes.Buffer
+	l   sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.l.Lock()
+	defer b.l.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.l.Lock()
+	defer b.l.Unlock()
+	return b.buf.String()
+}
+
+// logRecords returns a Logger writing JSON to the returned function, which
+// decodes what was logged so far.
+func logRecords(opts ...LoggerOptions) (*Logger, func() []map[string]any) {
+	var buf syncBuffer
+	handler := slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+	return NewLogger(slog.New(handler), opts...), func() []map[string]any {
+		var records []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if line == "" {
+				continue
+			}
+			var record map[string]any
+			_ = json.Unmarshal([]byte(line), &record)
+			records = append(records, record)
+		}
+		return records
+	}
+}
+
+// failingDeleteCache fails every Delete with an unavailable backend.
+type failingDeleteCache struct {
+	Cache
+}
+
+func (f failingDeleteCache) Delete(key string) error {
+	return WrapError(ErrUnavailable, errBroken)
+}
+
+func TestLoggerTrack(t *testing.T) {
+	logger, records := logRecords(LoggerWithSlowThreshold(time.Millisecond))
+	var nilLogger *Logger
+	err := WrapError(ErrUnavailable, errBroken)
+	nilLogger.Track("memory", "Get", "key1", time.Now(), &err)
+
+	logger.Track("memory", "Get", "key1", time.Now(), &err)
+	miss := ErrNotFound
+	logger.Track("memory", "Get", "key1", time.Now(), &miss)
+	var ok error
+	logger.Track("memory", "Set", "key1", time.Now().Add(-time.Second), &ok)
+	logger.Track("memory", "Set", "key1", time.Now(), &ok)
+
+	logged := records()
+	assert.Len(t, logged, 2)
+	assert.Equal(t, "ERROR", logged[0]["level"])
+	assert.Equal(t, "cache: operation failed", logged[0]["msg"])
+	// keys are hashed unless LogKeys is set
+	assert.Nil(t, logged[0]["key"])
+	assert.Len(t, logged[0]["key_hash"], 16)
+	assert.Equal(t, err.Error(), logged[0]["error"])
+	assert.Equal(t, "broken", logged[0]["cause"])
+	assert.Equal(t, "WARN", logged[1]["level"])
+	assert.Equal(t, "cache: slow operation", logged[1]["msg"])
+	assert.Equal(t, "Set", logged[1]["op"])
+	assert.Equal(t, logged[0]["key_hash"], logged[1]["key_hash"])
+
+	logger, records = logRecords(LoggerWithKeys(true))
+	logger.Track("memory", "Get", "key1", time.Now(), &err)
+	assert.Equal(t, "key1", records()[0]["key"])
+}
+
+func TestWithLoggingLogsOnce(t *testing.T) {
+	logger, records := logRecords()
+	c := NewCache(NewMemoryCache(time.Minute), NewFileCache(FileCacheWithCachePath(t.TempDir()))).SetLogger(logger)
+	for _, name := range []string{MemoryCacheName, FileCacheName} {
+		store, err := c.Store(name)
+		assert.Nil(t, err)
+		assert.Nil(t, store.Set("key1", "author", time.Minute))
+		// Has does not log the Get it is built on
+		ok, err := store.Has("key1")
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		assert.NotNil(t, store.Increment("key1", 1))
+	}
+
+	logged := records()
+	assert.Len(t, logged, 2)
+	for i, name := range []string{MemoryCacheName, FileCacheName} {
+		assert.Equal(t, name, logged[i]["store"])
+		assert.Equal(t, "Increment", logged[i]["op"])
+	}
+}
+
+func TestMemoryCacheLogsEvictions(t *testing.T) {
+	logger, records := logRecords(LoggerWithLevels(slog.LevelWarn, slog.LevelError, slog.LevelInfo))
+	c := NewMemoryCache(20*time.Millisecond, MemoryCacheWithLogger(logger))
+	defer c.(*MemoryCache).Close()
+	assert.Nil(t, c.Set("key1", "author", time.Millisecond))
+	assert.Nil(t, c.Set("key2", "author", time.Millisecond))
+	time.Sleep(100 * time.Millisecond)
+
+	logged := records()
+	assert.NotEmpty(t, logged)
+	assert.Equal(t, "INFO", logged[0]["level"])
+	assert.Equal(t, "cache: entries evicted", logged[0]["msg"])
+	assert.Equal(t, "expired", logged[0]["reason"])
+	assert.Equal(t, float64(2), logged[0]["count"])
+}
+
+func TestGoCacheSetLogger(t *testing.T) {
+	logger, records := logRecords()
+	c := New().Extend(failingDeleteCache{NewMemoryCache(time.Minute)}).SetLogger(logger)
+	assert.Nil(t, c.Set("key1", "author", time.Minute))
+	val, err := c.Pull("key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "author", val)
+	assert.NotNil(t, c.Increment("key1", 1))
+
+	logged := records()
+	assert.Len(t, logged, 3)
+	// the Delete of Pull, from the logging of the store then from Pull
+	assert.Equal(t, "Delete", logged[0]["op"])
+	assert.Equal(t, "Pull", logged[1]["op"])
+	assert.Equal(t, "broken", logged[1]["cause"])
+	assert.Equal(t, "Increment", logged[2]["op"])
+
+	c.SetLogger(nil)
+	assert.NotNil(t, c.Increment("key1", 1))
+	assert.Len(t, records(), 3)
+}
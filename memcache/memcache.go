@@ -1,30 +1,73 @@
 package memcache
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/bradfitz/gomemcache/memcache"
 	"github.com/pkg6/go-cache"
 )
 
+const (
+	// DefaultMaxItemSize is the default item size limit of memcached (-I 1m).
+	DefaultMaxItemSize = 1024 * 1024
+	// maxRelativeExpiration is the largest expiration memcached reads as
+	// seconds from now; larger values must be absolute unix timestamps.
+	maxRelativeExpiration = 60 * 60 * 24 * 30
+	// MaxKeyLength is the longest key memcached accepts.
+	MaxKeyLength = 250
+	// maxCASRetries bounds the compare-and-swap loop of the counters.
+	maxCASRetries = 100
+)
+
 type Cache struct {
-	Memcache *memcache.Client
+	Memcache    *memcache.Client
+	MaxItemSize int
+	CacheItem   cache.ICacheItem
+	// KeyTransformer maps user keys onto keys memcached accepts
+	KeyTransformer cache.KeyTransformer
+	// l guards CacheItem, which SetCacheItem mutates while encoding
+	l sync.Mutex
 }
 type CacheOptions func(c *Cache)
 
+// CacheWithKeyTransformer configures how keys are made legal for memcached.
+// Pass nil to send keys unchanged.
+func CacheWithKeyTransformer(transformer cache.KeyTransformer) CacheOptions {
+	return func(c *Cache) {
+		c.KeyTransformer = transformer
+	}
+}
+
+func CacheWithCacheItem(cacheItem cache.ICacheItem) CacheOptions {
+	return func(c *Cache) {
+		c.CacheItem = cacheItem
+	}
+}
+
 func CacheWithMemcacheClient(memcache *memcache.Client) CacheOptions {
 	return func(c *Cache) {
 		c.Memcache = memcache
 	}
 }
 
+// CacheWithMaxItemSize configures the largest value accepted by the server
+func CacheWithMaxItemSize(size int) CacheOptions {
+	return func(c *Cache) {
+		c.MaxItemSize = size
+	}
+}
+
 // New creates new memcache adapter.
 func New(opts ...CacheOptions) cache.Cache {
 	c := &Cache{
-		Memcache: memcache.New("127.0.0.1:11211"),
+		Memcache:       memcache.New("127.0.0.1:11211"),
+		MaxItemSize:    DefaultMaxItemSize,
+		CacheItem:      &cache.CacheItem{},
+		KeyTransformer: cache.SafeKey(MaxKeyLength),
 	}
 	for _, opt := range opts {
 		opt(c)
@@ -35,64 +78,229 @@ func (m *Cache) Name() string {
 	return cache.MemcacheCacheName
 }
 func (m *Cache) Set(key string, value any, ttl time.Duration) error {
-	item := memcache.Item{Key: key, Expiration: int32(ttl / time.Second)}
-	if v, ok := value.([]byte); ok {
-		item.Value = v
-	} else if str, ok := value.(string); ok {
-		item.Value = []byte(str)
-	} else {
-		return fmt.Errorf("the value must be string or byte[]. key: %s, value:%v", key, value)
+	item, err := m.newItem(key, value, ttl)
+	if err != nil {
+		return err
 	}
-	return m.Memcache.Set(&item)
+	return wrapError(m.Memcache.Set(item))
 }
 
 func (m *Cache) Has(key string) (bool, error) {
-	_, err := m.Get(key)
-	return err == nil, err
+	if _, err := m.Get(key); err != nil {
+		if cache.IsMiss(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
 }
 
 func (m *Cache) GetMulti(keys []string) ([]any, error) {
 	rv := make([]interface{}, len(keys))
-	mv, err := m.Memcache.GetMulti(keys)
+	cacheKeys := make([]string, len(keys))
+	for i, key := range keys {
+		cacheKeys[i] = m.cacheKey(key)
+	}
+	mv, err := m.Memcache.GetMulti(cacheKeys)
 	if err != nil {
-		return rv, fmt.Errorf("could not read multiple key-values from memcache, please check your keys, network and connection. Root cause: %s", err)
+		return rv, cache.WrapError(cache.ErrUnavailable, fmt.Errorf("could not read multiple key-values from memcache, please check your keys, network and connection. Root cause: %w", err))
 	}
-	keysErr := make([]string, 0)
+	var keysErr cache.MultiError
 	for i, ki := range keys {
-		if _, ok := mv[ki]; !ok {
-			keysErr = append(keysErr, fmt.Sprintf("key [%s] error: %s", ki, "key not exist"))
+		mi, ok := mv[cacheKeys[i]]
+		if !ok {
+			keysErr = append(keysErr, cache.KeyError{Key: ki, Err: cache.ErrNotFound})
 			continue
 		}
-		rv[i] = mv[ki].Value
-	}
-	if len(keysErr) == 0 {
-		return rv, nil
+		item, err := m.CacheItem.GetCacheItem(mi.Value)
+		if err != nil {
+			keysErr = append(keysErr, cache.KeyError{Key: ki, Err: err})
+			continue
+		}
+		rv[i] = item.GetData()
 	}
-	return rv, errors.New(strings.Join(keysErr, "; "))
+	return rv, keysErr.ErrorOrNil()
 }
 
 func (m *Cache) Get(key string) (any, error) {
-	if item, err := m.Memcache.Get(key); err == nil {
-		return item.Value, nil
-	} else {
-		return nil, fmt.Errorf("could not read data from memcache, please check your key, network and connection. Root cause: %s", err.Error())
+	_, item, err := m.getCacheItem(key)
+	if err != nil {
+		return nil, err
+	}
+	return item.GetData(), nil
+}
+
+// GetWithTTL gets key with the ttl left in its envelope.
+func (m *Cache) GetWithTTL(ctx context.Context, key string) (any, time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
 	}
+	_, item, err := m.getCacheItem(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	return cache.ItemWithTTL(item)
 }
 
 func (m *Cache) Delete(key string) error {
-	return m.Memcache.Delete(key)
+	if err := m.Memcache.Delete(m.cacheKey(key)); err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+		return wrapError(err)
+	}
+	return nil
 }
 
 func (m *Cache) Increment(key string, step int) error {
-	_, err := m.Memcache.Increment(key, uint64(step))
-	return err
+	return m.update(key, step, step, cache.Increment)
 }
 
 func (m *Cache) Decrement(key string, step int) error {
-	_, err := m.Memcache.Decrement(key, uint64(step))
-	return err
+	return m.update(key, step, -step, cache.Decrement)
 }
 
 func (m *Cache) Clear() error {
-	return m.Memcache.FlushAll()
+	return wrapError(m.Memcache.FlushAll())
+}
+
+// update applies fn to the counter under key with compare-and-swap, so
+// concurrent updates from any number of clients are not lost. A missing
+// key is created holding initial. So is an expired one, which memcached
+// keeps for up to a second after its envelope expires, since expirationOf
+// rounds up: it is swapped on its CasID rather than added.
+func (m *Cache) update(key string, step, initial int, fn func(originVal any, step int) (any, error)) error {
+	for i := 0; i < maxCASRetries; i++ {
+		mi, item, err := m.getCacheItem(key)
+		var (
+			val any = initial
+			ttl time.Duration
+		)
+		switch {
+		case cache.IsMiss(err):
+		case err != nil:
+			return err
+		case item.IsNeverExpires():
+			if val, err = fn(item.GetData(), step); err != nil {
+				return err
+			}
+		default:
+			if ttl = time.Until(item.GetExpirationTime()); ttl <= 0 {
+				ttl = 0
+				break
+			}
+			if val, err = fn(item.GetData(), step); err != nil {
+				return err
+			}
+		}
+		updated, err := m.newItem(key, val, ttl)
+		if err != nil {
+			return err
+		}
+		if mi == nil {
+			err = m.Memcache.Add(updated)
+			if errors.Is(err, memcache.ErrNotStored) {
+				continue
+			}
+			return wrapError(err)
+		}
+		updated.CasID = mi.CasID
+		err = m.Memcache.CompareAndSwap(updated)
+		if errors.Is(err, memcache.ErrCASConflict) || errors.Is(err, memcache.ErrCacheMiss) {
+			continue
+		}
+		return wrapError(err)
+	}
+	return cache.WrapError(cache.ErrUnavailable, fmt.Errorf("key %s: %w", key, memcache.ErrCASConflict))
+}
+
+// newItem encodes value into the cache item envelope. When the key had to
+// be transformed, the envelope keeps the original one for debugging.
+func (m *Cache) newItem(key string, value any, ttl time.Duration) (*memcache.Item, error) {
+	cacheKey := m.cacheKey(key)
+	m.l.Lock()
+	if keyed, ok := m.CacheItem.(cache.IKeyCacheItem); ok {
+		if cacheKey != key {
+			keyed.SetKey(key)
+		} else {
+			keyed.SetKey("")
+		}
+	}
+	valStr, err := m.CacheItem.SetCacheItem(value, ttl)
+	if err != nil {
+		m.l.Unlock()
+		return nil, err
+	}
+	var expiration int32
+	if !m.CacheItem.IsNeverExpires() {
+		expiration = expirationOf(m.CacheItem.GetTTL())
+	}
+	m.l.Unlock()
+	if m.MaxItemSize > 0 && len(valStr) > m.MaxItemSize {
+		return nil, fmt.Errorf("%w: key %s has %d bytes, limit is %d", cache.ErrTooLarge, key, len(valStr), m.MaxItemSize)
+	}
+	return &memcache.Item{Key: cacheKey, Value: []byte(valStr), Expiration: expiration}, nil
+}
+
+// getCacheItem returns the item under key and its envelope. The item is
+// also returned when its envelope fails to decode or has expired.
+func (m *Cache) getCacheItem(key string) (*memcache.Item, cache.ICacheItem, error) {
+	mi, err := m.Memcache.Get(m.cacheKey(key))
+	if err != nil {
+		return nil, nil, wrapError(err)
+	}
+	item, err := m.CacheItem.GetCacheItem(mi.Value)
+	if err != nil {
+		return mi, nil, err
+	}
+	return mi, item, nil
+}
+
+func (m *Cache) cacheKey(key string) string {
+	if m.KeyTransformer == nil {
+		return key
+	}
+	return m.KeyTransformer(key)
+}
+
+// expirationOf converts ttl into memcached's expiration: whole seconds
+// from now up to 30 days, an absolute unix timestamp beyond.
+func expirationOf(ttl time.Duration) int32 {
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	if seconds <= 0 {
+		seconds = 1
+	}
+	if seconds > maxRelativeExpiration {
+		return int32(time.Now().Add(ttl).Unix())
+	}
+	return int32(seconds)
+}
+
+// wrapError maps gomemcache errors onto the shared cache errors. The
+// answers of a healthy server, such as a malformed key or a lost
+// compare-and-swap, are returned as is. Values over MaxItemSize are refused
+// with ErrTooLarge before they are sent.
+func wrapError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, memcache.ErrCacheMiss):
+		return cache.WrapError(cache.ErrNotFound, err)
+	case errors.Is(err, memcache.ErrMalformedKey),
+		errors.Is(err, memcache.ErrNotStored),
+		errors.Is(err, memcache.ErrCASConflict):
+		return err
+	default:
+		return cache.WrapError(cache.ErrUnavailable, err)
+	}
+}
+
+// Ping checks that every server of the client is reachable.
+func (m *Cache) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return cache.WrapError(cache.ErrUnavailable, err)
+	}
+	return wrapError(m.Memcache.Ping())
+}
+
+// Close closes the idle connections of the client.
+func (m *Cache) Close() error {
+	return m.Memcache.Close()
 }
@@ -1,13 +1,16 @@
 package memcache
 
 import (
+	"context"
 	"log"
 	"os"
-	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/pkg6/go-cache"
+	"github.com/pkg6/go-cache/cachetest"
+	"github.com/pkg6/go-cache/memcache/memcachetest"
 
 	"github.com/bradfitz/gomemcache/memcache"
 	"github.com/stretchr/testify/assert"
@@ -19,11 +22,16 @@ type Suite struct {
 	driver string
 	dsn    string
 	cache  cache.Cache
+	server *memcachetest.Server
 }
 
 func (s *Suite) SetupSuite() {
 	t := s.T()
 	maxTryCnt := 10
+	if s.dsn == "" {
+		s.server = memcachetest.NewServer()
+		s.dsn = s.server.Addr
+	}
 	pool := memcache.New(s.dsn)
 	// test connection
 	err := pool.Ping()
@@ -42,6 +50,12 @@ func (s *Suite) SetupSuite() {
 
 }
 
+func (s *Suite) TearDownSuite() {
+	if s.server != nil {
+		_ = s.server.Close()
+	}
+}
+
 type MemcacheCompositionTestSuite struct {
 	Suite
 }
@@ -73,8 +87,7 @@ func (s *MemcacheCompositionTestSuite) TestMemcacheCacheGet() {
 
 			val, err := s.cache.Get(tc.key)
 			assert.Nil(t, err)
-			vs := val.([]byte)
-			assert.Equal(t, tc.value, string(vs))
+			assert.Equal(t, tc.value, val)
 		})
 	}
 }
@@ -118,14 +131,14 @@ func (s *MemcacheCompositionTestSuite) TestMemcacheCacheIncrAndDecr() {
 	testCases := []struct {
 		name            string
 		key             string
-		value           string
+		value           float64
 		timeoutDuration time.Duration
 		wantErr         error
 	}{
 		{
 			name:            "incr and decr",
 			key:             "key",
-			value:           "1",
+			value:           1,
 			timeoutDuration: 5 * time.Second,
 		},
 	}
@@ -133,30 +146,40 @@ func (s *MemcacheCompositionTestSuite) TestMemcacheCacheIncrAndDecr() {
 		s.T().Run(tc.name, func(t *testing.T) {
 			err := s.cache.Set(tc.key, tc.value, tc.timeoutDuration)
 			assert.Nil(t, err)
-			v, _ := strconv.Atoi(tc.value)
 
 			val, err := s.cache.Get(tc.key)
 			assert.Nil(t, err)
-			v1, _ := strconv.Atoi(string(val.([]byte)))
-			assert.Equal(t, v, v1)
+			assert.Equal(t, tc.value, val)
 
 			assert.Nil(t, s.cache.Increment(tc.key, 1))
 
 			val, err = s.cache.Get(tc.key)
 			assert.Nil(t, err)
-			v2, _ := strconv.Atoi(string(val.([]byte)))
-			assert.Equal(t, v1+1, v2)
+			assert.Equal(t, tc.value+1, val)
 
 			assert.Nil(t, s.cache.Decrement(tc.key, 1))
 
 			val, err = s.cache.Get(tc.key)
 			assert.Nil(t, err)
-			v3, _ := strconv.Atoi(string(val.([]byte)))
-			assert.Equal(t, v, v3)
+			assert.Equal(t, tc.value, val)
 		})
 	}
 }
 
+func (s *MemcacheCompositionTestSuite) TestMemcacheCacheIncrAfterExpiry() {
+	// memcached keeps the item up to a second past its envelope
+	assert.Nil(s.T(), s.cache.Set("expired-counter", 1, 300*time.Millisecond))
+	time.Sleep(400 * time.Millisecond)
+	assert.Nil(s.T(), s.cache.Increment("expired-counter", 1))
+	val, err := s.cache.Get("expired-counter")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), float64(1), val)
+	assert.Nil(s.T(), s.cache.Decrement("expired-counter", 3))
+	val, err = s.cache.Get("expired-counter")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), float64(-2), val)
+}
+
 func (s *MemcacheCompositionTestSuite) TestMemcacheCacheDelete() {
 	testCases := []struct {
 		name            string
@@ -210,19 +233,139 @@ func (s *MemcacheCompositionTestSuite) TestMemcacheCacheGetMulti() {
 			assert.Nil(t, err)
 			values := make([]string, 0, len(tc.values))
 			for _, v := range vals {
-				vs := v.([]byte)
-				values = append(values, string(vs))
+				values = append(values, v.(string))
 			}
 			assert.Equal(t, tc.values, values)
 		})
 	}
 }
 
+func (s *MemcacheCompositionTestSuite) TestMemcacheCacheErrors() {
+	t := s.T()
+	_, err := s.cache.Get("key-missing")
+	assert.ErrorIs(t, err, cache.ErrNotFound)
+
+	assert.Nil(t, s.cache.Set("key-present", "author", 5*time.Second))
+	_, err = s.cache.GetMulti([]string{"key-present", "key-missing"})
+	assert.ErrorIs(t, err, cache.ErrNotFound)
+
+	err = s.cache.Set("key-large", make([]byte, DefaultMaxItemSize+1), 5*time.Second)
+	assert.ErrorIs(t, err, cache.ErrTooLarge)
+
+	err = s.cache.Set("key-chan", make(chan int), 5*time.Second)
+	assert.ErrorIs(t, err, cache.ErrSerialization)
+
+	down := New(CacheWithMemcacheClient(memcache.New("127.0.0.1:1")))
+	_, err = down.Get("key")
+	assert.ErrorIs(t, err, cache.ErrUnavailable)
+
+	// answers of a healthy server are not availability failures
+	for _, answer := range []error{memcache.ErrMalformedKey, memcache.ErrNotStored, memcache.ErrCASConflict} {
+		assert.NotErrorIs(t, wrapError(answer), cache.ErrUnavailable, answer.Error())
+	}
+}
+
+func (s *MemcacheCompositionTestSuite) TestMemcacheCacheArbitraryValues() {
+	t := s.T()
+	type user struct {
+		Name string `json:"name"`
+	}
+	assert.Nil(t, s.cache.Set("key-map", map[string]any{"name": "author"}, 5*time.Second))
+	val, err := s.cache.Get("key-map")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]any{"name": "author"}, val)
+
+	assert.Nil(t, s.cache.Set("key-struct", user{Name: "author"}, 5*time.Second))
+	val, err = s.cache.Get("key-struct")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]any{"name": "author"}, val)
+}
+
+func (s *MemcacheCompositionTestSuite) TestMemcacheCacheExpiration() {
+	t := s.T()
+	assert.Nil(t, s.cache.Set("key-forever", "author", 0))
+	assert.Nil(t, s.cache.Set("key-long", "author", 60*24*time.Hour))
+
+	client := memcache.New(s.dsn)
+	item, err := client.Get("key-forever")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(0), item.Expiration)
+	ok, err := s.cache.Has("key-long")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func (s *MemcacheCompositionTestSuite) TestMemcacheCacheKeyTransformer() {
+	t := s.T()
+	keys := []string{"user 42", "line\nbreak", strings.Repeat("long", 100)}
+	for _, key := range keys {
+		assert.Nil(t, s.cache.Set(key, key, 5*time.Second))
+		val, err := s.cache.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, key, val)
+	}
+	vals, err := s.cache.GetMulti(keys)
+	assert.Nil(t, err)
+	assert.Equal(t, []any{keys[0], keys[1], keys[2]}, vals)
+
+	client := memcache.New(s.dsn)
+	stored, err := client.Get(cache.SafeKey(MaxKeyLength)(keys[2]))
+	assert.Nil(t, err)
+	item, err := (&cache.CacheItem{}).GetCacheItem(stored.Value)
+	assert.Nil(t, err)
+	assert.Equal(t, keys[2], item.(cache.IKeyCacheItem).GetKey())
+
+	assert.Nil(t, s.cache.Delete(keys[0]))
+	ok, err := s.cache.Has(keys[0])
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	raw := New(CacheWithMemcacheClient(client), CacheWithKeyTransformer(nil))
+	assert.ErrorIs(t, raw.Set("user 42", "author", 5*time.Second), memcache.ErrMalformedKey)
+}
+
+func (s *MemcacheCompositionTestSuite) TestMemcacheCachePingClose() {
+	t := s.T()
+	c := New(CacheWithMemcacheClient(memcache.New(s.dsn)))
+	assert.Nil(t, c.(*Cache).Ping(context.Background()))
+	assert.Nil(t, c.(*Cache).Close())
+
+	down := New(CacheWithMemcacheClient(memcache.New("127.0.0.1:1")))
+	assert.ErrorIs(t, down.(*Cache).Ping(context.Background()), cache.ErrUnavailable)
+}
+
+func (s *MemcacheCompositionTestSuite) TestMemcacheCacheConformance() {
+	cachetest.RunConformance(s.T(), func(t *testing.T) cache.Cache {
+		c := New(CacheWithMemcacheClient(memcache.New(s.dsn)))
+		t.Cleanup(func() {
+			_ = c.Clear()
+		})
+		return c
+	}, cachetest.WithTTLResolution(time.Second), cachetest.WithoutClearIsolation())
+}
+
+func (s *MemcacheCompositionTestSuite) TestMemcacheCacheLogging() {
+	cachetest.RunLogging(s.T(), func(t *testing.T) cache.Cache {
+		c := New(CacheWithMemcacheClient(memcache.New(s.dsn)))
+		t.Cleanup(func() {
+			_ = c.Clear()
+		})
+		return c
+	})
+}
+
+func TestExpirationOf(t *testing.T) {
+	assert.Equal(t, int32(1), expirationOf(time.Millisecond))
+	assert.Equal(t, int32(2), expirationOf(1500*time.Millisecond))
+	assert.Equal(t, int32(maxRelativeExpiration), expirationOf(30*24*time.Hour))
+	abs := expirationOf(31 * 24 * time.Hour)
+	assert.InDelta(t, time.Now().Add(31*24*time.Hour).Unix(), int64(abs), 1)
+}
+
 func TestSsdbComposition(t *testing.T) {
+	// MEMCACHE_ADDR runs the suite against a real server, e.g. the one in
+	// script/docker-compose.yml; otherwise an in-process server is used.
 	memCacheAddr := os.Getenv("MEMCACHE_ADDR")
-	if memCacheAddr == "" {
-		memCacheAddr = "127.0.0.1:11211"
-	}
 	suite.Run(t, &MemcacheCompositionTestSuite{
 		Suite{
 			driver: "memcache",
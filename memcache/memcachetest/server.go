@@ -0,0 +1,355 @@
+// Package memcachetest provides an in-process memcached server for tests.
+//
+// It speaks the memcached text protocol used by gomemcache and keeps its
+// data in a cache.MemoryCache, so tests run without a real memcached:
+//
+//	srv := memcachetest.NewServer()
+//	defer srv.Close()
+//	c := memcache.New(memcache.CacheWithMemcacheClient(srv.Client()))
+package memcachetest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/pkg6/go-cache"
+)
+
+const (
+	// MaxItemSize mirrors the default item size limit of memcached.
+	MaxItemSize = 1024 * 1024
+	// relativeExpirationLimit is the largest exptime memcached treats as
+	// relative; anything above is an absolute unix timestamp.
+	relativeExpirationLimit = 60 * 60 * 24 * 30
+	maxKeyLength            = 250
+)
+
+// Server is a memcached server listening on a random localhost port.
+type Server struct {
+	// Addr is the host:port the server listens on
+	Addr string
+
+	listener net.Listener
+	wg       sync.WaitGroup
+	store    *cache.MemoryCache
+	// l serialises commands that read and then write an entry
+	l      sync.Mutex
+	cas    uint64
+	connL  sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+type entry struct {
+	flags   uint32
+	value   []byte
+	cas     uint64
+	expires time.Time
+}
+
+// NewServer starts a server on 127.0.0.1 with a random port.
+// It panics if the port cannot be opened, like httptest.NewServer.
+func NewServer() *Server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("memcachetest: failed to listen on a port: %v", err))
+	}
+	s := &Server{
+		Addr:     ln.Addr().String(),
+		listener: ln,
+		store:    cache.NewMemoryCache(time.Minute).(*cache.MemoryCache),
+		conns:    make(map[net.Conn]struct{}),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// Client returns a gomemcache client connected to the server.
+func (s *Server) Client() *memcache.Client {
+	return memcache.New(s.Addr)
+}
+
+// Close shuts the listener and all open connections down.
+func (s *Server) Close() error {
+	s.connL.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.connL.Unlock()
+	err := s.listener.Close()
+	s.wg.Wait()
+	_ = s.store.Close()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.connL.Lock()
+		if s.closed {
+			s.connL.Unlock()
+			_ = conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.connL.Unlock()
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.connL.Lock()
+		delete(s.conns, conn)
+		s.connL.Unlock()
+		_ = conn.Close()
+	}()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			_, _ = w.WriteString("ERROR\r\n")
+		} else if quit := s.exec(r, w, fields); quit {
+			_ = w.Flush()
+			return
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) exec(r *bufio.Reader, w *bufio.Writer, fields []string) (quit bool) {
+	cmd, args := fields[0], fields[1:]
+	switch cmd {
+	case "get", "gets":
+		for _, key := range args {
+			e, ok := s.get(key)
+			if !ok {
+				continue
+			}
+			if cmd == "gets" {
+				_, _ = fmt.Fprintf(w, "VALUE %s %d %d %d\r\n", key, e.flags, len(e.value), e.cas)
+			} else {
+				_, _ = fmt.Fprintf(w, "VALUE %s %d %d\r\n", key, e.flags, len(e.value))
+			}
+			_, _ = w.Write(e.value)
+			_, _ = w.WriteString("\r\n")
+		}
+		_, _ = w.WriteString("END\r\n")
+	case "set", "add", "replace", "append", "prepend", "cas":
+		s.storage(r, w, cmd, args)
+	case "delete":
+		if len(args) < 1 {
+			_, _ = w.WriteString("ERROR\r\n")
+			return
+		}
+		s.l.Lock()
+		_, ok := s.get(args[0])
+		if ok {
+			_ = s.store.Delete(args[0])
+		}
+		s.l.Unlock()
+		if ok {
+			reply(w, args, "DELETED")
+		} else {
+			reply(w, args, "NOT_FOUND")
+		}
+	case "incr", "decr":
+		if len(args) < 2 {
+			_, _ = w.WriteString("ERROR\r\n")
+			return
+		}
+		delta, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			_, _ = w.WriteString("CLIENT_ERROR invalid numeric delta argument\r\n")
+			return
+		}
+		s.l.Lock()
+		defer s.l.Unlock()
+		e, ok := s.get(args[0])
+		if !ok {
+			reply(w, args, "NOT_FOUND")
+			return
+		}
+		n, err := strconv.ParseUint(string(e.value), 10, 64)
+		if err != nil {
+			_, _ = w.WriteString("CLIENT_ERROR cannot increment or decrement non-numeric value\r\n")
+			return
+		}
+		if cmd == "incr" {
+			n += delta
+		} else if delta > n {
+			n = 0
+		} else {
+			n -= delta
+		}
+		e.value = []byte(strconv.FormatUint(n, 10))
+		s.put(args[0], e)
+		reply(w, args, string(e.value))
+	case "touch":
+		if len(args) < 2 {
+			_, _ = w.WriteString("ERROR\r\n")
+			return
+		}
+		exptime, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			_, _ = w.WriteString("CLIENT_ERROR bad command line format\r\n")
+			return
+		}
+		s.l.Lock()
+		defer s.l.Unlock()
+		e, ok := s.get(args[0])
+		if !ok {
+			reply(w, args, "NOT_FOUND")
+			return
+		}
+		e.expires = expiration(exptime)
+		s.put(args[0], e)
+		reply(w, args, "TOUCHED")
+	case "flush_all":
+		_ = s.store.Clear()
+		reply(w, args, "OK")
+	case "version":
+		_, _ = w.WriteString("VERSION 1.6.0-memcachetest\r\n")
+	case "quit":
+		return true
+	default:
+		_, _ = w.WriteString("ERROR\r\n")
+	}
+	return
+}
+
+// storage handles the storage commands:
+// <cmd> <key> <flags> <exptime> <bytes> [<cas unique>] [noreply]
+func (s *Server) storage(r *bufio.Reader, w *bufio.Writer, cmd string, args []string) {
+	want := 4
+	if cmd == "cas" {
+		want = 5
+	}
+	if len(args) < want {
+		_, _ = w.WriteString("ERROR\r\n")
+		return
+	}
+	key := args[0]
+	flags, err1 := strconv.ParseUint(args[1], 10, 32)
+	exptime, err2 := strconv.ParseInt(args[2], 10, 64)
+	size, err3 := strconv.Atoi(args[3])
+	if err1 != nil || err2 != nil || err3 != nil || size < 0 {
+		_, _ = w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return
+	}
+	var casID uint64
+	if cmd == "cas" {
+		var err error
+		if casID, err = strconv.ParseUint(args[4], 10, 64); err != nil {
+			_, _ = w.WriteString("CLIENT_ERROR bad command line format\r\n")
+			return
+		}
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return
+	}
+	data = data[:size]
+	if len(key) > maxKeyLength {
+		_, _ = w.WriteString("CLIENT_ERROR key too long\r\n")
+		return
+	}
+	if size > MaxItemSize {
+		_, _ = w.WriteString("SERVER_ERROR object too large for cache\r\n")
+		return
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+	old, exists := s.get(key)
+	switch {
+	case cmd == "add" && exists,
+		(cmd == "replace" || cmd == "append" || cmd == "prepend") && !exists:
+		reply(w, args, "NOT_STORED")
+		return
+	case cmd == "cas" && !exists:
+		reply(w, args, "NOT_FOUND")
+		return
+	case cmd == "cas" && old.cas != casID:
+		reply(w, args, "EXISTS")
+		return
+	}
+	e := entry{flags: uint32(flags), value: data, expires: expiration(exptime)}
+	switch cmd {
+	case "append":
+		e = old
+		e.value = append(append([]byte(nil), old.value...), data...)
+	case "prepend":
+		e = old
+		e.value = append(append([]byte(nil), data...), old.value...)
+	}
+	s.put(key, e)
+	reply(w, args, "STORED")
+}
+
+func (s *Server) get(key string) (entry, bool) {
+	val, err := s.store.Get(key)
+	if err != nil {
+		return entry{}, false
+	}
+	return val.(entry), true
+}
+
+// put stores e under a fresh cas id; callers hold s.l.
+func (s *Server) put(key string, e entry) {
+	s.cas++
+	e.cas = s.cas
+	var ttl time.Duration
+	if !e.expires.IsZero() {
+		ttl = time.Until(e.expires)
+		if ttl <= 0 {
+			_ = s.store.Delete(key)
+			return
+		}
+	}
+	_ = s.store.Set(key, e, ttl)
+}
+
+// expiration converts a memcached exptime into an absolute time, zero
+// meaning the entry never expires.
+func expiration(exptime int64) time.Time {
+	switch {
+	case exptime == 0:
+		return time.Time{}
+	case exptime < 0:
+		return time.Now().Add(-time.Second)
+	case exptime <= relativeExpirationLimit:
+		return time.Now().Add(time.Duration(exptime) * time.Second)
+	default:
+		return time.Unix(exptime, 0)
+	}
+}
+
+func reply(w *bufio.Writer, args []string, msg string) {
+	if len(args) > 0 && args[len(args)-1] == "noreply" {
+		return
+	}
+	_, _ = w.WriteString(msg + "\r\n")
+}
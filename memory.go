@@ -1,9 +1,7 @@
 package cache
 
 import (
-	"errors"
-	"fmt"
-	"strings"
+	"context"
 	"sync"
 	"time"
 )
@@ -12,15 +10,33 @@ type MemoryCache struct {
 	sync.RWMutex
 	items    map[string]*CacheItem
 	Interval time.Duration
+	// Logger logs the entries the sweeps evict; nil logs nothing
+	Logger  *Logger
+	janitor *Janitor
 }
 
-// NewMemoryCache returns a new MemoryCache.
-func NewMemoryCache(interval time.Duration) Cache {
+type MemoryCacheOptions func(c *MemoryCache)
+
+// MemoryCacheWithLogger sets the Logger of the store.
+func MemoryCacheWithLogger(logger *Logger) MemoryCacheOptions {
+	return func(c *MemoryCache) {
+		c.Logger = logger
+	}
+}
+
+// NewMemoryCache returns a new MemoryCache sweeping expired keys every
+// interval, or never when it is not positive. Close stops the sweeps.
+func NewMemoryCache(interval time.Duration, opts ...MemoryCacheOptions) Cache {
 	c := &MemoryCache{
 		Interval: interval,
 		items:    make(map[string]*CacheItem),
 	}
-	go c.ClearExpiredKeys()
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.janitor = StartJanitor(c.Interval, func() {
+		c.Logger.Sweep(c.Name(), c.sweep)
+	})
 	return c
 }
 
@@ -31,6 +47,11 @@ func (m *MemoryCache) Name() string {
 func (m *MemoryCache) Set(key string, value any, ttl time.Duration) error {
 	m.Lock()
 	defer m.Unlock()
+	m.set(key, value, ttl)
+	return nil
+}
+
+func (m *MemoryCache) set(key string, value any, ttl time.Duration) {
 	item := &CacheItem{Data: value, JoinTime: time.Now()}
 	item.TTL = ttl
 	if item.TTL == time.Duration(0) {
@@ -38,11 +59,13 @@ func (m *MemoryCache) Set(key string, value any, ttl time.Duration) error {
 	}
 	item.ExpirationTime = item.JoinTime.Add(item.TTL)
 	m.items[key] = item
-	return nil
 }
 
 func (m *MemoryCache) Has(key string) (bool, error) {
-	if _, err := m.Get(key); err == nil {
+	if _, err := m.Get(key); err != nil {
+		if IsMiss(err) {
+			return false, nil
+		}
 		return false, err
 	}
 	return true, nil
@@ -50,19 +73,16 @@ func (m *MemoryCache) Has(key string) (bool, error) {
 
 func (m *MemoryCache) GetMulti(keys []string) ([]any, error) {
 	rc := make([]interface{}, len(keys))
-	keysErr := make([]string, 0)
+	var keysErr MultiError
 	for i, ki := range keys {
 		val, err := m.Get(ki)
 		if err != nil {
-			keysErr = append(keysErr, fmt.Sprintf("key [%s] error: %s", ki, err.Error()))
+			keysErr = append(keysErr, KeyError{Key: ki, Err: err})
 			continue
 		}
 		rc[i] = val
 	}
-	if len(keysErr) == 0 {
-		return rc, nil
-	}
-	return rc, errors.New(strings.Join(keysErr, "; "))
+	return rc, keysErr.ErrorOrNil()
 }
 
 func (m *MemoryCache) Get(key string) (any, error) {
@@ -70,11 +90,24 @@ func (m *MemoryCache) Get(key string) (any, error) {
 	defer m.RUnlock()
 	if item, ok := m.items[key]; ok {
 		if item.ExpirationTime.Before(time.Now()) {
-			return nil, ErrKeyExpired
+			return nil, ErrExpired
 		}
 		return item.Data, nil
 	}
-	return nil, ErrKeyNotExist
+	return nil, ErrNotFound
+}
+
+// GetWithTTL gets key with the ttl it has left.
+func (m *MemoryCache) GetWithTTL(ctx context.Context, key string) (any, time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	m.RLock()
+	defer m.RUnlock()
+	if item, ok := m.items[key]; ok {
+		return ItemWithTTL(item)
+	}
+	return nil, 0, ErrNotFound
 }
 
 func (m *MemoryCache) Delete(key string) error {
@@ -88,8 +121,9 @@ func (m *MemoryCache) Increment(key string, step int) error {
 	m.Lock()
 	defer m.Unlock()
 	itm, ok := m.items[key]
-	if !ok {
-		return m.Set(key, step, 0)
+	if !ok || itm.ExpirationTime.Before(time.Now()) {
+		m.set(key, step, 0)
+		return nil
 	}
 	val, err := Increment(itm.Data, step)
 	if err != nil {
@@ -103,8 +137,9 @@ func (m *MemoryCache) Decrement(key string, step int) error {
 	m.Lock()
 	defer m.Unlock()
 	itm, ok := m.items[key]
-	if !ok {
-		return m.Set(key, step, 0)
+	if !ok || itm.ExpirationTime.Before(time.Now()) {
+		m.set(key, -step, 0)
+		return nil
 	}
 	val, err := Decrement(itm.Data, step)
 	if err != nil {
@@ -114,6 +149,20 @@ func (m *MemoryCache) Decrement(key string, step int) error {
 	return nil
 }
 
+// Keys returns the keys that have not expired yet.
+func (m *MemoryCache) Keys() []string {
+	m.RLock()
+	defer m.RUnlock()
+	now := time.Now()
+	keys := make([]string, 0, len(m.items))
+	for key, item := range m.items {
+		if item.ExpirationTime.After(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
 func (m *MemoryCache) Clear() error {
 	m.Lock()
 	defer m.Unlock()
@@ -121,19 +170,35 @@ func (m *MemoryCache) Clear() error {
 	return nil
 }
 
+// ClearExpiredKeys removes the expired entries. The janitor of the store
+// calls it every Interval.
 func (m *MemoryCache) ClearExpiredKeys() {
-	for {
-		<-time.After(m.Interval)
-		m.RLock()
-		if m.items == nil {
-			m.RUnlock()
-			return
-		}
-		m.RUnlock()
-		for key, item := range m.items {
-			if item.ExpirationTime.Before(time.Now()) {
-				delete(m.items, key)
-			}
+	_, _ = m.sweep()
+}
+
+// sweep removes the expired entries and returns how many.
+func (m *MemoryCache) sweep() (int, error) {
+	m.Lock()
+	defer m.Unlock()
+	var evicted int
+	now := time.Now()
+	for key, item := range m.items {
+		if item.ExpirationTime.Before(now) {
+			delete(m.items, key)
+			evicted++
 		}
 	}
+	return evicted, nil
+}
+
+// Ping always succeeds: the store lives in the process.
+func (m *MemoryCache) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close stops the sweeper of expired keys. The entries stay readable.
+// It is safe to call more than once.
+func (m *MemoryCache) Close() error {
+	m.janitor.Stop()
+	return nil
 }
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"context"
 	"sync"
 	"testing"
 	"time"
@@ -79,3 +80,14 @@ func TestMemoryCacheConcurrencyIncr(t *testing.T) {
 		t.Error("Incr err")
 	}
 }
+
+func TestMemoryCacheClose(t *testing.T) {
+	c := NewMemoryCache(10 * time.Millisecond).(*MemoryCache)
+	assert.Nil(t, c.Set("key1", "author", 0))
+	assert.Nil(t, c.Ping(context.Background()))
+	assert.Nil(t, c.Close())
+	assert.Nil(t, c.Close())
+	val, err := c.Get("key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "author", val)
+}
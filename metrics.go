@@ -0,0 +1,223 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the latency
+// histogram of Metrics.
+var DefaultLatencyBuckets = []float64{.0001, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+
+// MetricSample is what one Cache operation contributes to the metrics.
+type MetricSample struct {
+	// Store is the Name of the store
+	Store string
+	// Operation is the name of the Cache method, e.g. "Get"
+	Operation string
+	// Hits and Misses count keys, so GetMulti may report several. Only
+	// Get and GetMulti report them.
+	Hits   int
+	Misses int
+	// Expirations counts the misses caused by an expired key
+	Expirations int
+	// Errors counts failures other than misses
+	Errors int
+	// BytesRead and BytesWritten are the sizes of the values returned and
+	// stored: strings and []byte by length, other values only with the
+	// ValueSizer of WithSizedMetrics
+	BytesRead    int
+	BytesWritten int
+	Duration     time.Duration
+}
+
+// MetricsCollector receives a MetricSample for every operation of a Cache
+// wrapped by WithMetrics. Implementations must be safe for concurrent use.
+type MetricsCollector interface {
+	Observe(sample MetricSample)
+}
+
+// ValueSizer returns the size in bytes of a value that is neither a string
+// nor a []byte, for the byte counts of MetricSample.
+type ValueSizer func(value any) int
+
+// WithMetrics returns a Middleware reporting every operation to collector.
+// Only strings and []byte count towards the bytes read and written, so no
+// value is encoded on the way; see WithSizedMetrics for the others.
+func WithMetrics(collector MetricsCollector) Middleware {
+	return WithSizedMetrics(collector, nil)
+}
+
+// WithSizedMetrics is WithMetrics measuring the other values with size,
+// e.g. JSONSize, at the cost of running it on every Get and Set.
+func WithSizedMetrics(collector MetricsCollector, size ValueSizer) Middleware {
+	return WithHooks(Hooks{After: func(op *Operation) {
+		collector.Observe(metricSample(op, size))
+	}})
+}
+
+// JSONSize is a ValueSizer measuring values by their JSON encoding, the
+// encoding of the default CacheItem.
+func JSONSize(value any) int {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
+
+func metricSample(op *Operation, size ValueSizer) MetricSample {
+	sample := MetricSample{Store: op.Store, Operation: op.Method, Duration: op.Duration}
+	switch op.Method {
+	case "Get":
+		switch {
+		case op.Err == nil:
+			sample.Hits = 1
+			sample.BytesRead = valueSize(op.Result, size)
+		case IsMiss(op.Err):
+			sample.Misses = 1
+		}
+	case "GetMulti":
+		var keysErr MultiError
+		if op.Err == nil || errors.As(op.Err, &keysErr) {
+			values, _ := op.Result.([]any)
+			for _, keyErr := range keysErr {
+				if IsMiss(keyErr.Err) {
+					sample.Misses++
+				} else {
+					sample.Errors++
+				}
+				if errors.Is(keyErr.Err, ErrExpired) {
+					sample.Expirations++
+				}
+			}
+			sample.Hits = len(op.Keys) - len(keysErr)
+			for _, value := range values {
+				sample.BytesRead += valueSize(value, size)
+			}
+			return sample
+		}
+	case "Set":
+		if op.Err == nil {
+			sample.BytesWritten = valueSize(op.Value, size)
+		}
+	}
+	if errors.Is(op.Err, ErrExpired) {
+		sample.Expirations = 1
+	}
+	if op.Err != nil && !IsMiss(op.Err) {
+		sample.Errors = 1
+	}
+	return sample
+}
+
+func valueSize(value any, size ValueSizer) int {
+	switch v := value.(type) {
+	case nil:
+		return 0
+	case string:
+		return len(v)
+	case []byte:
+		return len(v)
+	}
+	if size == nil {
+		return 0
+	}
+	return size(value)
+}
+
+// MetricsOptions configures Metrics.
+type MetricsOptions func(m *Metrics)
+
+// MetricsWithBuckets sets the upper bounds, in seconds, of the latency
+// histogram.
+func MetricsWithBuckets(buckets ...float64) MetricsOptions {
+	return func(m *Metrics) {
+		m.buckets = append([]float64(nil), buckets...)
+		sort.Float64s(m.buckets)
+	}
+}
+
+// Metrics is the in-tree MetricsCollector. It aggregates the samples in
+// memory, per store and operation, for the Prometheus and expvar exporters.
+type Metrics struct {
+	buckets []float64
+	series  map[seriesKey]*series
+	l       sync.Mutex
+}
+
+type seriesKey struct {
+	store     string
+	operation string
+}
+
+type series struct {
+	hits         uint64
+	misses       uint64
+	expirations  uint64
+	errors       uint64
+	bytesRead    uint64
+	bytesWritten uint64
+	count        uint64
+	// sum is the total latency in seconds
+	sum float64
+	// counts[i] are the operations not slower than buckets[i], not
+	// cumulated; the last one counts the slower ones
+	counts []uint64
+}
+
+// NewMetrics returns an empty Metrics.
+func NewMetrics(opts ...MetricsOptions) *Metrics {
+	m := &Metrics{buckets: DefaultLatencyBuckets, series: make(map[seriesKey]*series)}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Observe implements MetricsCollector.
+func (m *Metrics) Observe(sample MetricSample) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	key := seriesKey{store: sample.Store, operation: sample.Operation}
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{counts: make([]uint64, len(m.buckets)+1)}
+		m.series[key] = s
+	}
+	s.hits += uint64(sample.Hits)
+	s.misses += uint64(sample.Misses)
+	s.expirations += uint64(sample.Expirations)
+	s.errors += uint64(sample.Errors)
+	s.bytesRead += uint64(sample.BytesRead)
+	s.bytesWritten += uint64(sample.BytesWritten)
+	s.count++
+	seconds := sample.Duration.Seconds()
+	s.sum += seconds
+	s.counts[sort.SearchFloat64s(m.buckets, seconds)]++
+}
+
+// snapshot returns copies of the series sorted by store and operation.
+func (m *Metrics) snapshot() ([]seriesKey, []series) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	keys := make([]seriesKey, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].store != keys[j].store {
+			return keys[i].store < keys[j].store
+		}
+		return keys[i].operation < keys[j].operation
+	})
+	values := make([]series, len(keys))
+	for i, key := range keys {
+		values[i] = *m.series[key]
+		values[i].counts = append([]uint64(nil), values[i].counts...)
+	}
+	return keys, values
+}
@@ -0,0 +1,48 @@
+package cache
+
+import "expvar"
+
+// Expvar returns the metrics as an expvar.Var, a JSON object of stores
+// holding an object per operation.
+func (m *Metrics) Expvar() expvar.Var {
+	return expvar.Func(m.expvarValue)
+}
+
+// PublishExpvar publishes the metrics under name, served by the expvar
+// handler at /debug/vars. Like expvar.Publish it panics if name is taken.
+func (m *Metrics) PublishExpvar(name string) {
+	expvar.Publish(name, m.Expvar())
+}
+
+func (m *Metrics) expvarValue() any {
+	keys, values := m.snapshot()
+	stores := make(map[string]map[string]any)
+	for i, key := range keys {
+		s := values[i]
+		buckets := make(map[string]uint64, len(s.counts))
+		var cumulated uint64
+		for j, count := range s.counts {
+			cumulated += count
+			le := "+Inf"
+			if j < len(m.buckets) {
+				le = formatFloat(m.buckets[j])
+			}
+			buckets[le] = cumulated
+		}
+		if stores[key.store] == nil {
+			stores[key.store] = make(map[string]any)
+		}
+		stores[key.store][key.operation] = map[string]any{
+			"hits":                    s.hits,
+			"misses":                  s.misses,
+			"expirations":             s.expirations,
+			"errors":                  s.errors,
+			"read_bytes":              s.bytesRead,
+			"written_bytes":           s.bytesWritten,
+			"count":                   s.count,
+			"duration_seconds_sum":    s.sum,
+			"duration_seconds_bucket": buckets,
+		}
+	}
+	return stores
+}
@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// PrometheusContentType is the content type of the Prometheus text format.
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var prometheusCounters = []struct {
+	name  string
+	help  string
+	value func(s *series) uint64
+}{
+	{"gocache_hits_total", "Keys found by Get and GetMulti.", func(s *series) uint64 { return s.hits }},
+	{"gocache_misses_total", "Keys missing or expired in Get and GetMulti.", func(s *series) uint64 { return s.misses }},
+	{"gocache_expirations_total", "Keys found expired in Get and GetMulti.", func(s *series) uint64 { return s.expirations }},
+	{"gocache_errors_total", "Operations or keys failed for another reason than a miss.", func(s *series) uint64 { return s.errors }},
+	{"gocache_read_bytes_total", "Size of the values returned by Get and GetMulti.", func(s *series) uint64 { return s.bytesRead }},
+	{"gocache_written_bytes_total", "Size of the values stored by Set.", func(s *series) uint64 { return s.bytesWritten }},
+}
+
+// WritePrometheus writes the metrics to w in the Prometheus text format,
+// labeled by store and operation.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	keys, values := m.snapshot()
+	bw := bufio.NewWriter(w)
+	for _, counter := range prometheusCounters {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s counter\n", counter.name, counter.help, counter.name)
+		for i, key := range keys {
+			fmt.Fprintf(bw, "%s{%s} %d\n", counter.name, prometheusLabels(key), counter.value(&values[i]))
+		}
+	}
+	const histogram = "gocache_operation_duration_seconds"
+	fmt.Fprintf(bw, "# HELP %s Latency of the operations.\n# TYPE %s histogram\n", histogram, histogram)
+	for i, key := range keys {
+		labels := prometheusLabels(key)
+		var cumulated uint64
+		for j, count := range values[i].counts {
+			cumulated += count
+			le := math.Inf(1)
+			if j < len(m.buckets) {
+				le = m.buckets[j]
+			}
+			fmt.Fprintf(bw, "%s_bucket{%s,le=\"%s\"} %d\n", histogram, labels, formatFloat(le), cumulated)
+		}
+		fmt.Fprintf(bw, "%s_sum{%s} %s\n", histogram, labels, formatFloat(values[i].sum))
+		fmt.Fprintf(bw, "%s_count{%s} %d\n", histogram, labels, values[i].count)
+	}
+	return bw.Flush()
+}
+
+// PrometheusHandler serves the metrics in the Prometheus text format, for
+// use as a scrape target.
+func (m *Metrics) PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", PrometheusContentType)
+		_ = m.WritePrometheus(w)
+	})
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func prometheusLabels(key seriesKey) string {
+	return fmt.Sprintf(`store="%s",operation="%s"`, labelEscaper.Replace(key.store), labelEscaper.Replace(key.operation))
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
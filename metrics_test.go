@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type sampleCollector []MetricSample
+
+func (s *sampleCollector) Observe(sample MetricSample) {
+	*s = append(*s, sample)
+}
+
+func TestWithMetrics(t *testing.T) {
+	var samples sampleCollector
+	c := WithMetrics(&samples)(NewMemoryCache(time.Minute))
+	assert.Nil(t, c.Set("key1", "author", time.Minute))
+	assert.Nil(t, c.Set("short", "author", 50*time.Millisecond))
+	time.Sleep(100 * time.Millisecond)
+	_, _ = c.Get("key1")
+	_, _ = c.Get("missing")
+	_, _ = c.GetMulti([]string{"key1", "missing", "short"})
+	_ = c.Increment("key1", 1)
+
+	assert.Len(t, samples, 6)
+	assert.Equal(t, MetricSample{Store: MemoryCacheName, Operation: "Set", BytesWritten: 6, Duration: samples[0].Duration}, samples[0])
+	assert.Equal(t, 1, samples[2].Hits)
+	assert.Equal(t, 6, samples[2].BytesRead)
+	assert.Equal(t, 1, samples[3].Misses)
+	assert.Equal(t, 1, samples[4].Hits)
+	assert.Equal(t, 2, samples[4].Misses)
+	assert.Equal(t, 1, samples[4].Expirations)
+	assert.Equal(t, 6, samples[4].BytesRead)
+	assert.Equal(t, "Increment", samples[5].Operation)
+	assert.Equal(t, 1, samples[5].Errors)
+}
+
+func TestMetricsPrometheus(t *testing.T) {
+	m := NewMetrics(MetricsWithBuckets(0.01, 0.001))
+	m.Observe(MetricSample{Store: "memory", Operation: "Get", Hits: 1, BytesRead: 6, Duration: 500 * time.Microsecond})
+	m.Observe(MetricSample{Store: "memory", Operation: "Get", Misses: 1, Duration: 5 * time.Millisecond})
+	m.Observe(MetricSample{Store: `a"b`, Operation: "Set", BytesWritten: 3, Duration: time.Second})
+
+	rec := httptest.NewRecorder()
+	m.PrometheusHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, PrometheusContentType, rec.Header().Get("Content-Type"))
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE gocache_hits_total counter",
+		`gocache_hits_total{store="memory",operation="Get"} 1`,
+		`gocache_misses_total{store="memory",operation="Get"} 1`,
+		`gocache_read_bytes_total{store="memory",operation="Get"} 6`,
+		`gocache_written_bytes_total{store="a\"b",operation="Set"} 3`,
+		"# TYPE gocache_operation_duration_seconds histogram",
+		`gocache_operation_duration_seconds_bucket{store="memory",operation="Get",le="0.001"} 1`,
+		`gocache_operation_duration_seconds_bucket{store="memory",operation="Get",le="0.01"} 2`,
+		`gocache_operation_duration_seconds_bucket{store="memory",operation="Get",le="+Inf"} 2`,
+		`gocache_operation_duration_seconds_bucket{store="a\"b",operation="Set",le="0.01"} 0`,
+		`gocache_operation_duration_seconds_sum{store="memory",operation="Get"} 0.0055`,
+		`gocache_operation_duration_seconds_count{store="memory",operation="Get"} 2`,
+	} {
+		assert.Contains(t, strings.Split(body, "\n"), line)
+	}
+}
+
+func TestMetricsExpvar(t *testing.T) {
+	m := NewMetrics()
+	c := NewCache(NewMemoryCache(time.Minute)).Use(WithMetrics(m))
+	assert.Nil(t, c.Set("key1", "author", time.Minute))
+	_, _ = c.Get("key1")
+
+	var stores map[string]map[string]map[string]any
+	assert.Nil(t, json.Unmarshal([]byte(m.Expvar().String()), &stores))
+	assert.Equal(t, float64(1), stores[MemoryCacheName]["Get"]["hits"])
+	assert.Equal(t, float64(6), stores[MemoryCacheName]["Set"]["written_bytes"])
+	assert.Equal(t, float64(1), stores[MemoryCacheName]["Set"]["count"])
+}
+
+func TestWithSizedMetrics(t *testing.T) {
+	var samples sampleCollector
+	value := map[string]int{"count": 1}
+	_ = WithMetrics(&samples)(NewMemoryCache(time.Minute)).Set("key1", value, time.Minute)
+	_ = WithSizedMetrics(&samples, JSONSize)(NewMemoryCache(time.Minute)).Set("key1", value, time.Minute)
+	assert.Zero(t, samples[0].BytesWritten)
+	assert.Equal(t, len(`{"count":1}`), samples[1].BytesWritten)
+}
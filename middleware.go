@@ -0,0 +1,228 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Middleware wraps a Cache to add behaviour around its operations.
+//
+// A middleware returning its own Cache implementation hides the Pinger,
+// io.Closer and TTLGetter of the store it wraps unless it forwards them, as
+// the one of WithHooks does.
+type Middleware func(next Cache) Cache
+
+// Chain wraps c in mws, the first one outermost: it sees every operation
+// first and its result last.
+func Chain(c Cache, mws ...Middleware) Cache {
+	for i := len(mws) - 1; i >= 0; i-- {
+		c = mws[i](c)
+	}
+	return c
+}
+
+// Operation describes one call to a Cache method, for Hooks.
+type Operation struct {
+	// Context is the context of the call, context.Background() for the
+	// methods without one. Before may replace it, e.g. with one carrying
+	// a span; the store and After get the replacement.
+	Context context.Context
+	// Store is the Name of the wrapped store
+	Store string
+	// Method is the name of the Cache method, e.g. "Get"
+	Method string
+	// Key is empty for GetMulti and Clear
+	Key string
+	// Keys is only set for GetMulti
+	Keys []string
+	// Value is only set for Set
+	Value any
+	// TTL is only set for Set
+	TTL time.Duration
+	// Step is only set for Increment and Decrement
+	Step int
+	// Result is the value returned next to the error, nil for methods
+	// returning only an error. It is set before After runs.
+	Result any
+	// Err is set before After runs
+	Err error
+	// Start is when the operation began, after Before
+	Start time.Time
+	// Duration is set before After runs
+	Duration time.Duration
+}
+
+// Hooks are called around every operation of a Cache wrapped by WithHooks.
+// Either may be nil.
+type Hooks struct {
+	// Before runs first. A non-nil error skips the operation, which then
+	// fails with it, e.g. to reject invalid keys. After still runs.
+	Before func(op *Operation) error
+	// After runs last. It may replace op.Result and op.Err, which are
+	// what the caller gets.
+	After func(op *Operation)
+}
+
+// WithHooks returns a Middleware calling hooks around every operation. The
+// Cache it returns is a ContextCache, and a TTLGetter when next is one.
+func WithHooks(hooks Hooks) Middleware {
+	return func(next Cache) Cache {
+		h := &hookCache{next: NewContextCache(next), hooks: hooks}
+		if _, ok := h.next.(TTLGetter); ok {
+			return ttlHookCache{h}
+		}
+		return h
+	}
+}
+
+type hookCache struct {
+	next  ContextCache
+	hooks Hooks
+}
+
+// do runs fn between the hooks and returns the result left in op. fn gets
+// op.Context as left by Before.
+func (h *hookCache) do(op *Operation, fn func(ctx context.Context) (any, error)) (any, error) {
+	op.Store = h.next.Name()
+	var err error
+	if h.hooks.Before != nil {
+		err = h.hooks.Before(op)
+	}
+	op.Start = time.Now()
+	if err == nil {
+		op.Result, op.Err = fn(op.Context)
+	} else {
+		op.Err = err
+	}
+	op.Duration = time.Since(op.Start)
+	if h.hooks.After != nil {
+		h.hooks.After(op)
+	}
+	return op.Result, op.Err
+}
+
+func (h *hookCache) Name() string {
+	return h.next.Name()
+}
+
+func (h *hookCache) Set(key string, value any, ttl time.Duration) error {
+	return h.SetContext(context.Background(), key, value, ttl)
+}
+
+func (h *hookCache) Has(key string) (bool, error) {
+	return h.HasContext(context.Background(), key)
+}
+
+func (h *hookCache) GetMulti(keys []string) ([]any, error) {
+	return h.GetMultiContext(context.Background(), keys)
+}
+
+func (h *hookCache) Get(key string) (any, error) {
+	return h.GetContext(context.Background(), key)
+}
+
+func (h *hookCache) Delete(key string) error {
+	return h.DeleteContext(context.Background(), key)
+}
+
+func (h *hookCache) Increment(key string, step int) error {
+	return h.IncrementContext(context.Background(), key, step)
+}
+
+func (h *hookCache) Decrement(key string, step int) error {
+	return h.DecrementContext(context.Background(), key, step)
+}
+
+func (h *hookCache) Clear() error {
+	return h.ClearContext(context.Background())
+}
+
+func (h *hookCache) SetContext(ctx context.Context, key string, value any, ttl time.Duration) error {
+	_, err := h.do(&Operation{Context: ctx, Method: "Set", Key: key, Value: value, TTL: ttl}, func(ctx context.Context) (any, error) {
+		return nil, h.next.SetContext(ctx, key, value, ttl)
+	})
+	return err
+}
+
+func (h *hookCache) HasContext(ctx context.Context, key string) (bool, error) {
+	result, err := h.do(&Operation{Context: ctx, Method: "Has", Key: key}, func(ctx context.Context) (any, error) {
+		return h.next.HasContext(ctx, key)
+	})
+	ok, _ := result.(bool)
+	return ok, err
+}
+
+func (h *hookCache) GetMultiContext(ctx context.Context, keys []string) ([]any, error) {
+	result, err := h.do(&Operation{Context: ctx, Method: "GetMulti", Keys: keys}, func(ctx context.Context) (any, error) {
+		return h.next.GetMultiContext(ctx, keys)
+	})
+	values, _ := result.([]any)
+	return values, err
+}
+
+func (h *hookCache) GetContext(ctx context.Context, key string) (any, error) {
+	return h.do(&Operation{Context: ctx, Method: "Get", Key: key}, func(ctx context.Context) (any, error) {
+		return h.next.GetContext(ctx, key)
+	})
+}
+
+func (h *hookCache) DeleteContext(ctx context.Context, key string) error {
+	_, err := h.do(&Operation{Context: ctx, Method: "Delete", Key: key}, func(ctx context.Context) (any, error) {
+		return nil, h.next.DeleteContext(ctx, key)
+	})
+	return err
+}
+
+func (h *hookCache) IncrementContext(ctx context.Context, key string, step int) error {
+	_, err := h.do(&Operation{Context: ctx, Method: "Increment", Key: key, Step: step}, func(ctx context.Context) (any, error) {
+		return nil, h.next.IncrementContext(ctx, key, step)
+	})
+	return err
+}
+
+func (h *hookCache) DecrementContext(ctx context.Context, key string, step int) error {
+	_, err := h.do(&Operation{Context: ctx, Method: "Decrement", Key: key, Step: step}, func(ctx context.Context) (any, error) {
+		return nil, h.next.DecrementContext(ctx, key, step)
+	})
+	return err
+}
+
+func (h *hookCache) ClearContext(ctx context.Context) error {
+	_, err := h.do(&Operation{Context: ctx, Method: "Clear"}, func(ctx context.Context) (any, error) {
+		return nil, h.next.ClearContext(ctx)
+	})
+	return err
+}
+
+// ttlHookCache is the hookCache of a TTLGetter, which it forwards.
+type ttlHookCache struct {
+	*hookCache
+}
+
+// GetWithTTL gets key with its remaining ttl from the wrapped store, as a
+// Get for the hooks.
+func (h ttlHookCache) GetWithTTL(ctx context.Context, key string) (any, time.Duration, error) {
+	var ttl time.Duration
+	val, err := h.do(&Operation{Context: ctx, Method: "Get", Key: key}, func(ctx context.Context) (val any, err error) {
+		val, ttl, err = getWithTTL(ctx, h.next, key)
+		return val, err
+	})
+	return val, ttl, err
+}
+
+// Ping pings the wrapped store, or succeeds when it is not a Pinger.
+func (h *hookCache) Ping(ctx context.Context) error {
+	if pinger, ok := h.next.(Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// Close closes the wrapped store, if it is an io.Closer.
+func (h *hookCache) Close() error {
+	if closer, ok := h.next.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errInvalidKey = errors.New("invalid key")
+
+func TestWithHooks(t *testing.T) {
+	var ops []Operation
+	c := WithHooks(Hooks{
+		Before: func(op *Operation) error {
+			if strings.Contains(op.Key, " ") {
+				return errInvalidKey
+			}
+			return nil
+		},
+		After: func(op *Operation) {
+			ops = append(ops, *op)
+		},
+	})(NewMemoryCache(time.Minute))
+	assert.Equal(t, MemoryCacheName, c.Name())
+
+	assert.Nil(t, c.Set("key1", "author", time.Minute))
+	val, err := c.Get("key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "author", val)
+	ok, err := c.Has("missing")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.ErrorIs(t, c.Set("bad key", "author", time.Minute), errInvalidKey)
+	_, err = c.Get("bad key")
+	assert.ErrorIs(t, err, errInvalidKey)
+	assert.Nil(t, c.Increment("counter", 2))
+
+	assert.Len(t, ops, 6)
+	assert.Equal(t, MemoryCacheName, ops[0].Store)
+	assert.Equal(t, "Set", ops[0].Method)
+	assert.Equal(t, "author", ops[0].Value)
+	assert.Equal(t, time.Minute, ops[0].TTL)
+	assert.Equal(t, "author", ops[1].Result)
+	assert.Equal(t, false, ops[2].Result)
+	assert.ErrorIs(t, ops[3].Err, errInvalidKey)
+	assert.Equal(t, 2, ops[5].Step)
+
+	// the wrapped store was not reached for the rejected key
+	inner := NewMemoryCache(time.Minute)
+	_ = WithHooks(Hooks{Before: func(op *Operation) error { return errInvalidKey }})(inner).Set("key1", 1, 0)
+	_, err = inner.Get("key1")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestWithHooksAfterReplacesResult(t *testing.T) {
+	c := WithHooks(Hooks{
+		After: func(op *Operation) {
+			if IsMiss(op.Err) {
+				op.Result, op.Err = "default", nil
+			}
+		},
+	})(NewNullCache())
+	val, err := c.Get("key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "default", val)
+	assert.Nil(t, c.(Pinger).Ping(context.Background()))
+}
+
+func TestWithHooksForwardsGetWithTTL(t *testing.T) {
+	var methods []string
+	hooks := Hooks{After: func(op *Operation) {
+		methods = append(methods, op.Method)
+	}}
+	c := WithHooks(hooks)(NewMemoryCache(time.Minute))
+	assert.Nil(t, c.Set("key1", "val1", time.Minute))
+	val, ttl, err := c.(TTLGetter).GetWithTTL(context.Background(), "key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "val1", val)
+	assert.InDelta(t, time.Minute, ttl, float64(time.Second))
+	assert.Equal(t, []string{"Set", "Get"}, methods)
+
+	_, ok := WithHooks(hooks)(plainCache{NewMemoryCache(time.Minute)}).(TTLGetter)
+	assert.False(t, ok)
+}
+
+func TestChain(t *testing.T) {
+	var order []string
+	trace := func(name string) Middleware {
+		return WithHooks(Hooks{
+			Before: func(op *Operation) error {
+				order = append(order, "before "+name)
+				return nil
+			},
+			After: func(op *Operation) {
+				order = append(order, "after "+name)
+			},
+		})
+	}
+	c := Chain(NewMemoryCache(time.Minute), trace("a"), trace("b"))
+	assert.Nil(t, c.Delete("key1"))
+	assert.Equal(t, []string{"before a", "before b", "after b", "after a"}, order)
+}
+
+func TestGoCacheUse(t *testing.T) {
+	var methods []string
+	record := WithHooks(Hooks{After: func(op *Operation) {
+		methods = append(methods, op.Store+"."+op.Method)
+	}})
+
+	memory := NewMemoryCache(time.Minute)
+	c := NewCache(memory)
+	c.Use(record)
+	c.Extend(NewNullCache())
+	assert.Nil(t, c.Set("key1", "author", time.Minute))
+	store, err := c.Store(NullCacheName)
+	assert.Nil(t, err)
+	_, err = store.Get("key1")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, []string{"memory.Set", "null.Get"}, methods)
+
+	// the registered store comes back unwrapped
+	removed, err := c.Remove(MemoryCacheName)
+	assert.Nil(t, err)
+	assert.Equal(t, memory, removed)
+}
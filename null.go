@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// NullCache is a Cache that stores nothing: every read misses and every
+// write succeeds. Register it in place of a real store to switch caching
+// off without touching the code using it.
+type NullCache struct{}
+
+// NewNullCache returns a new NullCache.
+func NewNullCache() Cache {
+	return &NullCache{}
+}
+
+func (n *NullCache) Name() string {
+	return NullCacheName
+}
+
+func (n *NullCache) Set(key string, value any, ttl time.Duration) error {
+	return nil
+}
+
+func (n *NullCache) Has(key string) (bool, error) {
+	return false, nil
+}
+
+func (n *NullCache) GetMulti(keys []string) ([]any, error) {
+	var keysErr MultiError
+	for _, key := range keys {
+		keysErr = append(keysErr, KeyError{Key: key, Err: ErrNotFound})
+	}
+	return make([]any, len(keys)), keysErr.ErrorOrNil()
+}
+
+func (n *NullCache) Get(key string) (any, error) {
+	return nil, ErrNotFound
+}
+
+func (n *NullCache) Delete(key string) error {
+	return nil
+}
+
+func (n *NullCache) Increment(key string, step int) error {
+	return nil
+}
+
+func (n *NullCache) Decrement(key string, step int) error {
+	return nil
+}
+
+func (n *NullCache) Clear() error {
+	return nil
+}
+
+func (n *NullCache) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (n *NullCache) Close() error {
+	return nil
+}
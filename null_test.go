@@ -0,0 +1,33 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNullCache(t *testing.T) {
+	c := NewNullCache()
+	assert.Equal(t, NullCacheName, c.Name())
+	assert.Nil(t, c.Set("key1", "author", time.Minute))
+	_, err := c.Get("key1")
+	assert.ErrorIs(t, err, ErrNotFound)
+	ok, err := c.Has("key1")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	values, err := c.GetMulti([]string{"key1", "key2"})
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, []any{nil, nil}, values)
+	assert.Nil(t, c.Increment("key1", 1))
+	assert.Nil(t, c.Decrement("key1", 1))
+	assert.Nil(t, c.Delete("key1"))
+	assert.Nil(t, c.Clear())
+
+	gc := New().Extend(c)
+	val, err := gc.Remember("key1", "author", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, "author", val)
+	_, err = gc.Get("key1")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,165 @@
+// Package otelcache traces cache operations with OpenTelemetry.
+//
+// New returns a cache.Middleware starting a client span per operation, a
+// child of the span in the context given to the Context methods of the
+// cache, e.g. GoCache.GetContext:
+//
+//	c := cache.NewCache(cache.NewMemoryCache(time.Minute)).Use(otelcache.New())
+//	val, err := c.GetContext(ctx, "key")
+package otelcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	"github.com/pkg6/go-cache"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName is the name of the tracer of the middleware.
+const InstrumentationName = "github.com/pkg6/go-cache/otelcache"
+
+// The attributes of the spans, next to db.system and db.operation.
+const (
+	// KeyHashKey is the hash of the key of single key operations
+	KeyHashKey = attribute.Key("cache.key_hash")
+	// HitKey is set by Get and Has
+	HitKey = attribute.Key("cache.hit")
+	// BatchSizeKey, HitsKey and MissesKey are set by GetMulti
+	BatchSizeKey = attribute.Key("cache.batch_size")
+	HitsKey      = attribute.Key("cache.hits")
+	MissesKey    = attribute.Key("cache.misses")
+)
+
+// dbSystems maps the names of the stores to the db.system of their spans;
+// other stores use their name.
+var dbSystems = map[string]string{
+	cache.MemcacheCacheName: "memcached",
+}
+
+// Tracer configures the middleware returned by New.
+type Tracer struct {
+	// Provider defaults to the global TracerProvider
+	Provider trace.TracerProvider
+	// HashKey turns keys into the value of cache.key_hash, so they do not
+	// leak personal data into traces. It defaults to HashKey.
+	HashKey func(key string) string
+}
+
+// TracerOptions configures a Tracer.
+type TracerOptions func(t *Tracer)
+
+// TracerWithProvider sets the TracerProvider creating the spans.
+func TracerWithProvider(provider trace.TracerProvider) TracerOptions {
+	return func(t *Tracer) {
+		t.Provider = provider
+	}
+}
+
+// TracerWithHashKey replaces HashKey, e.g. to salt the hash. A nil hashKey
+// leaves the keys out of the spans.
+func TracerWithHashKey(hashKey func(key string) string) TracerOptions {
+	return func(t *Tracer) {
+		t.HashKey = hashKey
+	}
+}
+
+// HashKey returns the hex SHA-256 of key.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// New returns a cache.Middleware tracing every operation.
+func New(opts ...TracerOptions) cache.Middleware {
+	t := &Tracer{HashKey: HashKey}
+	for _, opt := range opts {
+		opt(t)
+	}
+	if t.Provider == nil {
+		t.Provider = otel.GetTracerProvider()
+	}
+	tracer := t.Provider.Tracer(InstrumentationName)
+	return cache.WithHooks(cache.Hooks{
+		Before: func(op *cache.Operation) error {
+			op.Context, _ = tracer.Start(op.Context, "cache."+op.Method,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(t.attributes(op)...))
+			return nil
+		},
+		After: func(op *cache.Operation) {
+			span := trace.SpanFromContext(op.Context)
+			defer span.End()
+			span.SetAttributes(result(op)...)
+			if op.Err != nil && !cache.IsMiss(op.Err) {
+				var keysErr cache.MultiError
+				if op.Method == "GetMulti" && errors.As(op.Err, &keysErr) && allMisses(keysErr) {
+					return
+				}
+				span.RecordError(op.Err)
+				span.SetStatus(codes.Error, op.Err.Error())
+			}
+		},
+	})
+}
+
+func (t *Tracer) attributes(op *cache.Operation) []attribute.KeyValue {
+	system, ok := dbSystems[op.Store]
+	if !ok {
+		system = op.Store
+	}
+	attrs := []attribute.KeyValue{
+		attribute.String("db.system", system),
+		attribute.String("db.operation", op.Method),
+	}
+	if op.Key != "" && t.HashKey != nil {
+		attrs = append(attrs, KeyHashKey.String(t.HashKey(op.Key)))
+	}
+	if op.Method == "GetMulti" {
+		attrs = append(attrs, BatchSizeKey.Int(len(op.Keys)))
+	}
+	return attrs
+}
+
+// result returns the attributes known once op is done.
+func result(op *cache.Operation) []attribute.KeyValue {
+	switch op.Method {
+	case "Get":
+		if op.Err == nil || cache.IsMiss(op.Err) {
+			return []attribute.KeyValue{HitKey.Bool(op.Err == nil)}
+		}
+	case "Has":
+		if op.Err == nil {
+			hit, _ := op.Result.(bool)
+			return []attribute.KeyValue{HitKey.Bool(hit)}
+		}
+	case "GetMulti":
+		var keysErr cache.MultiError
+		if op.Err == nil || errors.As(op.Err, &keysErr) {
+			var misses int
+			for _, keyErr := range keysErr {
+				if cache.IsMiss(keyErr.Err) {
+					misses++
+				}
+			}
+			return []attribute.KeyValue{
+				HitsKey.Int(len(op.Keys) - len(keysErr)),
+				MissesKey.Int(misses),
+			}
+		}
+	}
+	return nil
+}
+
+func allMisses(keysErr cache.MultiError) bool {
+	for _, keyErr := range keysErr {
+		if !cache.IsMiss(keyErr.Err) {
+			return false
+		}
+	}
+	return true
+}
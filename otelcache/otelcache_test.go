@@ -0,0 +1,91 @@
+package otelcache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg6/go-cache"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, attr := range span.Attributes() {
+		attrs[attr.Key] = attr.Value
+	}
+	return attrs
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	c := cache.NewCache(cache.NewMemoryCache(time.Minute)).Use(New(TracerWithProvider(provider)))
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+	assert.Nil(t, c.SetContext(ctx, "user@example.com", "author", time.Minute))
+	_, err := c.GetContext(ctx, "user@example.com")
+	assert.Nil(t, err)
+	_, err = c.GetContext(ctx, "missing")
+	assert.ErrorIs(t, err, cache.ErrNotFound)
+	_, err = c.GetMultiContext(ctx, []string{"user@example.com", "missing", "other"})
+	assert.ErrorIs(t, err, cache.ErrNotFound)
+	assert.NotNil(t, c.IncrementContext(ctx, "user@example.com", 1))
+	parent.End()
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 6)
+	for _, span := range spans[:5] {
+		assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+		assert.Equal(t, trace.SpanKindClient, span.SpanKind())
+		assert.Equal(t, "memory", attributes(span)["db.system"].AsString())
+	}
+
+	set := attributes(spans[0])
+	assert.Equal(t, "cache.Set", spans[0].Name())
+	assert.Equal(t, HashKey("user@example.com"), set[KeyHashKey].AsString())
+	for _, attr := range spans[0].Attributes() {
+		assert.NotContains(t, attr.Value.Emit(), "user@example.com")
+	}
+	assert.True(t, attributes(spans[1])[HitKey].AsBool())
+	assert.False(t, attributes(spans[2])[HitKey].AsBool())
+	assert.Equal(t, codes.Unset, spans[2].Status().Code)
+
+	multi := attributes(spans[3])
+	assert.Equal(t, int64(3), multi[BatchSizeKey].AsInt64())
+	assert.Equal(t, int64(1), multi[HitsKey].AsInt64())
+	assert.Equal(t, int64(2), multi[MissesKey].AsInt64())
+	assert.Equal(t, codes.Unset, spans[3].Status().Code)
+
+	assert.Equal(t, codes.Error, spans[4].Status().Code)
+	assert.Len(t, spans[4].Events(), 1)
+}
+
+type memcacheStub struct {
+	cache.NullCache
+}
+
+func (m *memcacheStub) Name() string {
+	return cache.MemcacheCacheName
+}
+
+func TestTracingWithoutKeys(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	c := New(TracerWithProvider(provider), TracerWithHashKey(nil))(&memcacheStub{})
+	_, err := c.Get("key1")
+	assert.ErrorIs(t, err, cache.ErrNotFound)
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 1)
+	attrs := attributes(spans[0])
+	assert.Equal(t, "memcached", attrs["db.system"].AsString())
+	assert.Equal(t, "Get", attrs["db.operation"].AsString())
+	_, ok := attrs[KeyHashKey]
+	assert.False(t, ok)
+}
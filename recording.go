@@ -0,0 +1,137 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Call is one call recorded by a RecordingCache.
+type Call struct {
+	// Method is the name of the Cache method, e.g. "Get"
+	Method string
+	// Args are the arguments after the receiver
+	Args []any
+	// Result is the value returned next to the error, nil for methods
+	// returning only an error
+	Result any
+	Err    error
+}
+
+// RecordingCache wraps a Cache and records every call and its result, for
+// assertions in unit tests. It takes the name of the wrapped store, so it
+// can be registered in its place.
+type RecordingCache struct {
+	Cache Cache
+	calls []Call
+	l     sync.Mutex
+}
+
+// NewRecordingCache returns a RecordingCache wrapping c.
+func NewRecordingCache(c Cache) Cache {
+	return &RecordingCache{Cache: c}
+}
+
+// Calls returns the calls recorded so far, oldest first.
+func (r *RecordingCache) Calls() []Call {
+	r.l.Lock()
+	defer r.l.Unlock()
+	return append([]Call(nil), r.calls...)
+}
+
+// CallsTo returns the recorded calls of method, oldest first.
+func (r *RecordingCache) CallsTo(method string) []Call {
+	var calls []Call
+	for _, call := range r.Calls() {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Reset forgets the recorded calls.
+func (r *RecordingCache) Reset() {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.calls = nil
+}
+
+func (r *RecordingCache) Name() string {
+	return r.Cache.Name()
+}
+
+func (r *RecordingCache) Set(key string, value any, ttl time.Duration) error {
+	err := r.Cache.Set(key, value, ttl)
+	r.record("Set", []any{key, value, ttl}, nil, err)
+	return err
+}
+
+func (r *RecordingCache) Has(key string) (bool, error) {
+	ok, err := r.Cache.Has(key)
+	r.record("Has", []any{key}, ok, err)
+	return ok, err
+}
+
+func (r *RecordingCache) GetMulti(keys []string) ([]any, error) {
+	values, err := r.Cache.GetMulti(keys)
+	r.record("GetMulti", []any{keys}, values, err)
+	return values, err
+}
+
+func (r *RecordingCache) Get(key string) (any, error) {
+	val, err := r.Cache.Get(key)
+	r.record("Get", []any{key}, val, err)
+	return val, err
+}
+
+func (r *RecordingCache) Delete(key string) error {
+	err := r.Cache.Delete(key)
+	r.record("Delete", []any{key}, nil, err)
+	return err
+}
+
+func (r *RecordingCache) Increment(key string, step int) error {
+	err := r.Cache.Increment(key, step)
+	r.record("Increment", []any{key, step}, nil, err)
+	return err
+}
+
+func (r *RecordingCache) Decrement(key string, step int) error {
+	err := r.Cache.Decrement(key, step)
+	r.record("Decrement", []any{key, step}, nil, err)
+	return err
+}
+
+func (r *RecordingCache) Clear() error {
+	err := r.Cache.Clear()
+	r.record("Clear", nil, nil, err)
+	return err
+}
+
+// Ping pings the wrapped store, or succeeds when it is not a Pinger.
+func (r *RecordingCache) Ping(ctx context.Context) error {
+	var err error
+	if pinger, ok := r.Cache.(Pinger); ok {
+		err = pinger.Ping(ctx)
+	}
+	r.record("Ping", nil, nil, err)
+	return err
+}
+
+// Close closes the wrapped store, if it is an io.Closer.
+func (r *RecordingCache) Close() error {
+	var err error
+	if closer, ok := r.Cache.(io.Closer); ok {
+		err = closer.Close()
+	}
+	r.record("Close", nil, nil, err)
+	return err
+}
+
+func (r *RecordingCache) record(method string, args []any, result any, err error) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.calls = append(r.calls, Call{Method: method, Args: args, Result: result, Err: err})
+}
@@ -0,0 +1,34 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordingCache(t *testing.T) {
+	c := NewRecordingCache(NewMemoryCache(time.Minute))
+	rec := c.(*RecordingCache)
+	assert.Equal(t, MemoryCacheName, c.Name())
+
+	gc := New().Extend(c)
+	assert.Nil(t, gc.Set("key1", "author", time.Minute))
+	val, err := gc.Get("key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "author", val)
+	_, err = gc.Get("missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Nil(t, gc.Increment("counter", 2))
+
+	assert.Equal(t, []Call{
+		{Method: "Set", Args: []any{"key1", "author", time.Minute}},
+		{Method: "Get", Args: []any{"key1"}, Result: "author"},
+		{Method: "Get", Args: []any{"missing"}, Err: ErrNotFound},
+		{Method: "Increment", Args: []any{"counter", 2}},
+	}, rec.Calls())
+	assert.Len(t, rec.CallsTo("Get"), 2)
+
+	rec.Reset()
+	assert.Empty(t, rec.Calls())
+}
@@ -1,9 +1,11 @@
 package redis
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
@@ -13,10 +15,15 @@ import (
 // DefaultKey defines the collection name of redis for the cache adapter.
 var DefaultKey = "gocache"
 
+// maxWatchRetries bounds the optimistic transaction loop of the counters.
+const maxWatchRetries = 100
+
 type Cache struct {
 	Redis     *redis.Pool // redis connection pool
 	Key       string
 	CacheItem cache.ICacheItem
+	// l guards CacheItem, which SetCacheItem mutates while encoding
+	l sync.Mutex
 }
 type CacheOptions func(c *Cache)
 
@@ -44,7 +51,7 @@ func defaultRedisPool() *redis.Pool {
 		Dial: func() (c redis.Conn, err error) {
 			c, err = redis.Dial("tcp", "127.0.0.1:6379")
 			if err != nil {
-				return nil, fmt.Errorf("could not dial to remote redis server: %s ", "127.0.0.1:6379")
+				return nil, fmt.Errorf("could not dial to remote redis server: %s: %w", "127.0.0.1:6379", err)
 			}
 			if _, doErr := c.Do("SELECT", 8); doErr != nil {
 				_ = c.Close()
@@ -75,24 +82,40 @@ func (c *Cache) Name() string {
 
 // Set puts cache into redis.
 func (c *Cache) Set(key string, value any, ttl time.Duration) error {
-	valStr, err := c.CacheItem.SetCacheItem(value, ttl)
+	return c.SetContext(context.Background(), key, value, ttl)
+}
+
+// SetContext puts cache into redis, giving up when ctx is done.
+func (c *Cache) SetContext(ctx context.Context, key string, value any, ttl time.Duration) error {
+	commandName, args, err := c.setCommand(key, value, ttl)
 	if err != nil {
 		return err
 	}
-	commandName := "SETEX"
-	args := []any{key}
+	_, err = c.do(ctx, commandName, args...)
+	return err
+}
+
+// setCommand encodes value and returns the command storing it under key.
+// args[0] is key, not yet prefixed with Key.
+func (c *Cache) setCommand(key string, value any, ttl time.Duration) (string, []any, error) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	valStr, err := c.CacheItem.SetCacheItem(value, ttl)
+	if err != nil {
+		return "", nil, err
+	}
 	if c.CacheItem.IsNeverExpires() {
-		commandName = "SET"
-		args = append(args, valStr)
-	} else {
-		args = append(args, int64(c.CacheItem.GetTTL()/time.Second), valStr)
+		return "SET", []any{key, valStr}, nil
 	}
-	_, err = c.do(commandName, args...)
-	return err
+	return "PSETEX", []any{key, int64(c.CacheItem.GetTTL() / time.Millisecond), valStr}, nil
 }
 
 func (c *Cache) Has(key string) (bool, error) {
-	v, err := redis.Bool(c.do("EXISTS", key))
+	return c.HasContext(context.Background(), key)
+}
+
+func (c *Cache) HasContext(ctx context.Context, key string) (bool, error) {
+	v, err := redis.Bool(c.do(ctx, "EXISTS", key))
 	if err != nil {
 		return false, err
 	}
@@ -101,7 +124,15 @@ func (c *Cache) Has(key string) (bool, error) {
 
 // GetMulti gets cache from redis.
 func (c *Cache) GetMulti(keys []string) ([]any, error) {
-	conn := c.Redis.Get()
+	return c.GetMultiContext(context.Background(), keys)
+}
+
+// GetMultiContext gets cache from redis with one MGET.
+func (c *Cache) GetMultiContext(ctx context.Context, keys []string) ([]any, error) {
+	conn, err := c.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
 	defer func() {
 		_ = conn.Close()
 	}()
@@ -109,95 +140,173 @@ func (c *Cache) GetMulti(keys []string) ([]any, error) {
 	for _, key := range keys {
 		args = append(args, c.cacheKey(key))
 	}
-	values, err := redis.Values(conn.Do("MGET", args...))
+	values, err := redis.Values(redis.DoContext(conn, ctx, "MGET", args...))
 	if err != nil {
-		return nil, err
+		return nil, wrapError("MGET", err)
 	}
 	newValues := make([]any, len(values))
-	keysErr := make([]string, len(values))
+	var keysErr cache.MultiError
 	for i, value := range values {
 		item, err := c.CacheItem.GetCacheItem(value)
 		if err != nil {
-			keysErr[i] = err.Error()
+			keysErr = append(keysErr, cache.KeyError{Key: keys[i], Err: err})
 			continue
 		}
 		newValues[i] = item.GetData()
 	}
-	if len(keysErr) == 0 {
-		return newValues, nil
-	}
-	return newValues, fmt.Errorf(strings.Join(keysErr, "; "))
+	return newValues, keysErr.ErrorOrNil()
 }
 
 // Get cache from redis.
 func (c *Cache) Get(key string) (any, error) {
-	item, err := c.getCacheItem(key)
+	return c.GetContext(context.Background(), key)
+}
+
+func (c *Cache) GetContext(ctx context.Context, key string) (any, error) {
+	item, err := c.getItem(ctx, key)
 	if err != nil {
 		return nil, err
 	}
 	return item.GetData(), nil
 }
 
+// GetWithTTL gets key with the ttl left in its envelope.
+func (c *Cache) GetWithTTL(ctx context.Context, key string) (any, time.Duration, error) {
+	item, err := c.getItem(ctx, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	return cache.ItemWithTTL(item)
+}
+
+func (c *Cache) getItem(ctx context.Context, key string) (cache.ICacheItem, error) {
+	reply, err := c.do(ctx, "GET", key)
+	if err != nil {
+		return nil, err
+	}
+	return c.CacheItem.GetCacheItem(reply)
+}
+
 // Delete deletes a key's cache in redis.
 func (c *Cache) Delete(key string) error {
-	_, err := c.do("DEL", key)
+	return c.DeleteContext(context.Background(), key)
+}
+
+func (c *Cache) DeleteContext(ctx context.Context, key string) error {
+	_, err := c.do(ctx, "DEL", key)
 	return err
 }
 
 // Increment increases a key's counter in redis.
 func (c *Cache) Increment(key string, step int) error {
-	item, err := c.getCacheItem(key)
-	if err != nil {
-		return c.Set(key, step, 0)
-	}
-	data, err := cache.Increment(item.GetData(), step)
-	if err != nil {
-		return err
-	}
-	return c.Set(key, data, item.GetTTL())
+	return c.IncrementContext(context.Background(), key, step)
+}
+
+func (c *Cache) IncrementContext(ctx context.Context, key string, step int) error {
+	return c.update(ctx, key, step, step, cache.Increment)
 }
 
 // Decrement decreases a key's counter in redis.
 func (c *Cache) Decrement(key string, step int) error {
-	item, err := c.getCacheItem(key)
-	if err != nil {
-		return c.Set(key, step, 0)
-	}
-	data, err := cache.Decrement(item.GetData(), step)
+	return c.DecrementContext(context.Background(), key, step)
+}
+
+func (c *Cache) DecrementContext(ctx context.Context, key string, step int) error {
+	return c.update(ctx, key, step, -step, cache.Decrement)
+}
+
+// update applies fn to the counter under key in a transaction watching
+// the key, so concurrent updates from any number of clients are not lost.
+// A missing key is created holding initial.
+func (c *Cache) update(ctx context.Context, key string, step, initial int, fn func(originVal any, step int) (any, error)) error {
+	conn, err := c.conn(ctx)
 	if err != nil {
 		return err
 	}
-	return c.Set(key, data, item.GetTTL())
+	defer func() {
+		_ = conn.Close()
+	}()
+	cacheKey := c.cacheKey(key)
+	for i := 0; i < maxWatchRetries; i++ {
+		if _, err := redis.DoContext(conn, ctx, "WATCH", cacheKey); err != nil {
+			return wrapError("WATCH", err)
+		}
+		reply, err := redis.DoContext(conn, ctx, "GET", cacheKey)
+		if err != nil {
+			return wrapError("GET", err)
+		}
+		var (
+			val any = initial
+			ttl time.Duration
+		)
+		item, err := c.CacheItem.GetCacheItem(reply)
+		switch {
+		case cache.IsMiss(err):
+		case err != nil:
+			return err
+		default:
+			if val, err = fn(item.GetData(), step); err != nil {
+				return err
+			}
+			if !item.IsNeverExpires() {
+				if ttl = time.Until(item.GetExpirationTime()); ttl <= 0 {
+					val, ttl = initial, 0
+				}
+			}
+		}
+		commandName, args, err := c.setCommand(key, val, ttl)
+		if err != nil {
+			return err
+		}
+		args[0] = cacheKey
+		if err := conn.Send("MULTI"); err != nil {
+			return wrapError("MULTI", err)
+		}
+		if err := conn.Send(commandName, args...); err != nil {
+			return wrapError(commandName, err)
+		}
+		replies, err := redis.DoContext(conn, ctx, "EXEC")
+		if err != nil {
+			return wrapError("EXEC", err)
+		}
+		// a nil reply means a concurrent write to the key aborted EXEC
+		if replies == nil {
+			continue
+		}
+		if results, ok := replies.([]any); ok && len(results) == 1 {
+			if err, ok := results[0].(redis.Error); ok {
+				return wrapError(commandName, err)
+			}
+		}
+		return nil
+	}
+	return cache.WrapError(cache.ErrUnavailable, fmt.Errorf("key %s: too many concurrent updates", key))
 }
 
 // Clear deletes all cache in the redis collection
 // Be careful about this method, because it scans all keys and the delete them one by one
 func (c *Cache) Clear() error {
-	cachedKeys, err := c.Scan(c.Key + ":*")
+	return c.ClearContext(context.Background())
+}
+
+func (c *Cache) ClearContext(ctx context.Context) error {
+	conn, err := c.conn(ctx)
 	if err != nil {
 		return err
 	}
-	conn := c.Redis.Get()
 	defer func() {
 		_ = conn.Close()
 	}()
-	for _, str := range cachedKeys {
-		if _, err = conn.Do("DEL", str); err != nil {
-			return err
-		}
+	cachedKeys, err := scan(ctx, conn, c.Key+":*")
+	if err != nil {
+		return wrapError("SCAN", err)
 	}
-	return err
-}
-func (c *Cache) getCacheItem(key string) (item cache.ICacheItem, err error) {
-	if v, err := c.do("GET", key); err == nil {
-		item, err = c.CacheItem.GetCacheItem(v)
-		if err != nil {
-			return nil, err
+	for _, str := range cachedKeys {
+		if _, err = redis.DoContext(conn, ctx, "DEL", str); err != nil {
+			return wrapError("DEL", err)
 		}
-		return item, nil
-	} else {
-		return nil, err
 	}
+	return nil
 }
 
 // cacheKey with config key.
@@ -205,36 +314,76 @@ func (c *Cache) cacheKey(originKey any) string {
 	return fmt.Sprintf("%s:%s", c.Key, originKey)
 }
 
+// conn takes a connection from the pool, waiting at most until ctx is done.
+func (c *Cache) conn(ctx context.Context) (redis.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	conn, err := c.Redis.GetContext(ctx)
+	if err != nil {
+		return nil, cache.WrapError(cache.ErrUnavailable, err)
+	}
+	return conn, nil
+}
+
 // Execute the redis commands. args[0] must be the key name
-func (c *Cache) do(commandName string, args ...any) (any, error) {
+func (c *Cache) do(ctx context.Context, commandName string, args ...any) (any, error) {
 	if len(args) == 0 {
 		return nil, errors.New("args is 0")
 	}
 	args[0] = c.cacheKey(args[0])
-	conn := c.Redis.Get()
+	conn, err := c.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
 	defer func() {
 		_ = conn.Close()
 	}()
-	reply, err := conn.Do(commandName, args...)
+	reply, err := redis.DoContext(conn, ctx, commandName, args...)
 	if err != nil {
-		return nil, fmt.Errorf("could not execute this command: %s", commandName)
+		return nil, wrapError(commandName, err)
 	}
 	return reply, nil
 }
 
+// unavailableReplies are the error replies of a server that cannot serve
+// for now, e.g. while loading its dataset or without a reachable master.
+var unavailableReplies = []string{"LOADING", "BUSY", "MASTERDOWN", "CLUSTERDOWN", "TRYAGAIN", "READONLY"}
+
+// wrapError maps the errors of redigo onto the shared cache errors. An
+// error reply of the server, such as WRONGTYPE, is an answer rather than a
+// failure of the backend, and is returned as is.
+func wrapError(commandName string, err error) error {
+	err = fmt.Errorf("could not execute this command: %s: %w", commandName, err)
+	var reply redis.Error
+	if !errors.As(err, &reply) {
+		return cache.WrapError(cache.ErrUnavailable, err)
+	}
+	for _, prefix := range unavailableReplies {
+		if strings.HasPrefix(string(reply), prefix+" ") {
+			return cache.WrapError(cache.ErrUnavailable, err)
+		}
+	}
+	return err
+}
+
 // Scan scans all keys matching a given pattern.
-func (c *Cache) Scan(pattern string) (keys []string, err error) {
+func (c *Cache) Scan(pattern string) ([]string, error) {
 	conn := c.Redis.Get()
 	defer func() {
 		_ = conn.Close()
 	}()
+	return scan(context.Background(), conn, pattern)
+}
+
+func scan(ctx context.Context, conn redis.Conn, pattern string) (keys []string, err error) {
 	var (
 		cursor uint64 = 0 // start
 		result []interface{}
 		list   []string
 	)
 	for {
-		result, err = redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern, "COUNT", 1024))
+		result, err = redis.Values(redis.DoContext(conn, ctx, "SCAN", cursor, "MATCH", pattern, "COUNT", 1024))
 		if err != nil {
 			return
 		}
@@ -252,3 +401,23 @@ func (c *Cache) Scan(pattern string) (keys []string, err error) {
 		}
 	}
 }
+
+// Ping sends PING over a pooled connection.
+func (c *Cache) Ping(ctx context.Context) error {
+	conn, err := c.conn(ctx)
+	if err != nil {
+		return cache.WrapError(cache.ErrUnavailable, err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if _, err := redis.DoContext(conn, ctx, "PING"); err != nil {
+		return cache.WrapError(cache.ErrUnavailable, err)
+	}
+	return nil
+}
+
+// Close closes the connection pool.
+func (c *Cache) Close() error {
+	return c.Redis.Close()
+}
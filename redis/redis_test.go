@@ -1,6 +1,7 @@
 package redis
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -8,6 +9,8 @@ import (
 	"time"
 
 	"github.com/pkg6/go-cache"
+	"github.com/pkg6/go-cache/cachetest"
+	"github.com/pkg6/go-cache/redis/redistest"
 
 	"github.com/gomodule/redigo/redis"
 	"github.com/stretchr/testify/assert"
@@ -19,11 +22,17 @@ type Suite struct {
 	driver string
 	dsn    string
 	cache  cache.Cache
+	pool   *redis.Pool
+	server *redistest.Server
 }
 
 func (s *Suite) SetupSuite() {
 	t := s.T()
 	maxTryCnt := 10
+	if s.dsn == "" {
+		s.server = redistest.NewServer()
+		s.dsn = s.server.Addr
+	}
 
 	dialFunc := func() (c redis.Conn, err error) {
 		c, err = redis.Dial("tcp", s.dsn)
@@ -62,6 +71,7 @@ func (s *Suite) SetupSuite() {
 		t.Fatal(err)
 	}
 
+	s.pool = pool
 	bm := New(CacheWithRedisPool(pool), CacheWithKey("test"))
 	if err != nil {
 		t.Fatal(err)
@@ -69,6 +79,12 @@ func (s *Suite) SetupSuite() {
 	s.cache = bm
 }
 
+func (s *Suite) TearDownSuite() {
+	if s.server != nil {
+		_ = s.server.Close()
+	}
+}
+
 type RedisCompositionTestSuite struct {
 	Suite
 }
@@ -185,11 +201,98 @@ func (s *RedisCompositionTestSuite) TestRedisCacheIncrAndDecr() {
 	}
 }
 
+func (s *RedisCompositionTestSuite) TestRedisCacheErrors() {
+	t := s.T()
+	_, err := s.cache.Get("key-missing")
+	assert.ErrorIs(t, err, cache.ErrNotFound)
+
+	assert.Nil(t, s.cache.Set("key-present", "author", 5*time.Second))
+	_, err = s.cache.GetMulti([]string{"key-present", "key-missing"})
+	assert.ErrorIs(t, err, cache.ErrNotFound)
+
+	down := New(CacheWithRedisPool(&redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", "127.0.0.1:1")
+		},
+	}))
+	_, err = down.Get("key")
+	assert.ErrorIs(t, err, cache.ErrUnavailable)
+
+	// error replies are answers of a healthy server
+	_, err = s.cache.(*Cache).do(context.Background(), "NOSUCHCOMMAND", "key")
+	var reply redis.Error
+	assert.ErrorAs(t, err, &reply)
+	assert.NotErrorIs(t, err, cache.ErrUnavailable)
+	assert.ErrorIs(t, wrapError("GET", redis.Error("LOADING Redis is loading the dataset in memory")), cache.ErrUnavailable)
+}
+
+func (s *RedisCompositionTestSuite) TestRedisCacheContext() {
+	t := s.T()
+	c := s.cache.(*Cache)
+	assert.Same(t, c, cache.NewContextCache(c))
+	ctx := context.Background()
+	assert.Nil(t, c.SetContext(ctx, "ctx", "author", time.Minute))
+	assert.Nil(t, c.IncrementContext(ctx, "ctx-counter", 2))
+	val, err := c.GetContext(ctx, "ctx")
+	assert.Nil(t, err)
+	assert.Equal(t, "author", val)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = c.GetContext(canceled, "ctx")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, c.IncrementContext(canceled, "ctx-counter", 1), context.Canceled)
+	assert.ErrorIs(t, c.ClearContext(canceled), context.Canceled)
+	val, err = c.GetContext(ctx, "ctx-counter")
+	assert.Nil(t, err)
+	assert.Equal(t, float64(2), val)
+}
+
+func (s *RedisCompositionTestSuite) TestRedisCachePingClose() {
+	t := s.T()
+	c := New(CacheWithRedisPool(&redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", s.dsn)
+		},
+	}))
+	assert.Nil(t, c.(*Cache).Ping(context.Background()))
+	assert.Nil(t, c.(*Cache).Close())
+	assert.ErrorIs(t, c.(*Cache).Ping(context.Background()), cache.ErrUnavailable)
+
+	down := New(CacheWithRedisPool(&redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", "127.0.0.1:1")
+		},
+	}))
+	assert.ErrorIs(t, down.(*Cache).Ping(context.Background()), cache.ErrUnavailable)
+}
+
+func (s *RedisCompositionTestSuite) TestRedisCacheConformance() {
+	var n int
+	cachetest.RunConformance(s.T(), func(t *testing.T) cache.Cache {
+		n++
+		c := New(CacheWithRedisPool(s.pool), CacheWithKey(fmt.Sprintf("conformance-%d", n)))
+		t.Cleanup(func() {
+			_ = c.Clear()
+		})
+		return c
+	})
+}
+
+func (s *RedisCompositionTestSuite) TestRedisCacheLogging() {
+	cachetest.RunLogging(s.T(), func(t *testing.T) cache.Cache {
+		c := New(CacheWithRedisPool(s.pool), CacheWithKey("logging"))
+		t.Cleanup(func() {
+			_ = c.Clear()
+		})
+		return c
+	})
+}
+
 func TestRedisComposition(t *testing.T) {
+	// REDIS_ADDR runs the suite against a real server, e.g. the one in
+	// script/docker-compose.yml; otherwise an in-process server is used.
 	redisAddr := os.Getenv("REDIS_ADDR")
-	if redisAddr == "" {
-		redisAddr = "127.0.0.1:6379"
-	}
 	suite.Run(t, &RedisCompositionTestSuite{
 		Suite{
 			driver: "redis",
@@ -0,0 +1,498 @@
+// Package redistest provides an in-process redis server for tests.
+//
+// It speaks enough of the RESP protocol for the redis adapter, transactions
+// with WATCH included, and keeps its data in a cache.MemoryCache, so tests
+// run without a real redis:
+//
+//	srv := redistest.NewServer()
+//	defer srv.Close()
+//	c := redis.New(redis.CacheWithRedisPool(srv.Pool()))
+package redistest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg6/go-cache"
+)
+
+// Server is a RESP server listening on a random localhost port.
+type Server struct {
+	// Addr is the host:port the server listens on
+	Addr string
+
+	listener net.Listener
+	wg       sync.WaitGroup
+	l        sync.Mutex
+	dbs      map[int]*cache.MemoryCache
+	conns    map[net.Conn]struct{}
+	closed   bool
+	// versions counts the writes of every key, and epoch the flushes, so
+	// EXEC can tell whether a watched key changed
+	versions map[string]uint64
+	epoch    uint64
+	// commands runs one command at a time, like redis does
+	commands sync.Mutex
+}
+
+// NewServer starts a server on 127.0.0.1 with a random port.
+// It panics if the port cannot be opened, like httptest.NewServer.
+func NewServer() *Server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("redistest: failed to listen on a port: %v", err))
+	}
+	s := &Server{
+		Addr:     ln.Addr().String(),
+		listener: ln,
+		dbs:      make(map[int]*cache.MemoryCache),
+		conns:    make(map[net.Conn]struct{}),
+		versions: make(map[string]uint64),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// Pool returns a redis pool dialing the server.
+func (s *Server) Pool() *redis.Pool {
+	return &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", s.Addr)
+		},
+		MaxIdle:     3,
+		IdleTimeout: 3 * time.Second,
+	}
+}
+
+// Close shuts the listener and all open connections down.
+func (s *Server) Close() error {
+	s.l.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.l.Unlock()
+	err := s.listener.Close()
+	s.wg.Wait()
+	s.l.Lock()
+	for _, db := range s.dbs {
+		_ = db.Close()
+	}
+	s.l.Unlock()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.l.Lock()
+		if s.closed {
+			s.l.Unlock()
+			_ = conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.l.Unlock()
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) db(index int) *cache.MemoryCache {
+	s.l.Lock()
+	defer s.l.Unlock()
+	db, ok := s.dbs[index]
+	if !ok {
+		db = cache.NewMemoryCache(time.Minute).(*cache.MemoryCache)
+		s.dbs[index] = db
+	}
+	return db
+}
+
+// version returns the write count of key in the database index and the
+// flush count.
+func (s *Server) version(index int, key string) [2]uint64 {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return [2]uint64{s.versions[strconv.Itoa(index)+":"+key], s.epoch}
+}
+
+// touch records a write to key in the database index.
+func (s *Server) touch(index int, key string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.versions[strconv.Itoa(index)+":"+key]++
+}
+
+// flush records a flush of every database.
+func (s *Server) flush() {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.epoch++
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.l.Lock()
+		delete(s.conns, conn)
+		s.l.Unlock()
+		_ = conn.Close()
+	}()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	session := &session{server: s}
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				writeError(w, err.Error())
+				_ = w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		s.commands.Lock()
+		quit := session.exec(w, strings.ToUpper(args[0]), args[1:])
+		s.commands.Unlock()
+		if err := w.Flush(); err != nil || quit {
+			return
+		}
+	}
+}
+
+type session struct {
+	server *Server
+	index  int
+	// multi is set between MULTI and EXEC, which runs queued
+	multi   bool
+	queued  [][]string
+	watched map[string][2]uint64
+}
+
+func (c *session) exec(w *bufio.Writer, cmd string, args []string) (quit bool) {
+	db := c.server.db(c.index)
+	if c.multi && cmd != "EXEC" && cmd != "DISCARD" && cmd != "MULTI" && cmd != "WATCH" {
+		c.queued = append(c.queued, append([]string{cmd}, args...))
+		writeStatus(w, "QUEUED")
+		return
+	}
+	switch cmd {
+	case "WATCH":
+		if len(args) == 0 {
+			writeArity(w, cmd)
+			return
+		}
+		if c.multi {
+			writeError(w, "ERR WATCH inside MULTI is not allowed")
+			return
+		}
+		if c.watched == nil {
+			c.watched = make(map[string][2]uint64)
+		}
+		for _, key := range args {
+			watched := strconv.Itoa(c.index) + ":" + key
+			if _, ok := c.watched[watched]; !ok {
+				c.watched[watched] = c.server.version(c.index, key)
+			}
+		}
+		writeStatus(w, "OK")
+	case "UNWATCH":
+		c.watched = nil
+		writeStatus(w, "OK")
+	case "MULTI":
+		if c.multi {
+			writeError(w, "ERR MULTI calls can not be nested")
+			return
+		}
+		c.multi = true
+		writeStatus(w, "OK")
+	case "DISCARD":
+		if !c.multi {
+			writeError(w, "ERR DISCARD without MULTI")
+			return
+		}
+		c.multi, c.queued, c.watched = false, nil, nil
+		writeStatus(w, "OK")
+	case "EXEC":
+		if !c.multi {
+			writeError(w, "ERR EXEC without MULTI")
+			return
+		}
+		queued, watched := c.queued, c.watched
+		c.multi, c.queued, c.watched = false, nil, nil
+		for key, version := range watched {
+			index, key, _ := strings.Cut(key, ":")
+			n, _ := strconv.Atoi(index)
+			if c.server.version(n, key) != version {
+				_, _ = w.WriteString("*-1\r\n")
+				return
+			}
+		}
+		writeArrayHeader(w, len(queued))
+		for _, command := range queued {
+			c.exec(w, command[0], command[1:])
+		}
+	case "PING":
+		if len(args) > 0 {
+			writeBulk(w, &args[0])
+			return
+		}
+		writeStatus(w, "PONG")
+	case "ECHO":
+		if len(args) != 1 {
+			writeArity(w, cmd)
+			return
+		}
+		writeBulk(w, &args[0])
+	case "QUIT":
+		writeStatus(w, "OK")
+		return true
+	case "SELECT":
+		if len(args) != 1 {
+			writeArity(w, cmd)
+			return
+		}
+		index, err := strconv.Atoi(args[0])
+		if err != nil || index < 0 {
+			writeError(w, "ERR DB index is out of range")
+			return
+		}
+		c.index = index
+		writeStatus(w, "OK")
+	case "GET":
+		if len(args) != 1 {
+			writeArity(w, cmd)
+			return
+		}
+		writeBulk(w, get(db, args[0]))
+	case "MGET":
+		if len(args) == 0 {
+			writeArity(w, cmd)
+			return
+		}
+		writeArrayHeader(w, len(args))
+		for _, key := range args {
+			writeBulk(w, get(db, key))
+		}
+	case "SET":
+		if len(args) < 2 {
+			writeArity(w, cmd)
+			return
+		}
+		var ttl time.Duration
+		for i := 2; i < len(args); i++ {
+			unit := time.Duration(0)
+			switch strings.ToUpper(args[i]) {
+			case "EX":
+				unit = time.Second
+			case "PX":
+				unit = time.Millisecond
+			default:
+				writeError(w, "ERR syntax error")
+				return
+			}
+			if i+1 >= len(args) {
+				writeError(w, "ERR syntax error")
+				return
+			}
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil || n <= 0 {
+				writeError(w, "ERR invalid expire time in 'set' command")
+				return
+			}
+			ttl = time.Duration(n) * unit
+		}
+		_ = db.Set(args[0], args[1], ttl)
+		c.server.touch(c.index, args[0])
+		writeStatus(w, "OK")
+	case "SETEX", "PSETEX":
+		if len(args) != 3 {
+			writeArity(w, cmd)
+			return
+		}
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || n <= 0 {
+			writeError(w, fmt.Sprintf("ERR invalid expire time in '%s' command", strings.ToLower(cmd)))
+			return
+		}
+		unit := time.Second
+		if cmd == "PSETEX" {
+			unit = time.Millisecond
+		}
+		_ = db.Set(args[0], args[2], time.Duration(n)*unit)
+		c.server.touch(c.index, args[0])
+		writeStatus(w, "OK")
+	case "DEL", "EXISTS":
+		if len(args) == 0 {
+			writeArity(w, cmd)
+			return
+		}
+		var n int64
+		for _, key := range args {
+			if get(db, key) != nil {
+				n++
+				if cmd == "DEL" {
+					_ = db.Delete(key)
+					c.server.touch(c.index, key)
+				}
+			}
+		}
+		writeInt(w, n)
+	case "KEYS":
+		if len(args) != 1 {
+			writeArity(w, cmd)
+			return
+		}
+		writeStrings(w, match(db.Keys(), args[0]))
+	case "SCAN":
+		if len(args) == 0 {
+			writeArity(w, cmd)
+			return
+		}
+		pattern := "*"
+		for i := 1; i+1 < len(args); i += 2 {
+			if strings.ToUpper(args[i]) == "MATCH" {
+				pattern = args[i+1]
+			}
+		}
+		// The whole keyspace is returned in one page, so the cursor is always 0.
+		writeArrayHeader(w, 2)
+		zero := "0"
+		writeBulk(w, &zero)
+		writeStrings(w, match(db.Keys(), pattern))
+	case "FLUSHDB":
+		_ = db.Clear()
+		c.server.flush()
+		writeStatus(w, "OK")
+	case "FLUSHALL":
+		c.server.l.Lock()
+		for _, db := range c.server.dbs {
+			_ = db.Clear()
+		}
+		c.server.l.Unlock()
+		c.server.flush()
+		writeStatus(w, "OK")
+	default:
+		writeError(w, fmt.Sprintf("ERR unknown command '%s'", strings.ToLower(cmd)))
+	}
+	return
+}
+
+func get(db *cache.MemoryCache, key string) *string {
+	val, err := db.Get(key)
+	if err != nil {
+		return nil
+	}
+	str := val.(string)
+	return &str
+}
+
+func match(keys []string, pattern string) []string {
+	matched := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if ok, _ := path.Match(pattern, key); ok {
+			matched = append(matched, key)
+		}
+	}
+	return matched
+}
+
+// readCommand reads one RESP array of bulk strings.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, nil
+	}
+	if line[0] != '*' {
+		// inline command, e.g. from telnet
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 {
+		return nil, errors.New("ERR Protocol error: invalid multibulk length")
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, errors.New("ERR Protocol error: expected '$'")
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, errors.New("ERR Protocol error: invalid bulk length")
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func writeStatus(w *bufio.Writer, status string) {
+	_, _ = fmt.Fprintf(w, "+%s\r\n", status)
+}
+
+func writeError(w *bufio.Writer, msg string) {
+	_, _ = fmt.Fprintf(w, "-%s\r\n", msg)
+}
+
+func writeArity(w *bufio.Writer, cmd string) {
+	writeError(w, fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+}
+
+func writeInt(w *bufio.Writer, n int64) {
+	_, _ = fmt.Fprintf(w, ":%d\r\n", n)
+}
+
+func writeBulk(w *bufio.Writer, s *string) {
+	if s == nil {
+		_, _ = w.WriteString("$-1\r\n")
+		return
+	}
+	_, _ = fmt.Fprintf(w, "$%d\r\n%s\r\n", len(*s), *s)
+}
+
+func writeArrayHeader(w *bufio.Writer, n int) {
+	_, _ = fmt.Fprintf(w, "*%d\r\n", n)
+}
+
+func writeStrings(w *bufio.Writer, values []string) {
+	writeArrayHeader(w, len(values))
+	for i := range values {
+		writeBulk(w, &values[i])
+	}
+}
@@ -0,0 +1,579 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultRepairChance is the share of the hits of a ReplicatedCache
+// checked for read repair.
+const DefaultRepairChance = 0.1
+
+// replicaFailurePenalty is added to the latency of a failed call, so that
+// ReadNearest stops preferring a replica failing fast.
+const replicaFailurePenalty = time.Second
+
+// ErrQuorum is matched by the QuorumError of a write that too few replicas
+// acknowledged.
+var ErrQuorum = errors.New("cache: write quorum not reached")
+
+// WriteQuorum is the number of replicas that must acknowledge a write.
+type WriteQuorum int
+
+const (
+	QuorumMajority WriteQuorum = iota
+	QuorumAll
+	QuorumOne
+)
+
+// ReadPolicy orders the replicas a read tries.
+type ReadPolicy int
+
+const (
+	// ReadFirstSuccess tries the replicas in order
+	ReadFirstSuccess ReadPolicy = iota
+	// ReadNearest tries the replicas with the lowest recent latency first
+	ReadNearest
+)
+
+// QuorumError is returned by a write that fewer than Required replicas
+// acknowledged. errors.Is matches ErrQuorum and the errors of the replicas.
+type QuorumError struct {
+	Acks     int
+	Required int
+	Errors   StoreErrors
+}
+
+func (e *QuorumError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("%s: %d of %d required acks", ErrQuorum.Error(), e.Acks, e.Required)
+	}
+	return fmt.Sprintf("%s: %d of %d required acks: %s", ErrQuorum.Error(), e.Acks, e.Required, e.Errors.Error())
+}
+
+func (e *QuorumError) Is(target error) bool {
+	return target == ErrQuorum
+}
+
+func (e *QuorumError) Unwrap() error {
+	return e.Errors
+}
+
+// ReplicatedCache mirrors writes to several stores, its replicas, and
+// reads from any of them.
+//
+// A write goes to every replica in parallel and returns once WriteQuorum of
+// them acknowledged it; the others finish in the background. A read tries
+// the replicas in the order of ReadPolicy until one answers without
+// failing, a miss being an answer. Replicas that fail are reported to
+// OnFailure and counted by Failures.
+type ReplicatedCache struct {
+	WriteQuorum WriteQuorum
+	ReadPolicy  ReadPolicy
+	// Repair copies the value a Get found, with the ttl it has left, to
+	// the replicas missing it or holding another one, in the background.
+	// Only values read from a TTLGetter are repaired. A write to the key
+	// through the ReplicatedCache cancels the repairs that read before it
+	// finished, and waits for a repair write already under way, so it is
+	// never overwritten by one; writes of other processes are not seen.
+	Repair bool
+	// RepairChance is the share of the hits checked for repair, each
+	// check reading the key from every other replica
+	RepairChance float64
+	// OnFailure is called with every failed call to a replica, possibly
+	// from several goroutines at once
+	OnFailure func(store, method string, err error)
+
+	replicas []*replica
+	pending  sync.WaitGroup
+	repairs  uint64
+	// repairing holds the keys read for repair, whose writes cancel it
+	repairing map[string]*repairState
+	// writing counts the writes in flight per key, clearing the Clears
+	writing  map[string]int
+	clearing int
+	l        sync.Mutex
+}
+
+type repairState struct {
+	// gen is moved on by every write to the key, as it begins and ends
+	gen uint64
+	// reads is the number of repairs of the key in flight
+	reads int
+	// writeL is held by a repair across its check and its write, and
+	// waited for by the writes beginning
+	writeL sync.Mutex
+}
+
+type replica struct {
+	name     string
+	cache    ContextCache
+	latency  time.Duration
+	failures uint64
+}
+
+type replicaResult struct {
+	replica *replica
+	err     error
+}
+
+// NewReplicatedCache returns a ReplicatedCache over stores. A replica is
+// named after its store, suffixed with its position in stores when the
+// name is taken, e.g. "redis" and "redis-1".
+func NewReplicatedCache(stores ...Cache) *ReplicatedCache {
+	return newReplicatedCache(uniqueNames(stores), stores)
+}
+
+func newReplicatedCache(names []string, stores []Cache) *ReplicatedCache {
+	r := &ReplicatedCache{
+		RepairChance: DefaultRepairChance,
+		repairing:    make(map[string]*repairState),
+		writing:      make(map[string]int),
+	}
+	for i, c := range stores {
+		r.replicas = append(r.replicas, &replica{name: names[i], cache: NewContextCache(c)})
+	}
+	return r
+}
+
+// Replicated returns a ReplicatedCache over the stores registered under
+// names, which name the replicas. It goes through their Store facades, so
+// it follows Replace and the middlewares of Use.
+func (f *GoCache) Replicated(names ...string) (*ReplicatedCache, error) {
+	stores := make([]Cache, len(names))
+	for i, name := range names {
+		store, err := f.Store(name)
+		if err != nil {
+			return nil, err
+		}
+		stores[i] = store
+	}
+	return newReplicatedCache(names, stores), nil
+}
+
+// uniqueNames names stores after their Name, suffixed with their position
+// when the name is taken.
+func uniqueNames(stores []Cache) []string {
+	names := make([]string, len(stores))
+	taken := make(map[string]bool, len(stores))
+	for i, c := range stores {
+		name := c.Name()
+		if taken[name] {
+			name += "-" + strconv.Itoa(i)
+		}
+		taken[name] = true
+		names[i] = name
+	}
+	return names
+}
+
+// Failures returns how many calls failed on each replica.
+func (r *ReplicatedCache) Failures() map[string]uint64 {
+	r.l.Lock()
+	defer r.l.Unlock()
+	failures := make(map[string]uint64, len(r.replicas))
+	for _, rep := range r.replicas {
+		failures[rep.name] = rep.failures
+	}
+	return failures
+}
+
+// Repairs returns how many entries read repair wrote.
+func (r *ReplicatedCache) Repairs() uint64 {
+	r.l.Lock()
+	defer r.l.Unlock()
+	return r.repairs
+}
+
+// Wait blocks until the writes and repairs still running in the
+// background are done.
+func (r *ReplicatedCache) Wait() {
+	r.pending.Wait()
+}
+
+// required returns the acks a write needs.
+func (r *ReplicatedCache) required() int {
+	switch r.WriteQuorum {
+	case QuorumAll:
+		return len(r.replicas)
+	case QuorumOne:
+		return 1
+	}
+	return len(r.replicas)/2 + 1
+}
+
+// observe accounts for a call to rep that took elapsed and failed with err
+// when it is a failure.
+func (r *ReplicatedCache) observe(rep *replica, method string, elapsed time.Duration, err error) {
+	failed := err != nil && !IsMiss(err)
+	if failed {
+		elapsed += replicaFailurePenalty
+	}
+	r.l.Lock()
+	if rep.latency == 0 {
+		rep.latency = elapsed
+	} else {
+		rep.latency = (rep.latency*7 + elapsed) / 8
+	}
+	if failed {
+		rep.failures++
+	}
+	r.l.Unlock()
+	if failed && r.OnFailure != nil {
+		r.OnFailure(rep.name, method, err)
+	}
+}
+
+// order returns the replicas in the order reads try them.
+func (r *ReplicatedCache) order() []*replica {
+	replicas := append([]*replica(nil), r.replicas...)
+	if r.ReadPolicy == ReadNearest {
+		r.l.Lock()
+		sort.SliceStable(replicas, func(i, j int) bool {
+			return replicas[i].latency < replicas[j].latency
+		})
+		r.l.Unlock()
+	}
+	return replicas
+}
+
+// write runs fn on every replica in parallel and returns once the quorum
+// is reached or can no longer be, or ctx is done. The writes outlive the
+// cancellation of ctx, since some of them may go on after write returns.
+// end, the result of begin when not nil, is called once they are all done.
+func (r *ReplicatedCache) write(ctx context.Context, method string, end func(), fn func(ctx context.Context, c ContextCache) error) error {
+	background := context.WithoutCancel(ctx)
+	required := r.required()
+	results := make(chan replicaResult, len(r.replicas))
+	var left sync.WaitGroup
+	left.Add(len(r.replicas))
+	for _, rep := range r.replicas {
+		r.pending.Add(1)
+		go func(rep *replica) {
+			defer r.pending.Done()
+			defer left.Done()
+			start := time.Now()
+			err := fn(background, rep.cache)
+			r.observe(rep, method, time.Since(start), err)
+			results <- replicaResult{replica: rep, err: err}
+		}(rep)
+	}
+	if end != nil {
+		r.pending.Add(1)
+		go func() {
+			defer r.pending.Done()
+			left.Wait()
+			end()
+		}()
+	}
+	var (
+		acks      int
+		storesErr StoreErrors
+	)
+	for range r.replicas {
+		var result replicaResult
+		select {
+		case result = <-results:
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %w", &QuorumError{Acks: acks, Required: required, Errors: storesErr}, ctx.Err())
+		}
+		if result.err == nil {
+			if acks++; acks >= required {
+				return nil
+			}
+			continue
+		}
+		storesErr = append(storesErr, StoreError{Store: result.replica.name, Err: result.err})
+		if len(storesErr) > len(r.replicas)-required {
+			return &QuorumError{Acks: acks, Required: required, Errors: storesErr}
+		}
+	}
+	return &QuorumError{Acks: acks, Required: required, Errors: storesErr}
+}
+
+// read runs fn on the replicas in read order until one does not fail, and
+// returns that one, or nil with the last failure.
+func (r *ReplicatedCache) read(ctx context.Context, method string, fn func(ctx context.Context, c ContextCache) error) (*replica, error) {
+	err := WrapError(ErrUnavailable, ErrNoHealthyStore)
+	for _, rep := range r.order() {
+		ctxErr := ctx.Err()
+		if ctxErr != nil {
+			return nil, ctxErr
+		}
+		start := time.Now()
+		err = fn(ctx, rep.cache)
+		r.observe(rep, method, time.Since(start), err)
+		if !isFailure(ctxErr, err) {
+			return rep, err
+		}
+	}
+	return nil, err
+}
+
+// track registers a read of key for repair and returns the generation of
+// key; untrack ends it.
+func (r *ReplicatedCache) track(key string) uint64 {
+	r.l.Lock()
+	defer r.l.Unlock()
+	state, ok := r.repairing[key]
+	if !ok {
+		state = &repairState{}
+		r.repairing[key] = state
+	}
+	state.reads++
+	return state.gen
+}
+
+func (r *ReplicatedCache) untrack(key string) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	state := r.repairing[key]
+	if state.reads--; state.reads == 0 {
+		delete(r.repairing, key)
+	}
+}
+
+// begin registers a write of key, or a Clear when all is set, before it
+// goes to the replicas, and returns the function ending it. Both move on
+// the generation of the keys written, cancelling the repairs that read
+// them before; begin also waits for a repair write under way.
+func (r *ReplicatedCache) begin(key string, all bool) func() {
+	r.l.Lock()
+	if all {
+		r.clearing++
+	} else {
+		r.writing[key]++
+	}
+	states := r.changed(key, all)
+	r.l.Unlock()
+	for _, state := range states {
+		// wait for the repair write holding it, if any
+		state.writeL.Lock()
+		state.writeL.Unlock()
+	}
+	return func() {
+		r.l.Lock()
+		defer r.l.Unlock()
+		if all {
+			r.clearing--
+		} else if r.writing[key]--; r.writing[key] == 0 {
+			delete(r.writing, key)
+		}
+		r.changed(key, all)
+	}
+}
+
+// changed moves on the generation of key, or of every key when all is
+// set, and returns their states. r.l must be held.
+func (r *ReplicatedCache) changed(key string, all bool) []*repairState {
+	var states []*repairState
+	for k, state := range r.repairing {
+		if all || k == key {
+			state.gen++
+			states = append(states, state)
+		}
+	}
+	return states
+}
+
+// unchanged reports whether key is still at generation gen, with no write
+// in flight. r.l must be held.
+func (r *ReplicatedCache) unchanged(key string, gen uint64) bool {
+	return r.repairing[key].gen == gen && r.writing[key] == 0 && r.clearing == 0
+}
+
+// repairWrite runs set, the repair write of key read at generation gen,
+// unless key changed since; writes beginning meanwhile wait for it.
+func (r *ReplicatedCache) repairWrite(key string, gen uint64, set func() error) (bool, error) {
+	r.l.Lock()
+	state := r.repairing[key]
+	r.l.Unlock()
+	state.writeL.Lock()
+	defer state.writeL.Unlock()
+	r.l.Lock()
+	ok := r.unchanged(key, gen)
+	r.l.Unlock()
+	if !ok {
+		return false, nil
+	}
+	return true, set()
+}
+
+// repair copies val under key, expiring at expires or never when it is
+// zero, from served to the other replicas that miss it or hold another
+// value, unless key is written after generation gen.
+func (r *ReplicatedCache) repair(served *replica, key string, val any, expires time.Time, gen uint64) {
+	r.pending.Add(1)
+	go func() {
+		defer r.pending.Done()
+		defer r.untrack(key)
+		ctx := context.Background()
+		for _, rep := range r.replicas {
+			if rep == served {
+				continue
+			}
+			current, err := rep.cache.GetContext(ctx, key)
+			if (err == nil && reflect.DeepEqual(current, val)) || (err != nil && !IsMiss(err)) {
+				continue
+			}
+			var ttl time.Duration
+			if !expires.IsZero() {
+				if ttl = time.Until(expires); ttl <= 0 {
+					return
+				}
+			}
+			start := time.Now()
+			ok, err := r.repairWrite(key, gen, func() error {
+				return rep.cache.SetContext(ctx, key, val, ttl)
+			})
+			if !ok {
+				return
+			}
+			r.observe(rep, "Repair", time.Since(start), err)
+			if err == nil {
+				r.l.Lock()
+				r.repairs++
+				r.l.Unlock()
+			}
+		}
+	}()
+}
+
+func (r *ReplicatedCache) Name() string {
+	return ReplicatedCacheName
+}
+
+func (r *ReplicatedCache) Set(key string, value any, ttl time.Duration) error {
+	return r.SetContext(context.Background(), key, value, ttl)
+}
+
+func (r *ReplicatedCache) Has(key string) (bool, error) {
+	return r.HasContext(context.Background(), key)
+}
+
+func (r *ReplicatedCache) GetMulti(keys []string) ([]any, error) {
+	return r.GetMultiContext(context.Background(), keys)
+}
+
+func (r *ReplicatedCache) Get(key string) (any, error) {
+	return r.GetContext(context.Background(), key)
+}
+
+func (r *ReplicatedCache) Delete(key string) error {
+	return r.DeleteContext(context.Background(), key)
+}
+
+func (r *ReplicatedCache) Increment(key string, step int) error {
+	return r.IncrementContext(context.Background(), key, step)
+}
+
+func (r *ReplicatedCache) Decrement(key string, step int) error {
+	return r.DecrementContext(context.Background(), key, step)
+}
+
+func (r *ReplicatedCache) Clear() error {
+	return r.ClearContext(context.Background())
+}
+
+func (r *ReplicatedCache) SetContext(ctx context.Context, key string, value any, ttl time.Duration) error {
+	return r.write(ctx, "Set", r.begin(key, false), func(ctx context.Context, c ContextCache) error {
+		return c.SetContext(ctx, key, value, ttl)
+	})
+}
+
+func (r *ReplicatedCache) HasContext(ctx context.Context, key string) (bool, error) {
+	var ok bool
+	_, err := r.read(ctx, "Has", func(ctx context.Context, c ContextCache) (err error) {
+		ok, err = c.HasContext(ctx, key)
+		return err
+	})
+	return ok, err
+}
+
+func (r *ReplicatedCache) GetMultiContext(ctx context.Context, keys []string) ([]any, error) {
+	var values []any
+	_, err := r.read(ctx, "GetMulti", func(ctx context.Context, c ContextCache) (err error) {
+		values, err = c.GetMultiContext(ctx, keys)
+		return err
+	})
+	return values, err
+}
+
+func (r *ReplicatedCache) GetContext(ctx context.Context, key string) (any, error) {
+	var val any
+	if !r.Repair || rand.Float64() >= r.RepairChance {
+		_, err := r.read(ctx, "Get", func(ctx context.Context, c ContextCache) (err error) {
+			val, err = c.GetContext(ctx, key)
+			return err
+		})
+		return val, err
+	}
+	// the key is tracked before the read, so no write after it goes unseen
+	gen := r.track(key)
+	var (
+		ttl   time.Duration
+		known bool
+	)
+	served, err := r.read(ctx, "Get", func(ctx context.Context, c ContextCache) (err error) {
+		val, ttl, err = getWithTTL(ctx, c, key)
+		if known = !errors.Is(err, ErrTTLUnknown); known {
+			return err
+		}
+		val, err = c.GetContext(ctx, key)
+		return err
+	})
+	if err != nil || !known {
+		r.untrack(key)
+		return val, err
+	}
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+	r.repair(served, key, val, expires, gen)
+	return val, err
+}
+
+func (r *ReplicatedCache) DeleteContext(ctx context.Context, key string) error {
+	return r.write(ctx, "Delete", r.begin(key, false), func(ctx context.Context, c ContextCache) error {
+		return c.DeleteContext(ctx, key)
+	})
+}
+
+// IncrementContext increments the counter on every replica; the replicas
+// that miss a step drift apart until the key is set again.
+func (r *ReplicatedCache) IncrementContext(ctx context.Context, key string, step int) error {
+	return r.write(ctx, "Increment", r.begin(key, false), func(ctx context.Context, c ContextCache) error {
+		return c.IncrementContext(ctx, key, step)
+	})
+}
+
+func (r *ReplicatedCache) DecrementContext(ctx context.Context, key string, step int) error {
+	return r.write(ctx, "Decrement", r.begin(key, false), func(ctx context.Context, c ContextCache) error {
+		return c.DecrementContext(ctx, key, step)
+	})
+}
+
+func (r *ReplicatedCache) ClearContext(ctx context.Context) error {
+	return r.write(ctx, "Clear", r.begin("", true), func(ctx context.Context, c ContextCache) error {
+		return c.ClearContext(ctx)
+	})
+}
+
+// Ping succeeds when enough replicas answer to reach the write quorum. The
+// replicas are pinged under ctx.
+func (r *ReplicatedCache) Ping(ctx context.Context) error {
+	return r.write(ctx, "Ping", nil, func(_ context.Context, c ContextCache) error {
+		if pinger, ok := c.(Pinger); ok {
+			return pinger.Ping(ctx)
+		}
+		return nil
+	})
+}
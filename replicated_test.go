@@ -0,0 +1,236 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplicatedCacheQuorum(t *testing.T) {
+	a := &flakyCache{Cache: NewMemoryCache(time.Minute)}
+	b := &flakyCache{Cache: NewMemoryCache(time.Minute)}
+	c := &flakyCache{Cache: NewMemoryCache(time.Minute)}
+	r := NewReplicatedCache(a, b, c)
+	var (
+		l        sync.Mutex
+		failures []string
+	)
+	r.OnFailure = func(store, method string, err error) {
+		l.Lock()
+		defer l.Unlock()
+		failures = append(failures, method+"@"+store)
+	}
+	assert.Equal(t, ReplicatedCacheName, r.Name())
+
+	assert.Nil(t, r.Set("key1", "val1", time.Minute))
+	r.Wait()
+	for _, replica := range []Cache{a, b, c} {
+		val, err := replica.Get("key1")
+		assert.Nil(t, err)
+		assert.Equal(t, "val1", val)
+	}
+
+	// a majority of 2 out of 3 is still reached
+	c.down.Store(true)
+	assert.Nil(t, r.Set("key2", "val2", time.Minute))
+	r.Wait()
+	assert.Equal(t, []string{"Set@memory-2"}, failures)
+	assert.Equal(t, map[string]uint64{"memory": 0, "memory-1": 0, "memory-2": 1}, r.Failures())
+
+	r.WriteQuorum = QuorumAll
+	err := r.Set("key3", "val3", time.Minute)
+	assert.ErrorIs(t, err, ErrQuorum)
+	assert.ErrorIs(t, err, ErrUnavailable)
+	var quorumErr *QuorumError
+	assert.ErrorAs(t, err, &quorumErr)
+	assert.Equal(t, 3, quorumErr.Required)
+	assert.Equal(t, "memory-2", quorumErr.Errors[0].Store)
+
+	b.down.Store(true)
+	r.WriteQuorum = QuorumMajority
+	assert.ErrorIs(t, r.Set("key4", "val4", time.Minute), ErrQuorum)
+	r.WriteQuorum = QuorumOne
+	assert.Nil(t, r.Set("key4", "val4", time.Minute))
+	assert.Nil(t, r.Ping(context.Background()))
+	r.Wait()
+}
+
+func TestReplicatedCacheRead(t *testing.T) {
+	a := &flakyCache{Cache: NewMemoryCache(time.Minute)}
+	b := NewMemoryCache(time.Minute)
+	r := NewReplicatedCache(a, b)
+	assert.Nil(t, r.Set("key1", "val1", time.Minute))
+	r.Wait()
+
+	// the first replica failing, the read moves on to the next
+	a.down.Store(true)
+	val, err := r.Get("key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "val1", val)
+	values, err := r.GetMulti([]string{"key1", "missing"})
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, "val1", values[0])
+
+	// a miss is an answer
+	a.down.Store(false)
+	calls := a.calls.Load()
+	_, err = r.Get("missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, calls+1, a.calls.Load())
+
+	// the failures pushed a behind b
+	r.ReadPolicy = ReadNearest
+	calls = a.calls.Load()
+	val, err = r.Get("key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "val1", val)
+	assert.Equal(t, calls, a.calls.Load())
+}
+
+func TestReplicatedCacheRepair(t *testing.T) {
+	a := NewMemoryCache(time.Minute)
+	b := NewMemoryCache(time.Minute)
+	c := New().Extend(a, "zone-a").Extend(b, "zone-b")
+	r, err := c.Replicated("zone-a", "zone-b")
+	assert.Nil(t, err)
+	r.Repair = true
+	r.RepairChance = 1
+
+	// b missed the write, and then holds a stale value
+	assert.Nil(t, a.Set("key1", "val1", 10*time.Second))
+	val, err := r.Get("key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "val1", val)
+	r.Wait()
+	// the copy keeps the ttl left to the value
+	val, ttl, err := b.(TTLGetter).GetWithTTL(context.Background(), "key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "val1", val)
+	assert.InDelta(t, 10*time.Second, ttl, float64(time.Second))
+	assert.Equal(t, uint64(1), r.Repairs())
+
+	assert.Nil(t, b.Set("key1", "stale", time.Minute))
+	_, err = r.Get("key1")
+	assert.Nil(t, err)
+	r.Wait()
+	val, _ = b.Get("key1")
+	assert.Equal(t, "val1", val)
+	assert.Equal(t, uint64(2), r.Repairs())
+
+	// replicas in sync are left alone
+	_, err = r.Get("key1")
+	assert.Nil(t, err)
+	r.Wait()
+	assert.Equal(t, uint64(2), r.Repairs())
+
+	// a write after the read cancels the repair
+	assert.Nil(t, a.Set("key2", "val2", 0))
+	gen := r.track("key2")
+	assert.Nil(t, r.Delete("key2"))
+	r.repair(r.replicas[0], "key2", "val2", time.Time{}, gen)
+	r.Wait()
+	_, err = b.Get("key2")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, uint64(2), r.Repairs())
+	assert.Empty(t, r.repairing)
+
+	_, err = c.Replicated("zone-a", "redis")
+	assert.ErrorIs(t, err, ErrStoreNotFound)
+}
+
+func TestReplicatedCacheRepairNeedsTTL(t *testing.T) {
+	a := plainCache{NewMemoryCache(time.Minute)}
+	b := NewMemoryCache(time.Minute)
+	r := NewReplicatedCache(a, b)
+	r.Repair = true
+	r.RepairChance = 1
+	assert.Nil(t, a.Set("key1", "val1", time.Minute))
+	val, err := r.Get("key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "val1", val)
+	r.Wait()
+	_, err = b.Get("key1")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, uint64(0), r.Repairs())
+}
+
+// hangingCache blocks its Set until release is closed, and its Ping until
+// ctx is done.
+type hangingCache struct {
+	Cache
+	release chan struct{}
+}
+
+func (h hangingCache) Set(key string, value any, ttl time.Duration) error {
+	<-h.release
+	return h.Cache.Set(key, value, ttl)
+}
+
+func (h hangingCache) Ping(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestReplicatedCacheHangingReplicas(t *testing.T) {
+	release := make(chan struct{})
+	r := NewReplicatedCache(NewMemoryCache(time.Minute),
+		hangingCache{NewMemoryCache(time.Minute), release}, hangingCache{NewMemoryCache(time.Minute), release})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := r.SetContext(ctx, "key1", "val1", time.Minute)
+	assert.ErrorIs(t, err, ErrQuorum)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.ErrorIs(t, r.Ping(ctx), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// the writes left behind still finish
+	close(release)
+	r.Wait()
+	val, err := r.replicas[2].cache.Get("key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "val1", val)
+}
+
+func TestReplicatedCacheRepairBeforeWrite(t *testing.T) {
+	a := NewMemoryCache(time.Minute)
+	release := make(chan struct{})
+	b := hangingCache{NewMemoryCache(time.Minute), release}
+	r := NewReplicatedCache(a, b)
+	r.Repair = true
+	r.RepairChance = 1
+	r.WriteQuorum = QuorumOne
+	assert.Nil(t, a.Set("key1", "old", time.Minute))
+
+	// the repair of b blocks in its write
+	val, err := r.Get("key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "old", val)
+	time.Sleep(20 * time.Millisecond)
+
+	done := make(chan error)
+	go func() {
+		done <- r.Set("key1", "new", time.Minute)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Set did not wait for the repair write")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	assert.Nil(t, <-done)
+	r.Wait()
+	for _, c := range []Cache{a, b} {
+		val, err := c.Get("key1")
+		assert.Nil(t, err)
+		assert.Equal(t, "new", val)
+	}
+	assert.Equal(t, uint64(1), r.Repairs())
+}
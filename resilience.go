@@ -0,0 +1,467 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is the cause of the ErrUnavailable returned by a
+// ResilientCache while its circuit is open.
+var ErrCircuitOpen = errors.New("cache: circuit open")
+
+const (
+	DefaultBreakerWindow         = 20
+	DefaultBreakerMinRequests    = 10
+	DefaultBreakerFailureRate    = 0.5
+	DefaultBreakerOpenTimeout    = 10 * time.Second
+	DefaultBreakerHalfOpenProbes = 1
+	DefaultRetryBackoff          = 50 * time.Millisecond
+	DefaultRetryMaxBackoff       = time.Second
+)
+
+// CircuitState is the state of the circuit breaker of a ResilientCache.
+type CircuitState int
+
+const (
+	// CircuitClosed lets every call through
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects every call until OpenTimeout has passed
+	CircuitOpen
+	// CircuitHalfOpen lets HalfOpenProbes calls through to decide whether
+	// to close or to open again
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// ResilientCache wraps a Cache, usually a remote one, with a circuit
+// breaker, retries of the idempotent operations and an optional fail-open
+// mode, so an unreachable backend does not take the callers down with it.
+//
+// Failures are errors matching ErrUnavailable or context.DeadlineExceeded,
+// and calls slower than SlowCall. Misses and other errors are successes, as
+// are the errors of a context that had already ended before the call.
+type ResilientCache struct {
+	// Window is the number of recent calls the failure rate is taken on
+	Window int
+	// MinRequests is the number of calls in the window needed to open
+	MinRequests int
+	// FailureRate opens the circuit when reached, between 0 and 1
+	FailureRate float64
+	// SlowCall counts calls slower than it as failures, when positive
+	SlowCall time.Duration
+	// OpenTimeout is how long the circuit stays open before probing
+	OpenTimeout time.Duration
+	// HalfOpenProbes is the number of successful probes that close the
+	// circuit again
+	HalfOpenProbes int
+	// RetryAttempts is how many times a failed idempotent operation is
+	// retried; Increment and Decrement never are
+	RetryAttempts int
+	// RetryBackoff is the wait before the first retry, doubled before
+	// every other one up to RetryMaxBackoff
+	RetryBackoff    time.Duration
+	RetryMaxBackoff time.Duration
+	// FailOpen makes reads miss and Set succeed without calling the store
+	// while the circuit is open. The other writes still fail.
+	FailOpen bool
+	// OnStateChange is called on every transition of the circuit
+	OnStateChange func(store string, from, to CircuitState)
+
+	next ContextCache
+
+	l         sync.Mutex
+	state     CircuitState
+	outcomes  []bool
+	cursor    int
+	failures  int
+	openedAt  time.Time
+	probes    int
+	successes int
+}
+
+// ResilienceOptions configures a ResilientCache.
+type ResilienceOptions func(r *ResilientCache)
+
+// ResilienceWithBreaker opens the circuit when failureRate of the last
+// window calls failed, once at least minRequests of them were made.
+func ResilienceWithBreaker(window, minRequests int, failureRate float64) ResilienceOptions {
+	return func(r *ResilientCache) {
+		r.Window = window
+		r.MinRequests = minRequests
+		r.FailureRate = failureRate
+	}
+}
+
+// ResilienceWithSlowCall counts calls slower than threshold as failures.
+func ResilienceWithSlowCall(threshold time.Duration) ResilienceOptions {
+	return func(r *ResilientCache) {
+		r.SlowCall = threshold
+	}
+}
+
+// ResilienceWithOpenTimeout sets how long the circuit stays open.
+func ResilienceWithOpenTimeout(timeout time.Duration) ResilienceOptions {
+	return func(r *ResilientCache) {
+		r.OpenTimeout = timeout
+	}
+}
+
+// ResilienceWithHalfOpenProbes sets the probes closing the circuit.
+func ResilienceWithHalfOpenProbes(probes int) ResilienceOptions {
+	return func(r *ResilientCache) {
+		r.HalfOpenProbes = probes
+	}
+}
+
+// ResilienceWithRetry retries the failed idempotent operations attempts
+// times, waiting backoff, then twice as long every time up to maxBackoff.
+func ResilienceWithRetry(attempts int, backoff, maxBackoff time.Duration) ResilienceOptions {
+	return func(r *ResilientCache) {
+		r.RetryAttempts = attempts
+		r.RetryBackoff = backoff
+		r.RetryMaxBackoff = maxBackoff
+	}
+}
+
+// ResilienceWithFailOpen treats an open circuit as a miss.
+func ResilienceWithFailOpen(failOpen bool) ResilienceOptions {
+	return func(r *ResilientCache) {
+		r.FailOpen = failOpen
+	}
+}
+
+// ResilienceWithStateChange sets OnStateChange.
+func ResilienceWithStateChange(fn func(store string, from, to CircuitState)) ResilienceOptions {
+	return func(r *ResilientCache) {
+		r.OnStateChange = fn
+	}
+}
+
+// NewResilientCache returns a ResilientCache wrapping c.
+func NewResilientCache(c Cache, opts ...ResilienceOptions) Cache {
+	r := &ResilientCache{
+		Window:          DefaultBreakerWindow,
+		MinRequests:     DefaultBreakerMinRequests,
+		FailureRate:     DefaultBreakerFailureRate,
+		OpenTimeout:     DefaultBreakerOpenTimeout,
+		HalfOpenProbes:  DefaultBreakerHalfOpenProbes,
+		RetryBackoff:    DefaultRetryBackoff,
+		RetryMaxBackoff: DefaultRetryMaxBackoff,
+		next:            NewContextCache(c),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.Window < 1 {
+		r.Window = 1
+	}
+	if r.HalfOpenProbes < 1 {
+		r.HalfOpenProbes = 1
+	}
+	r.outcomes = make([]bool, 0, r.Window)
+	return r
+}
+
+// WithResilience returns a Middleware wrapping every store in its own
+// ResilientCache. Registering it again with GoCache.Use or SetLogger
+// starts the circuits afresh.
+func WithResilience(opts ...ResilienceOptions) Middleware {
+	return func(next Cache) Cache {
+		return NewResilientCache(next, opts...)
+	}
+}
+
+// State returns the current state of the circuit.
+func (r *ResilientCache) State() CircuitState {
+	r.l.Lock()
+	defer r.l.Unlock()
+	if r.state == CircuitOpen && time.Since(r.openedAt) >= r.OpenTimeout {
+		return CircuitHalfOpen
+	}
+	return r.state
+}
+
+// allow reports whether a call may go through, and whether it is a probe
+// of the half-open circuit.
+func (r *ResilientCache) allow() (ok, probe bool) {
+	r.l.Lock()
+	from := r.state
+	if r.state == CircuitOpen && time.Since(r.openedAt) >= r.OpenTimeout {
+		r.state, r.probes, r.successes = CircuitHalfOpen, 0, 0
+	}
+	switch r.state {
+	case CircuitClosed:
+		ok = true
+	case CircuitHalfOpen:
+		if r.probes < r.HalfOpenProbes {
+			r.probes++
+			ok, probe = true, true
+		}
+	}
+	to := r.state
+	r.l.Unlock()
+	r.changed(from, to)
+	return ok, probe
+}
+
+// record accounts for the outcome of a call let through by allow.
+func (r *ResilientCache) record(failed, probe bool) {
+	r.l.Lock()
+	from := r.state
+	switch {
+	case probe && r.state == CircuitHalfOpen:
+		r.probes--
+		if failed {
+			r.open()
+		} else if r.successes++; r.successes >= r.HalfOpenProbes {
+			r.state = CircuitClosed
+			r.outcomes, r.cursor, r.failures = r.outcomes[:0], 0, 0
+		}
+	case !probe && r.state == CircuitClosed:
+		if len(r.outcomes) < r.Window {
+			r.outcomes = append(r.outcomes, failed)
+		} else {
+			if r.outcomes[r.cursor] {
+				r.failures--
+			}
+			r.outcomes[r.cursor] = failed
+			r.cursor = (r.cursor + 1) % r.Window
+		}
+		if failed {
+			r.failures++
+		}
+		if len(r.outcomes) >= r.MinRequests && float64(r.failures) >= r.FailureRate*float64(len(r.outcomes)) {
+			r.open()
+		}
+	}
+	to := r.state
+	r.l.Unlock()
+	r.changed(from, to)
+}
+
+// open opens the circuit. r.l must be held.
+func (r *ResilientCache) open() {
+	r.state, r.openedAt = CircuitOpen, time.Now()
+	r.outcomes, r.cursor, r.failures = r.outcomes[:0], 0, 0
+}
+
+func (r *ResilientCache) changed(from, to CircuitState) {
+	if from != to && r.OnStateChange != nil {
+		r.OnStateChange(r.next.Name(), from, to)
+	}
+}
+
+// call runs fn through the breaker, retrying it when idempotent.
+func (r *ResilientCache) call(ctx context.Context, idempotent bool, fn func(ctx context.Context) error) error {
+	attempts := 1
+	if idempotent {
+		attempts += r.RetryAttempts
+	}
+	backoff := r.RetryBackoff
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+			if backoff *= 2; backoff > r.RetryMaxBackoff {
+				backoff = r.RetryMaxBackoff
+			}
+		}
+		ok, probe := r.allow()
+		if !ok {
+			if err != nil {
+				return err
+			}
+			return WrapError(ErrUnavailable, ErrCircuitOpen)
+		}
+		ctxErr, start := ctx.Err(), time.Now()
+		err = fn(ctx)
+		failed := isFailure(ctxErr, err)
+		r.record(failed || (r.SlowCall > 0 && time.Since(start) > r.SlowCall), probe)
+		if !failed {
+			return err
+		}
+	}
+	return err
+}
+
+// isFailure reports whether err, returned by a call made when ctx had
+// already ended with ctxErr, nil if it had not, is a failure of the store.
+// A deadline counts only when it passed during the call.
+func isFailure(ctxErr, err error) bool {
+	if ctxErr != nil && errors.Is(err, ctxErr) {
+		return false
+	}
+	return errors.Is(err, ErrUnavailable) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// failOpen reports whether err is an open circuit to be treated as a miss.
+func (r *ResilientCache) failOpen(err error) bool {
+	return r.FailOpen && errors.Is(err, ErrCircuitOpen)
+}
+
+func (r *ResilientCache) Name() string {
+	return r.next.Name()
+}
+
+func (r *ResilientCache) Set(key string, value any, ttl time.Duration) error {
+	return r.SetContext(context.Background(), key, value, ttl)
+}
+
+func (r *ResilientCache) Has(key string) (bool, error) {
+	return r.HasContext(context.Background(), key)
+}
+
+func (r *ResilientCache) GetMulti(keys []string) ([]any, error) {
+	return r.GetMultiContext(context.Background(), keys)
+}
+
+func (r *ResilientCache) Get(key string) (any, error) {
+	return r.GetContext(context.Background(), key)
+}
+
+func (r *ResilientCache) Delete(key string) error {
+	return r.DeleteContext(context.Background(), key)
+}
+
+func (r *ResilientCache) Increment(key string, step int) error {
+	return r.IncrementContext(context.Background(), key, step)
+}
+
+func (r *ResilientCache) Decrement(key string, step int) error {
+	return r.DecrementContext(context.Background(), key, step)
+}
+
+func (r *ResilientCache) Clear() error {
+	return r.ClearContext(context.Background())
+}
+
+func (r *ResilientCache) SetContext(ctx context.Context, key string, value any, ttl time.Duration) error {
+	err := r.call(ctx, true, func(ctx context.Context) error {
+		return r.next.SetContext(ctx, key, value, ttl)
+	})
+	if r.failOpen(err) {
+		return nil
+	}
+	return err
+}
+
+func (r *ResilientCache) HasContext(ctx context.Context, key string) (bool, error) {
+	var ok bool
+	err := r.call(ctx, true, func(ctx context.Context) (err error) {
+		ok, err = r.next.HasContext(ctx, key)
+		return err
+	})
+	if r.failOpen(err) {
+		return false, nil
+	}
+	return ok, err
+}
+
+func (r *ResilientCache) GetMultiContext(ctx context.Context, keys []string) ([]any, error) {
+	var values []any
+	err := r.call(ctx, true, func(ctx context.Context) (err error) {
+		values, err = r.next.GetMultiContext(ctx, keys)
+		return err
+	})
+	if r.failOpen(err) {
+		var keysErr MultiError
+		for _, key := range keys {
+			keysErr = append(keysErr, KeyError{Key: key, Err: ErrNotFound})
+		}
+		return make([]any, len(keys)), keysErr.ErrorOrNil()
+	}
+	return values, err
+}
+
+func (r *ResilientCache) GetContext(ctx context.Context, key string) (any, error) {
+	var val any
+	err := r.call(ctx, true, func(ctx context.Context) (err error) {
+		val, err = r.next.GetContext(ctx, key)
+		return err
+	})
+	if r.failOpen(err) {
+		return nil, ErrNotFound
+	}
+	return val, err
+}
+
+// GetWithTTL gets key with its remaining ttl through the breaker, or fails
+// with ErrTTLUnknown when the wrapped store is not a TTLGetter.
+func (r *ResilientCache) GetWithTTL(ctx context.Context, key string) (any, time.Duration, error) {
+	if _, ok := r.next.(TTLGetter); !ok {
+		return nil, 0, ErrTTLUnknown
+	}
+	var (
+		val any
+		ttl time.Duration
+	)
+	err := r.call(ctx, true, func(ctx context.Context) (err error) {
+		val, ttl, err = getWithTTL(ctx, r.next, key)
+		return err
+	})
+	if r.failOpen(err) {
+		return nil, 0, ErrNotFound
+	}
+	return val, ttl, err
+}
+
+func (r *ResilientCache) DeleteContext(ctx context.Context, key string) error {
+	return r.call(ctx, true, func(ctx context.Context) error {
+		return r.next.DeleteContext(ctx, key)
+	})
+}
+
+func (r *ResilientCache) IncrementContext(ctx context.Context, key string, step int) error {
+	return r.call(ctx, false, func(ctx context.Context) error {
+		return r.next.IncrementContext(ctx, key, step)
+	})
+}
+
+func (r *ResilientCache) DecrementContext(ctx context.Context, key string, step int) error {
+	return r.call(ctx, false, func(ctx context.Context) error {
+		return r.next.DecrementContext(ctx, key, step)
+	})
+}
+
+func (r *ResilientCache) ClearContext(ctx context.Context) error {
+	return r.call(ctx, true, func(ctx context.Context) error {
+		return r.next.ClearContext(ctx)
+	})
+}
+
+// Ping pings the wrapped store, bypassing the circuit, so health checks
+// see the backend itself.
+func (r *ResilientCache) Ping(ctx context.Context) error {
+	if pinger, ok := r.next.(Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// Close closes the wrapped store, if it is an io.Closer.
+func (r *ResilientCache) Close() error {
+	if closer, ok := r.next.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyCache fails every call with ErrUnavailable while down, and counts
+// the calls reaching it.
+type flakyCache struct {
+	Cache
+	down  atomic.Bool
+	calls atomic.Int32
+	// failures fails that many calls before succeeding, while not down
+	failures atomic.Int32
+}
+
+func (f *flakyCache) err() error {
+	f.calls.Add(1)
+	if f.down.Load() || f.failures.Add(-1) >= 0 {
+		return WrapError(ErrUnavailable, errBroken)
+	}
+	return nil
+}
+
+func (f *flakyCache) Get(key string) (any, error) {
+	if err := f.err(); err != nil {
+		return nil, err
+	}
+	return f.Cache.Get(key)
+}
+
+func (f *flakyCache) GetMulti(keys []string) ([]any, error) {
+	if err := f.err(); err != nil {
+		return nil, err
+	}
+	return f.Cache.GetMulti(keys)
+}
+
+func (f *flakyCache) Set(key string, value any, ttl time.Duration) error {
+	if err := f.err(); err != nil {
+		return err
+	}
+	return f.Cache.Set(key, value, ttl)
+}
+
+func (f *flakyCache) Increment(key string, step int) error {
+	if err := f.err(); err != nil {
+		return err
+	}
+	return f.Cache.Increment(key, step)
+}
+
+func (f *flakyCache) Delete(key string) error {
+	if err := f.err(); err != nil {
+		return err
+	}
+	return f.Cache.Delete(key)
+}
+
+func (f *flakyCache) Ping(ctx context.Context) error {
+	return f.err()
+}
+
+func TestResilientCacheBreaker(t *testing.T) {
+	flaky := &flakyCache{Cache: NewMemoryCache(time.Minute)}
+	var transitions []string
+	c := NewResilientCache(flaky,
+		ResilienceWithBreaker(4, 4, 0.5),
+		ResilienceWithOpenTimeout(50*time.Millisecond),
+		ResilienceWithStateChange(func(store string, from, to CircuitState) {
+			transitions = append(transitions, store+": "+from.String()+" -> "+to.String())
+		}))
+	r := c.(*ResilientCache)
+	assert.Nil(t, c.Set("key1", "author", time.Minute))
+
+	flaky.down.Store(true)
+	for i := 0; i < 3; i++ {
+		_, err := c.Get("key1")
+		assert.ErrorIs(t, err, errBroken)
+	}
+	assert.Equal(t, CircuitOpen, r.State())
+	_, err := c.Get("key1")
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.Equal(t, int32(4), flaky.calls.Load())
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, CircuitHalfOpen, r.State())
+	_, err = c.Get("key1")
+	assert.ErrorIs(t, err, errBroken)
+	assert.Equal(t, CircuitOpen, r.State())
+
+	flaky.down.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	val, err := c.Get("key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "author", val)
+	assert.Equal(t, CircuitClosed, r.State())
+	assert.Equal(t, []string{
+		"memory: closed -> open",
+		"memory: open -> half-open",
+		"memory: half-open -> open",
+		"memory: open -> half-open",
+		"memory: half-open -> closed",
+	}, transitions)
+}
+
+func TestResilientCacheSlowCalls(t *testing.T) {
+	c := NewResilientCache(slowCache{NewMemoryCache(time.Minute)},
+		ResilienceWithBreaker(2, 2, 1), ResilienceWithSlowCall(time.Millisecond))
+	_, _ = c.Get("key1")
+	_, _ = c.Get("key1")
+	assert.Equal(t, CircuitOpen, c.(*ResilientCache).State())
+}
+
+func TestResilientCacheExpiredContext(t *testing.T) {
+	c := NewResilientCache(NewMemoryCache(time.Minute), ResilienceWithBreaker(2, 2, 1))
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		_, err := c.(ContextCache).GetContext(ctx, "key1")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	}
+	assert.Equal(t, CircuitClosed, c.(*ResilientCache).State())
+}
+
+// slowCache takes 5ms per Get.
+type slowCache struct {
+	Cache
+}
+
+func (s slowCache) Get(key string) (any, error) {
+	time.Sleep(5 * time.Millisecond)
+	return s.Cache.Get(key)
+}
+
+func TestResilientCacheRetry(t *testing.T) {
+	flaky := &flakyCache{Cache: NewMemoryCache(time.Minute)}
+	c := NewResilientCache(flaky, ResilienceWithRetry(2, time.Millisecond, 2*time.Millisecond))
+	flaky.failures.Store(2)
+	assert.Nil(t, c.Set("key1", 1, time.Minute))
+	assert.Equal(t, int32(3), flaky.calls.Load())
+
+	flaky.calls.Store(0)
+	flaky.failures.Store(1)
+	assert.ErrorIs(t, c.Increment("key1", 1), ErrUnavailable)
+	assert.Equal(t, int32(1), flaky.calls.Load())
+
+	flaky.calls.Store(0)
+	flaky.failures.Store(5)
+	_, err := c.Get("key1")
+	assert.ErrorIs(t, err, errBroken)
+	assert.Equal(t, int32(3), flaky.calls.Load())
+}
+
+func TestResilientCacheFailOpen(t *testing.T) {
+	flaky := &flakyCache{Cache: NewMemoryCache(time.Minute)}
+	c := NewCache(flaky).Use(WithResilience(
+		ResilienceWithBreaker(1, 1, 1),
+		ResilienceWithFailOpen(true)))
+	flaky.down.Store(true)
+	_, err := c.Get("key1")
+	assert.ErrorIs(t, err, ErrUnavailable)
+
+	calls := flaky.calls.Load()
+	_, err = c.Get("key1")
+	assert.ErrorIs(t, err, ErrNotFound)
+	val, err := c.Remember("key1", "loaded", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, "loaded", val)
+	_, err = c.GetMulti([]string{"key1", "key2"})
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, c.Increment("key1", 1), ErrCircuitOpen)
+	assert.Equal(t, calls, flaky.calls.Load())
+}
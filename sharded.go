@@ -0,0 +1,390 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultShardReplicas is the number of virtual nodes a shard of weight 1
+// has on the ring.
+const DefaultShardReplicas = 160
+
+// ErrNoShards is the cause of the ErrUnavailable returned by a
+// ShardedCache without shards.
+var ErrNoShards = errors.New("cache: no shards")
+
+// ShardedCache spreads keys over several stores, its shards, with a
+// consistent hash ring: each shard owns Replicas virtual nodes per unit of
+// weight, and a key belongs to the shard of the first node at or after its
+// hash. Adding or removing a shard only moves the keys it gains or loses.
+//
+// GetMulti and Clear run on the shards in parallel.
+type ShardedCache struct {
+	// Replicas is the number of virtual nodes per unit of weight
+	Replicas int
+	// Hash places keys and virtual nodes on the ring. Replicas and Hash
+	// take effect at the next AddShard or RemoveShard.
+	Hash func(key string) uint64
+
+	shards map[string]*shard
+	names  []string
+	ring   []ringNode
+	// hash is the Hash the ring was built with
+	hash func(key string) uint64
+	l    sync.RWMutex
+}
+
+type shard struct {
+	name   string
+	base   Cache
+	cache  ContextCache
+	weight int
+}
+
+type ringNode struct {
+	hash  uint64
+	shard *shard
+}
+
+// NewShardedCache returns a ShardedCache over stores, all of weight 1. A
+// shard is named after its store, suffixed with its position in stores
+// when the name is taken, e.g. "redis" and "redis-1".
+//
+// Keys are placed by shard name, so those suffixes tie the placement of
+// every key to the order of stores: reordering stores of the same kind, or
+// removing one but the last, moves their keys between shards, where they
+// miss. Stores sharing a Name should be added with AddShard, or registered
+// and passed to GoCache.Sharded, under names that stay stable.
+func NewShardedCache(stores ...Cache) *ShardedCache {
+	s := &ShardedCache{Replicas: DefaultShardReplicas, Hash: HashKey64, shards: make(map[string]*shard)}
+	for i, name := range uniqueNames(stores) {
+		_ = s.AddShard(name, stores[i], 1)
+	}
+	return s
+}
+
+// Sharded returns a ShardedCache over the stores registered under names,
+// which name the shards. It goes through their Store facades, so it
+// follows Replace and the middlewares of Use.
+func (f *GoCache) Sharded(names ...string) (*ShardedCache, error) {
+	s := NewShardedCache()
+	for _, name := range names {
+		store, err := f.Store(name)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.AddShard(name, store, 1); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// HashKey64 is the default Hash of ShardedCache: FNV-1a, with the bits
+// mixed so that similar keys land far apart.
+func HashKey64(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// AddShard adds c as the shard name, with weight times the virtual nodes
+// of a shard of weight 1.
+func (s *ShardedCache) AddShard(name string, c Cache, weight int) error {
+	if weight < 1 {
+		return fmt.Errorf("cache: invalid weight %d for shard %s", weight, name)
+	}
+	s.l.Lock()
+	defer s.l.Unlock()
+	if _, ok := s.shards[name]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateStore, name)
+	}
+	s.shards[name] = &shard{name: name, base: c, cache: NewContextCache(c), weight: weight}
+	s.names = append(s.names, name)
+	s.rebuild()
+	return nil
+}
+
+// RemoveShard removes the shard name and returns its store. Its keys move
+// to the other shards, where they miss.
+func (s *ShardedCache) RemoveShard(name string) (Cache, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	sh, ok := s.shards[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrStoreNotFound, name)
+	}
+	delete(s.shards, name)
+	for i, n := range s.names {
+		if n == name {
+			s.names = append(s.names[:i:i], s.names[i+1:]...)
+			break
+		}
+	}
+	s.rebuild()
+	return sh.base, nil
+}
+
+// Shards returns the names of the shards in the order they were added.
+func (s *ShardedCache) Shards() []string {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	return append([]string(nil), s.names...)
+}
+
+// ShardFor returns the name of the shard owning key, "" without shards.
+func (s *ShardedCache) ShardFor(key string) string {
+	if sh := s.shardFor(key); sh != nil {
+		return sh.name
+	}
+	return ""
+}
+
+// rebuild recomputes the ring. s.l must be held.
+func (s *ShardedCache) rebuild() {
+	hash := s.Hash
+	if hash == nil {
+		hash = HashKey64
+	}
+	replicas := s.Replicas
+	if replicas < 1 {
+		replicas = DefaultShardReplicas
+	}
+	ring := make([]ringNode, 0, len(s.shards)*replicas)
+	for _, sh := range s.shards {
+		for i := 0; i < replicas*sh.weight; i++ {
+			ring = append(ring, ringNode{hash: hash(sh.name + "#" + strconv.Itoa(i)), shard: sh})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		if ring[i].hash != ring[j].hash {
+			return ring[i].hash < ring[j].hash
+		}
+		return ring[i].shard.name < ring[j].shard.name
+	})
+	s.ring, s.hash = ring, hash
+}
+
+func (s *ShardedCache) shardFor(key string) *shard {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	if len(s.ring) == 0 {
+		return nil
+	}
+	h := s.hash(key)
+	i := sort.Search(len(s.ring), func(i int) bool {
+		return s.ring[i].hash >= h
+	})
+	if i == len(s.ring) {
+		i = 0
+	}
+	return s.ring[i].shard
+}
+
+// snapshot returns the shards in the order they were added.
+func (s *ShardedCache) snapshot() []*shard {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	shards := make([]*shard, len(s.names))
+	for i, name := range s.names {
+		shards[i] = s.shards[name]
+	}
+	return shards
+}
+
+// on returns the shard owning key, or the error of a ring without shards.
+func (s *ShardedCache) on(key string) (ContextCache, error) {
+	if sh := s.shardFor(key); sh != nil {
+		return sh.cache, nil
+	}
+	return nil, WrapError(ErrUnavailable, ErrNoShards)
+}
+
+func (s *ShardedCache) Name() string {
+	return ShardedCacheName
+}
+
+func (s *ShardedCache) Set(key string, value any, ttl time.Duration) error {
+	return s.SetContext(context.Background(), key, value, ttl)
+}
+
+func (s *ShardedCache) Has(key string) (bool, error) {
+	return s.HasContext(context.Background(), key)
+}
+
+func (s *ShardedCache) GetMulti(keys []string) ([]any, error) {
+	return s.GetMultiContext(context.Background(), keys)
+}
+
+func (s *ShardedCache) Get(key string) (any, error) {
+	return s.GetContext(context.Background(), key)
+}
+
+func (s *ShardedCache) Delete(key string) error {
+	return s.DeleteContext(context.Background(), key)
+}
+
+func (s *ShardedCache) Increment(key string, step int) error {
+	return s.IncrementContext(context.Background(), key, step)
+}
+
+func (s *ShardedCache) Decrement(key string, step int) error {
+	return s.DecrementContext(context.Background(), key, step)
+}
+
+func (s *ShardedCache) Clear() error {
+	return s.ClearContext(context.Background())
+}
+
+func (s *ShardedCache) SetContext(ctx context.Context, key string, value any, ttl time.Duration) error {
+	c, err := s.on(key)
+	if err != nil {
+		return err
+	}
+	return c.SetContext(ctx, key, value, ttl)
+}
+
+func (s *ShardedCache) HasContext(ctx context.Context, key string) (bool, error) {
+	c, err := s.on(key)
+	if err != nil {
+		return false, err
+	}
+	return c.HasContext(ctx, key)
+}
+
+// GetMultiContext groups keys by shard and gets every group in parallel.
+// The failures of a shard are reported per key.
+func (s *ShardedCache) GetMultiContext(ctx context.Context, keys []string) ([]any, error) {
+	values := make([]any, len(keys))
+	groups := make(map[*shard][]int)
+	for i, key := range keys {
+		sh := s.shardFor(key)
+		if sh == nil {
+			return values, WrapError(ErrUnavailable, ErrNoShards)
+		}
+		groups[sh] = append(groups[sh], i)
+	}
+	var (
+		wg      sync.WaitGroup
+		l       sync.Mutex
+		keysErr MultiError
+	)
+	for sh, indexes := range groups {
+		wg.Add(1)
+		go func(sh *shard, indexes []int) {
+			defer wg.Done()
+			shardKeys := make([]string, len(indexes))
+			for j, i := range indexes {
+				shardKeys[j] = keys[i]
+			}
+			shardValues, err := sh.cache.GetMultiContext(ctx, shardKeys)
+			l.Lock()
+			defer l.Unlock()
+			for j, i := range indexes {
+				if j < len(shardValues) {
+					values[i] = shardValues[j]
+				}
+			}
+			var shardErr MultiError
+			switch {
+			case err == nil:
+			case errors.As(err, &shardErr):
+				keysErr = append(keysErr, shardErr...)
+			default:
+				for _, key := range shardKeys {
+					keysErr = append(keysErr, KeyError{Key: key, Err: err})
+				}
+			}
+		}(sh, indexes)
+	}
+	wg.Wait()
+	return values, keysErr.ErrorOrNil()
+}
+
+func (s *ShardedCache) GetContext(ctx context.Context, key string) (any, error) {
+	c, err := s.on(key)
+	if err != nil {
+		return nil, err
+	}
+	return c.GetContext(ctx, key)
+}
+
+func (s *ShardedCache) DeleteContext(ctx context.Context, key string) error {
+	c, err := s.on(key)
+	if err != nil {
+		return err
+	}
+	return c.DeleteContext(ctx, key)
+}
+
+func (s *ShardedCache) IncrementContext(ctx context.Context, key string, step int) error {
+	c, err := s.on(key)
+	if err != nil {
+		return err
+	}
+	return c.IncrementContext(ctx, key, step)
+}
+
+func (s *ShardedCache) DecrementContext(ctx context.Context, key string, step int) error {
+	c, err := s.on(key)
+	if err != nil {
+		return err
+	}
+	return c.DecrementContext(ctx, key, step)
+}
+
+// ClearContext clears every shard in parallel and reports the failed ones
+// as StoreErrors.
+func (s *ShardedCache) ClearContext(ctx context.Context) error {
+	return s.each(func(sh *shard) error {
+		return sh.cache.ClearContext(ctx)
+	})
+}
+
+// Ping pings every shard in parallel and reports the failed ones as
+// StoreErrors.
+func (s *ShardedCache) Ping(ctx context.Context) error {
+	return s.each(func(sh *shard) error {
+		if pinger, ok := sh.cache.(Pinger); ok {
+			return pinger.Ping(ctx)
+		}
+		return nil
+	})
+}
+
+// each runs fn on every shard in parallel.
+func (s *ShardedCache) each(fn func(sh *shard) error) error {
+	shards := s.snapshot()
+	errs := make([]error, len(shards))
+	var wg sync.WaitGroup
+	for i, sh := range shards {
+		wg.Add(1)
+		go func(i int, sh *shard) {
+			defer wg.Done()
+			errs[i] = fn(sh)
+		}(i, sh)
+	}
+	wg.Wait()
+	var storesErr StoreErrors
+	for i, err := range errs {
+		if err != nil {
+			storesErr = append(storesErr, StoreError{Store: shards[i].name, Err: err})
+		}
+	}
+	if len(storesErr) == 0 {
+		return nil
+	}
+	return storesErr
+}
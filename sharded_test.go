@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("user:%d", i)
+	}
+	return keys
+}
+
+func owners(s *ShardedCache, keys []string) map[string]string {
+	owners := make(map[string]string, len(keys))
+	for _, key := range keys {
+		owners[key] = s.ShardFor(key)
+	}
+	return owners
+}
+
+func TestShardedCache(t *testing.T) {
+	a, b, c := NewMemoryCache(time.Minute), NewMemoryCache(time.Minute), NewMemoryCache(time.Minute)
+	s := NewShardedCache(a, b, c)
+	assert.Equal(t, []string{"memory", "memory-1", "memory-2"}, s.Shards())
+	assert.Equal(t, ShardedCacheName, s.Name())
+
+	keys := testKeys(300)
+	for _, key := range keys {
+		assert.Nil(t, s.Set(key, key, time.Minute))
+	}
+	for i, store := range []Cache{a, b, c} {
+		n := len(store.(*MemoryCache).Keys())
+		assert.Greater(t, n, 50, "shard %d", i)
+	}
+
+	values, err := s.GetMulti(append([]string{"missing"}, keys...))
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Len(t, err.(MultiError), 1)
+	assert.Nil(t, values[0])
+	for i, key := range keys {
+		assert.Equal(t, key, values[i+1])
+	}
+
+	assert.Nil(t, s.Increment("counter", 2))
+	val, err := s.Get("counter")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, val)
+
+	assert.Nil(t, s.Clear())
+	for _, store := range []Cache{a, b, c} {
+		assert.Empty(t, store.(*MemoryCache).Keys())
+	}
+	assert.Nil(t, s.Ping(context.Background()))
+}
+
+func TestShardedCacheRemapping(t *testing.T) {
+	s := NewShardedCache()
+	for _, name := range []string{"a", "b", "c", "d"} {
+		assert.Nil(t, s.AddShard(name, NewMemoryCache(time.Minute), 1))
+	}
+	keys := testKeys(10000)
+	before := owners(s, keys)
+
+	assert.Nil(t, s.AddShard("e", NewMemoryCache(time.Minute), 1))
+	after := owners(s, keys)
+	var moved int
+	for _, key := range keys {
+		if before[key] != after[key] {
+			moved++
+			assert.Equal(t, "e", after[key])
+		}
+	}
+	// about a fifth of the keys move, all to the new shard
+	assert.InDelta(t, len(keys)/5, moved, float64(len(keys))/20)
+
+	_, err := s.RemoveShard("e")
+	assert.Nil(t, err)
+	assert.Equal(t, before, owners(s, keys))
+	_, err = s.RemoveShard("e")
+	assert.ErrorIs(t, err, ErrStoreNotFound)
+	assert.ErrorIs(t, s.AddShard("a", NewNullCache(), 1), ErrDuplicateStore)
+}
+
+func TestShardedCacheWeights(t *testing.T) {
+	s := NewShardedCache()
+	assert.Nil(t, s.AddShard("small", NewNullCache(), 1))
+	assert.Nil(t, s.AddShard("large", NewNullCache(), 3))
+	assert.NotNil(t, s.AddShard("empty", NewNullCache(), 0))
+	counts := make(map[string]int)
+	for _, owner := range owners(s, testKeys(10000)) {
+		counts[owner]++
+	}
+	assert.InDelta(t, 7500, counts["large"], 500)
+}
+
+func TestGoCacheSharded(t *testing.T) {
+	c := New().Extend(NewMemoryCache(time.Minute), "a").Extend(&flakyCache{Cache: NewMemoryCache(time.Minute)}, "b")
+	s, err := c.Sharded("a", "b")
+	assert.Nil(t, err)
+	flaky, _ := c.Cache("b")
+	flaky.(*flakyCache).down.Store(true)
+
+	keys := testKeys(100)
+	_, err = s.GetMulti(keys)
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.Len(t, err.(MultiError), len(keys))
+	for _, keyErr := range err.(MultiError) {
+		if s.ShardFor(keyErr.Key) == "b" {
+			assert.ErrorIs(t, keyErr, ErrUnavailable)
+		} else {
+			assert.ErrorIs(t, keyErr, ErrNotFound)
+		}
+	}
+	assert.Nil(t, s.Clear())
+
+	_, err = New().Sharded("a")
+	assert.ErrorIs(t, err, ErrStoreNotFound)
+	_, err = NewShardedCache().Get("key1")
+	assert.ErrorIs(t, err, ErrNoShards)
+}
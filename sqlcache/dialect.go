@@ -0,0 +1,167 @@
+package sqlcache
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Dialect writes the SQL a database understands. Table names reaching a
+// Dialect have been validated, so they only need quoting.
+type Dialect interface {
+	Name() string
+	// Placeholder returns the bind parameter for the nth argument, from 1.
+	Placeholder(n int) string
+	// Quote quotes an identifier.
+	Quote(ident string) string
+	// Schema returns the statements creating table and its expiry index,
+	// each a no-op when the object exists. unlogged asks for a table that
+	// skips the write-ahead log, where the database has such tables.
+	Schema(table string, unlogged bool) []string
+	// Upsert inserts or replaces the key, value and expires_at arguments.
+	Upsert(table string) string
+	// InsertIgnore inserts key, value and expires_at unless key exists.
+	InsertIgnore(table string) string
+	// ForUpdate is appended to a SELECT to lock the rows it reads until the
+	// transaction ends.
+	ForUpdate() string
+}
+
+// KeyLimiter is implemented by the dialects whose key column holds at most
+// MaxKeyLength bytes. New shortens longer keys with cache.SafeKey for them.
+type KeyLimiter interface {
+	MaxKeyLength() int
+}
+
+var (
+	// Postgres is the dialect of PostgreSQL 9.5 and later.
+	Postgres Dialect = postgres{}
+	// MySQL is the dialect of MySQL 5.7 and later, and MariaDB. Its key
+	// column is a VARBINARY(512), part of the primary key, so it is a
+	// KeyLimiter.
+	MySQL Dialect = mysql{}
+	// SQLite is the dialect of SQLite 3.24 and later.
+	SQLite Dialect = sqlite{}
+)
+
+type postgres struct{}
+
+func (postgres) Name() string {
+	return "postgres"
+}
+
+func (postgres) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func (postgres) Quote(ident string) string {
+	return `"` + ident + `"`
+}
+
+func (d postgres) Schema(table string, unlogged bool) []string {
+	kind := "TABLE"
+	if unlogged {
+		kind = "UNLOGGED TABLE"
+	}
+	return []string{
+		fmt.Sprintf(`CREATE %s IF NOT EXISTS %s (key TEXT NOT NULL PRIMARY KEY, value BYTEA NOT NULL, expires_at BIGINT)`,
+			kind, d.Quote(table)),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s (expires_at)`, d.Quote(table+"_expires_at"), d.Quote(table)),
+	}
+}
+
+func (d postgres) Upsert(table string) string {
+	return insert(d, table) + ` ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at`
+}
+
+func (d postgres) InsertIgnore(table string) string {
+	return insert(d, table) + ` ON CONFLICT (key) DO NOTHING`
+}
+
+func (postgres) ForUpdate() string {
+	return " FOR UPDATE"
+}
+
+type mysql struct{}
+
+func (mysql) Name() string {
+	return "mysql"
+}
+
+func (mysql) Placeholder(int) string {
+	return "?"
+}
+
+func (mysql) Quote(ident string) string {
+	return "`" + ident + "`"
+}
+
+// mysqlMaxKeyLength is the size of the key column, within the 3072 bytes
+// InnoDB allows in a primary key.
+const mysqlMaxKeyLength = 512
+
+func (mysql) MaxKeyLength() int {
+	return mysqlMaxKeyLength
+}
+
+func (d mysql) Schema(table string, _ bool) []string {
+	return []string{
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s VARBINARY(%d) NOT NULL PRIMARY KEY, value LONGBLOB NOT NULL, expires_at BIGINT NULL, INDEX %s (expires_at))",
+			d.Quote(table), d.Quote("key"), mysqlMaxKeyLength, d.Quote(table+"_expires_at")),
+	}
+}
+
+func (d mysql) Upsert(table string) string {
+	return insert(d, table) + ` ON DUPLICATE KEY UPDATE value = VALUES(value), expires_at = VALUES(expires_at)`
+}
+
+func (d mysql) InsertIgnore(table string) string {
+	return strings.Replace(insert(d, table), "INSERT", "INSERT IGNORE", 1)
+}
+
+func (mysql) ForUpdate() string {
+	return " FOR UPDATE"
+}
+
+type sqlite struct{}
+
+func (sqlite) Name() string {
+	return "sqlite"
+}
+
+func (sqlite) Placeholder(int) string {
+	return "?"
+}
+
+func (sqlite) Quote(ident string) string {
+	return `"` + ident + `"`
+}
+
+func (d sqlite) Schema(table string, _ bool) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (key TEXT NOT NULL PRIMARY KEY, value BLOB NOT NULL, expires_at INTEGER) WITHOUT ROWID`,
+			d.Quote(table)),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s (expires_at) WHERE expires_at IS NOT NULL`,
+			d.Quote(table+"_expires_at"), d.Quote(table)),
+	}
+}
+
+func (d sqlite) Upsert(table string) string {
+	return insert(d, table) + ` ON CONFLICT (key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at`
+}
+
+func (d sqlite) InsertIgnore(table string) string {
+	return insert(d, table) + ` ON CONFLICT (key) DO NOTHING`
+}
+
+// ForUpdate is empty: SQLite locks the whole database instead of rows, so
+// open it with _txlock=immediate for transactions to take the write lock
+// up front.
+func (sqlite) ForUpdate() string {
+	return ""
+}
+
+func insert(d Dialect, table string) string {
+	return fmt.Sprintf(`INSERT INTO %s (%s, value, expires_at) VALUES (%s, %s, %s)`,
+		d.Quote(table), d.Quote("key"), d.Placeholder(1), d.Placeholder(2), d.Placeholder(3))
+}
@@ -0,0 +1,605 @@
+// Package sqlcache provides a cache adapter over any database/sql database,
+// for deployments that have a relational database but no cache server.
+//
+// The SQL is written by a Dialect; PostgreSQL, MySQL and SQLite are
+// provided:
+//
+//	db, _ := sql.Open("pgx", dsn)
+//	c := sqlcache.New(sqlcache.CacheWithDB(db), sqlcache.CacheWithDialect(sqlcache.Postgres))
+//
+// Entries live in one table of key, value and expires_at, the latter
+// indexed so expired entries are swept without a table scan. Keys longer
+// than the key column of the dialect are shortened with cache.SafeKey.
+package sqlcache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg6/go-cache"
+)
+
+const (
+	// DefaultTable is the table entries are stored in.
+	DefaultTable = "gocache"
+	// DefaultSweepInterval is how often expired entries are removed.
+	DefaultSweepInterval = 5 * time.Minute
+	// maxBatchSize bounds the placeholders of one GetMulti query.
+	maxBatchSize = 500
+	// maxRetries bounds the retries of a counter whose row another client
+	// created first, or whose transaction failed with a Retryable error.
+	maxRetries = 100
+)
+
+var (
+	ErrInvalidTable = errors.New("cache: invalid sql table name")
+	ErrNoDatabase   = errors.New("cache: no sql database configured")
+
+	tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+type Cache struct {
+	// DB is the database; the Cache only closes it when Open opened it
+	DB *sql.DB
+	// Open opens the database on first use when DB is nil
+	Open    func() (*sql.DB, error)
+	Dialect Dialect
+	// Table entries are stored in
+	Table string
+	// Unlogged creates the table without write-ahead logging on PostgreSQL:
+	// faster writes, but the table is emptied after a crash
+	Unlogged bool
+	// AutoMigrate creates the table on first use
+	AutoMigrate bool
+	// SweepInterval is how often expired entries are removed, 0 disables it
+	SweepInterval time.Duration
+	// KeyTransformer maps user keys onto keys the key column holds
+	KeyTransformer cache.KeyTransformer
+	// Retryable reports the errors on which a counter transaction is
+	// retried, such as a lock held by another connection
+	Retryable func(err error) bool
+	// StoreName is returned by Name, SQLCacheName when empty
+	StoreName string
+	CacheItem cache.ICacheItem
+	// Logger logs the entries the sweeps evict, and their failures; nil
+	// logs nothing
+	Logger *cache.Logger
+
+	// l guards CacheItem, which SetCacheItem mutates while encoding
+	l sync.Mutex
+	// initL guards DB and the queries while they are set up and closed
+	initL   sync.Mutex
+	queries *queries
+	owned   bool
+	closed  bool
+	janitor *cache.Janitor
+}
+type CacheOptions func(c *Cache)
+
+// queries are run on db, the DB they were built for, so operations never
+// read DB while it is being configured.
+type queries struct {
+	db                                                   *sql.DB
+	get, getForUpdate, set, insert, delete, sweep, clear string
+}
+
+// CacheWithDB configures the database. The Cache does not close it.
+func CacheWithDB(db *sql.DB) CacheOptions {
+	return func(c *Cache) {
+		c.DB = db
+	}
+}
+
+// CacheWithOpen configures how the database is opened on first use when
+// no DB is set. The Cache closes the database it opened.
+func CacheWithOpen(open func() (*sql.DB, error)) CacheOptions {
+	return func(c *Cache) {
+		c.Open = open
+	}
+}
+
+// CacheWithDialect configures the SQL dialect of the database.
+func CacheWithDialect(dialect Dialect) CacheOptions {
+	return func(c *Cache) {
+		c.Dialect = dialect
+	}
+}
+
+// CacheWithTable configures the table entries are stored in.
+func CacheWithTable(table string) CacheOptions {
+	return func(c *Cache) {
+		c.Table = table
+	}
+}
+
+// CacheWithUnlogged configures an UNLOGGED table on PostgreSQL.
+// It only applies when the table is created.
+func CacheWithUnlogged(unlogged bool) CacheOptions {
+	return func(c *Cache) {
+		c.Unlogged = unlogged
+	}
+}
+
+// CacheWithAutoMigrate configures whether the table is created on first use.
+func CacheWithAutoMigrate(autoMigrate bool) CacheOptions {
+	return func(c *Cache) {
+		c.AutoMigrate = autoMigrate
+	}
+}
+
+// CacheWithSweepInterval configures how often expired entries are removed.
+func CacheWithSweepInterval(interval time.Duration) CacheOptions {
+	return func(c *Cache) {
+		c.SweepInterval = interval
+	}
+}
+
+// CacheWithKeyTransformer configures how keys are made to fit the key
+// column, by default cache.SafeKey for dialects with a bounded column.
+func CacheWithKeyTransformer(transformer cache.KeyTransformer) CacheOptions {
+	return func(c *Cache) {
+		c.KeyTransformer = transformer
+	}
+}
+
+// CacheWithRetryable configures the errors on which a counter transaction
+// is retried.
+func CacheWithRetryable(retryable func(err error) bool) CacheOptions {
+	return func(c *Cache) {
+		c.Retryable = retryable
+	}
+}
+
+func CacheWithCacheItem(cacheItem cache.ICacheItem) CacheOptions {
+	return func(c *Cache) {
+		c.CacheItem = cacheItem
+	}
+}
+
+// CacheWithLogger sets the Logger of the store.
+func CacheWithLogger(logger *cache.Logger) CacheOptions {
+	return func(c *Cache) {
+		c.Logger = logger
+	}
+}
+
+// New creates a new sql cache, PostgreSQL flavoured unless configured
+// otherwise. Call Close to stop its sweeper.
+func New(opts ...CacheOptions) cache.Cache {
+	c := &Cache{
+		Dialect:       Postgres,
+		Table:         DefaultTable,
+		AutoMigrate:   true,
+		SweepInterval: DefaultSweepInterval,
+		CacheItem:     &cache.CacheItem{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.KeyTransformer == nil {
+		if limited, ok := c.Dialect.(KeyLimiter); ok {
+			c.KeyTransformer = cache.SafeKey(limited.MaxKeyLength())
+		}
+	}
+	c.janitor = cache.StartJanitor(c.SweepInterval, func() {
+		c.Logger.Sweep(c.Name(), c.Sweep)
+	})
+	return c
+}
+
+func (c *Cache) Name() string {
+	if c.StoreName != "" {
+		return c.StoreName
+	}
+	return cache.SQLCacheName
+}
+
+func (c *Cache) Set(key string, value any, ttl time.Duration) error {
+	return c.SetContext(context.Background(), key, value, ttl)
+}
+
+func (c *Cache) SetContext(ctx context.Context, key string, value any, ttl time.Duration) error {
+	q, err := c.init(ctx)
+	if err != nil {
+		return err
+	}
+	val, expires, err := c.encode(value, ttl)
+	if err != nil {
+		return err
+	}
+	_, err = q.db.ExecContext(ctx, q.set, c.key(key), val, expires)
+	return cache.WrapBackendError(err)
+}
+
+func (c *Cache) Has(key string) (bool, error) {
+	return c.HasContext(context.Background(), key)
+}
+
+func (c *Cache) HasContext(ctx context.Context, key string) (bool, error) {
+	if _, err := c.GetContext(ctx, key); err != nil {
+		if cache.IsMiss(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// GetMulti gets the keys with one IN query per batch of keys.
+func (c *Cache) GetMulti(keys []string) ([]any, error) {
+	return c.GetMultiContext(context.Background(), keys)
+}
+
+// GetMultiContext gets the keys with one IN query per batch of keys.
+func (c *Cache) GetMultiContext(ctx context.Context, keys []string) ([]any, error) {
+	q, err := c.init(ctx)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string][]byte, len(keys))
+	for start := 0; start < len(keys); start += maxBatchSize {
+		end := start + maxBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if err := c.getBatch(ctx, q, keys[start:end], found); err != nil {
+			return nil, err
+		}
+	}
+	values := make([]any, len(keys))
+	var keysErr cache.MultiError
+	for i, key := range keys {
+		item, err := c.decode(found[c.key(key)])
+		if err != nil {
+			keysErr = append(keysErr, cache.KeyError{Key: key, Err: err})
+			continue
+		}
+		values[i] = item.GetData()
+	}
+	return values, keysErr.ErrorOrNil()
+}
+
+func (c *Cache) Get(key string) (any, error) {
+	return c.GetContext(context.Background(), key)
+}
+
+func (c *Cache) GetContext(ctx context.Context, key string) (any, error) {
+	item, err := c.getItem(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return item.GetData(), nil
+}
+
+// GetWithTTL gets key with the ttl left in its envelope.
+func (c *Cache) GetWithTTL(ctx context.Context, key string) (any, time.Duration, error) {
+	item, err := c.getItem(ctx, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	return cache.ItemWithTTL(item)
+}
+
+func (c *Cache) getItem(ctx context.Context, key string) (cache.ICacheItem, error) {
+	q, err := c.init(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var val []byte
+	if err := q.db.QueryRowContext(ctx, q.get, c.key(key)).Scan(&val); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, cache.ErrNotFound
+		}
+		return nil, cache.WrapBackendError(err)
+	}
+	return c.decode(val)
+}
+
+func (c *Cache) Delete(key string) error {
+	return c.DeleteContext(context.Background(), key)
+}
+
+func (c *Cache) DeleteContext(ctx context.Context, key string) error {
+	q, err := c.init(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = q.db.ExecContext(ctx, q.delete, c.key(key))
+	return cache.WrapBackendError(err)
+}
+
+// Increment increases a key's counter inside one transaction.
+func (c *Cache) Increment(key string, step int) error {
+	return c.IncrementContext(context.Background(), key, step)
+}
+
+func (c *Cache) IncrementContext(ctx context.Context, key string, step int) error {
+	return c.counter(ctx, key, step, step, cache.Increment)
+}
+
+// Decrement decreases a key's counter inside one transaction.
+func (c *Cache) Decrement(key string, step int) error {
+	return c.DecrementContext(context.Background(), key, step)
+}
+
+func (c *Cache) DecrementContext(ctx context.Context, key string, step int) error {
+	return c.counter(ctx, key, step, -step, cache.Decrement)
+}
+
+// Clear deletes all entries of the table.
+func (c *Cache) Clear() error {
+	return c.ClearContext(context.Background())
+}
+
+func (c *Cache) ClearContext(ctx context.Context) error {
+	q, err := c.init(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = q.db.ExecContext(ctx, q.clear)
+	return cache.WrapBackendError(err)
+}
+
+// Sweep removes the entries that have expired and reports how many.
+// It runs every SweepInterval in the background.
+func (c *Cache) Sweep() (int, error) {
+	q, err := c.init(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	res, err := q.db.Exec(q.sweep, time.Now().UnixNano())
+	if err != nil {
+		return 0, cache.WrapBackendError(err)
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// Migrate creates the table of the Cache if it does not exist.
+func (c *Cache) Migrate() error {
+	c.initL.Lock()
+	defer c.initL.Unlock()
+	db, err := c.db()
+	if err != nil {
+		return err
+	}
+	return Migrate(db, c.Dialect, c.Table, c.Unlogged)
+}
+
+// Ping checks that the database is reachable, opening it if needed.
+func (c *Cache) Ping(ctx context.Context) error {
+	c.initL.Lock()
+	db, err := c.db()
+	c.initL.Unlock()
+	if err != nil {
+		return err
+	}
+	return cache.WrapBackendError(db.PingContext(ctx))
+}
+
+// Close stops the sweeper and closes the database if the Cache opened it;
+// a database set as DB is left open for its owner. It is safe to call
+// more than once.
+func (c *Cache) Close() error {
+	c.janitor.Stop()
+	c.initL.Lock()
+	defer c.initL.Unlock()
+	c.closed, c.queries = true, nil
+	if !c.owned || c.DB == nil {
+		return nil
+	}
+	err := c.DB.Close()
+	c.DB, c.owned = nil, false
+	return cache.WrapBackendError(err)
+}
+
+func (c *Cache) getBatch(ctx context.Context, q *queries, keys []string, found map[string][]byte) error {
+	args := make([]any, len(keys))
+	placeholders := make([]string, len(keys))
+	for i, key := range keys {
+		args[i] = c.key(key)
+		placeholders[i] = c.Dialect.Placeholder(i + 1)
+	}
+	d := c.Dialect
+	rows, err := q.db.QueryContext(ctx, fmt.Sprintf(`SELECT %[1]s, value FROM %[2]s WHERE %[1]s IN (%[3]s)`,
+		d.Quote("key"), d.Quote(c.Table), strings.Join(placeholders, ", ")), args...)
+	if err != nil {
+		return cache.WrapBackendError(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			key string
+			val []byte
+		)
+		if err := rows.Scan(&key, &val); err != nil {
+			return cache.WrapBackendError(err)
+		}
+		found[key] = val
+	}
+	return cache.WrapBackendError(rows.Err())
+}
+
+// counter applies fn to the counter under key, holding the lock on its
+// row. A missing key is created holding initial; when another client
+// creates it first, the update starts over against that row. An existing
+// key keeps its expiration time. Transactions failing with a Retryable
+// error start over too, after a short backoff.
+func (c *Cache) counter(ctx context.Context, key string, step, initial int, fn func(originVal any, step int) (any, error)) error {
+	q, err := c.init(ctx)
+	if err != nil {
+		return err
+	}
+	key = c.key(key)
+	for i := 0; i < maxRetries; i++ {
+		done, err := c.counterTx(ctx, q, key, step, initial, fn)
+		if err != nil && c.Retryable != nil && c.Retryable(err) {
+			time.Sleep(time.Duration(i+1) * time.Millisecond)
+			continue
+		}
+		if err != nil || done {
+			return err
+		}
+	}
+	return cache.WrapError(cache.ErrUnavailable, fmt.Errorf("key %s: too many concurrent updates", key))
+}
+
+func (c *Cache) counterTx(ctx context.Context, q *queries, key string, step, initial int, fn func(originVal any, step int) (any, error)) (bool, error) {
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, cache.WrapBackendError(err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	var current []byte
+	err = tx.QueryRowContext(ctx, q.getForUpdate, key).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		val, expires, err := c.encode(initial, 0)
+		if err != nil {
+			return false, err
+		}
+		res, err := tx.ExecContext(ctx, q.insert, key, val, expires)
+		if err != nil {
+			return false, cache.WrapBackendError(err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return false, nil
+		}
+		return true, cache.WrapBackendError(tx.Commit())
+	}
+	if err != nil {
+		return false, cache.WrapBackendError(err)
+	}
+	var (
+		data any = initial
+		ttl  time.Duration
+	)
+	item, err := c.decode(current)
+	if err != nil && !cache.IsMiss(err) {
+		return false, err
+	}
+	if err == nil {
+		if data, err = fn(item.GetData(), step); err != nil {
+			return false, err
+		}
+		if !item.IsNeverExpires() {
+			ttl = time.Until(item.GetExpirationTime())
+		}
+	}
+	val, expires, err := c.encode(data, ttl)
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, q.set, key, val, expires); err != nil {
+		return false, cache.WrapBackendError(err)
+	}
+	return true, cache.WrapBackendError(tx.Commit())
+}
+
+// encode wraps value in the cache item envelope and reports its
+// expiration time in unix nanoseconds, nil when it never expires.
+func (c *Cache) encode(value any, ttl time.Duration) ([]byte, any, error) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	val, err := c.CacheItem.SetCacheItem(value, ttl)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.CacheItem.IsNeverExpires() {
+		return []byte(val), nil, nil
+	}
+	return []byte(val), c.CacheItem.GetExpirationTime().UnixNano(), nil
+}
+
+func (c *Cache) key(key string) string {
+	if c.KeyTransformer == nil {
+		return key
+	}
+	return c.KeyTransformer(key)
+}
+
+func (c *Cache) decode(val []byte) (cache.ICacheItem, error) {
+	if val == nil {
+		return nil, cache.ErrNotFound
+	}
+	return c.CacheItem.GetCacheItem(val)
+}
+
+// init validates the configuration, creates the table if AutoMigrate is
+// set and builds the queries, once. It fails with the error of ctx when
+// ctx is done.
+func (c *Cache) init(ctx context.Context) (*queries, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.initL.Lock()
+	defer c.initL.Unlock()
+	if c.queries != nil {
+		return c.queries, nil
+	}
+	db, err := c.db()
+	if err != nil {
+		return nil, err
+	}
+	if !tableName.MatchString(c.Table) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidTable, c.Table)
+	}
+	if c.AutoMigrate {
+		if err := Migrate(db, c.Dialect, c.Table, c.Unlogged); err != nil {
+			return nil, err
+		}
+	}
+	d, table, key := c.Dialect, c.Dialect.Quote(c.Table), c.Dialect.Quote("key")
+	get := fmt.Sprintf(`SELECT value FROM %s WHERE %s = %s`, table, key, d.Placeholder(1))
+	c.queries = &queries{
+		db:           db,
+		get:          get,
+		getForUpdate: get + d.ForUpdate(),
+		set:          d.Upsert(c.Table),
+		insert:       d.InsertIgnore(c.Table),
+		delete:       fmt.Sprintf(`DELETE FROM %s WHERE %s = %s`, table, key, d.Placeholder(1)),
+		sweep:        fmt.Sprintf(`DELETE FROM %s WHERE expires_at <= %s`, table, d.Placeholder(1)),
+		clear:        fmt.Sprintf(`DELETE FROM %s`, table),
+	}
+	return c.queries, nil
+}
+
+// db returns DB, opening it first with Open if needed. initL must be held.
+func (c *Cache) db() (*sql.DB, error) {
+	if c.closed {
+		return nil, cache.WrapError(cache.ErrUnavailable, errors.New("sqlcache: cache is closed"))
+	}
+	if c.DB != nil {
+		return c.DB, nil
+	}
+	if c.Open == nil {
+		return nil, cache.WrapError(cache.ErrUnavailable, ErrNoDatabase)
+	}
+	db, err := c.Open()
+	if err != nil {
+		return nil, cache.WrapBackendError(err)
+	}
+	c.DB, c.owned = db, true
+	return db, nil
+}
+
+// Migrate creates table and its expiry index in db if they do not exist.
+// unlogged creates an UNLOGGED table on PostgreSQL and is ignored elsewhere.
+func Migrate(db *sql.DB, dialect Dialect, table string, unlogged bool) error {
+	if !tableName.MatchString(table) {
+		return fmt.Errorf("%w: %q", ErrInvalidTable, table)
+	}
+	for _, query := range dialect.Schema(table, unlogged) {
+		if _, err := db.Exec(query); err != nil {
+			return cache.WrapBackendError(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg6/go-cache"
+	"github.com/pkg6/go-cache/cachetest"
+	"github.com/stretchr/testify/assert"
+	_ "modernc.org/sqlite"
+)
+
+func openSQLite(t *testing.T) *sql.DB {
+	path := filepath.Join(t.TempDir(), "cache.sqlite")
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate")
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return db
+}
+
+func newTestCache(t *testing.T, db *sql.DB, opts ...CacheOptions) *Cache {
+	opts = append([]CacheOptions{CacheWithDB(db), CacheWithDialect(SQLite)}, opts...)
+	c := New(opts...).(*Cache)
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+	return c
+}
+
+func TestConformance(t *testing.T) {
+	cachetest.RunConformance(t, func(t *testing.T) cache.Cache {
+		return newTestCache(t, openSQLite(t))
+	})
+}
+
+func TestCacheLifecycle(t *testing.T) {
+	cachetest.RunLifecycle(t, func(t *testing.T) cache.Cache {
+		return newTestCache(t, openSQLite(t))
+	})
+}
+
+func TestCacheLogging(t *testing.T) {
+	cachetest.RunLogging(t, func(t *testing.T) cache.Cache {
+		return newTestCache(t, openSQLite(t))
+	})
+}
+
+func TestCacheTables(t *testing.T) {
+	cachetest.RunSharedBackend(t, func(t *testing.T) (cache.Cache, cache.Cache) {
+		db := openSQLite(t)
+		return newTestCache(t, db, CacheWithTable("cache_a")), newTestCache(t, db, CacheWithTable("cache_b"))
+	})
+}
+
+func TestCacheOpen(t *testing.T) {
+	var opened *sql.DB
+	c := New(CacheWithDialect(SQLite), CacheWithSweepInterval(0), CacheWithOpen(func() (*sql.DB, error) {
+		opened = openSQLite(t)
+		return opened, nil
+	})).(*Cache)
+	assert.Nil(t, c.Set("key1", "author", time.Minute))
+	assert.Same(t, opened, c.DB)
+	// the database it opened is closed with it
+	assert.Nil(t, c.Close())
+	assert.Error(t, opened.Ping())
+
+	c = New(CacheWithDialect(SQLite), CacheWithSweepInterval(0), CacheWithOpen(func() (*sql.DB, error) {
+		return nil, errors.New("no such file")
+	})).(*Cache)
+	assert.ErrorIs(t, c.Ping(context.Background()), cache.ErrUnavailable)
+}
+
+func TestCacheConfiguration(t *testing.T) {
+	c := New(CacheWithSweepInterval(0))
+	assert.ErrorIs(t, c.Set("key1", "author", time.Minute), ErrNoDatabase)
+	assert.ErrorIs(t, c.Set("key1", "author", time.Minute), cache.ErrUnavailable)
+
+	c = newTestCache(t, openSQLite(t), CacheWithTable("gocache; DROP TABLE users"))
+	assert.ErrorIs(t, c.Set("key1", "author", time.Minute), ErrInvalidTable)
+
+	c = newTestCache(t, openSQLite(t), CacheWithAutoMigrate(false))
+	assert.ErrorIs(t, c.Set("key1", "author", time.Minute), cache.ErrUnavailable)
+	assert.Nil(t, c.(*Cache).Migrate())
+	assert.Nil(t, c.Set("key1", "author", time.Minute))
+}
+
+func TestCacheSweep(t *testing.T) {
+	cachetest.RunSweep(t, func(t *testing.T) cache.Cache {
+		return newTestCache(t, openSQLite(t), CacheWithSweepInterval(0))
+	})
+}
+
+func TestCacheGetMultiBatches(t *testing.T) {
+	c := newTestCache(t, openSQLite(t))
+	keys := make([]string, maxBatchSize+10)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+		if i%2 == 0 {
+			assert.Nil(t, c.Set(keys[i], i, time.Minute))
+		}
+	}
+	values, err := c.GetMulti(keys)
+	var multi cache.MultiError
+	assert.ErrorAs(t, err, &multi)
+	assert.Len(t, multi, len(keys)/2)
+	for i, val := range values {
+		if i%2 == 0 {
+			assert.Equal(t, float64(i), val)
+		} else {
+			assert.Nil(t, val)
+		}
+	}
+}
+
+func TestCacheKeyTransformer(t *testing.T) {
+	// the MySQL key column is bounded, so long keys are shortened
+	assert.Nil(t, New(CacheWithSweepInterval(0)).(*Cache).KeyTransformer)
+	mysqlCache := New(CacheWithDialect(MySQL), CacheWithSweepInterval(0)).(*Cache)
+	assert.Len(t, mysqlCache.KeyTransformer(strings.Repeat("k", 1000)), MySQL.(KeyLimiter).MaxKeyLength())
+
+	db := openSQLite(t)
+	c := newTestCache(t, db, CacheWithKeyTransformer(cache.SafeKey(16)))
+	long := strings.Repeat("k", 100)
+	assert.Nil(t, c.Set(long, "author", time.Minute))
+	assert.Nil(t, c.Increment("counter "+long, 1))
+	values, err := c.GetMulti([]string{long})
+	assert.Nil(t, err)
+	assert.Equal(t, []any{"author"}, values)
+	var n int
+	assert.Nil(t, db.QueryRow(`SELECT count(*) FROM gocache WHERE length(key) <= 16`).Scan(&n))
+	assert.Equal(t, 2, n)
+	assert.Nil(t, c.Delete(long))
+	ok, err := c.Has(long)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestCacheConcurrentCountersAcrossCaches(t *testing.T) {
+	db := openSQLite(t)
+	caches := []*Cache{newTestCache(t, db), newTestCache(t, db)}
+	var wg sync.WaitGroup
+	for _, c := range caches {
+		wg.Add(1)
+		go func(c *Cache) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				assert.Nil(t, c.Increment("counter", 1))
+			}
+		}(c)
+	}
+	wg.Wait()
+	val, err := caches[0].Get("counter")
+	assert.Nil(t, err)
+	assert.Equal(t, float64(100), val)
+}
+
+func TestDialects(t *testing.T) {
+	testCases := []struct {
+		dialect      Dialect
+		placeholder  string
+		upsert       string
+		insertIgnore string
+		forUpdate    string
+	}{
+		{
+			dialect:      Postgres,
+			placeholder:  "$2",
+			upsert:       `INSERT INTO "c" ("key", value, expires_at) VALUES ($1, $2, $3) ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at`,
+			insertIgnore: `INSERT INTO "c" ("key", value, expires_at) VALUES ($1, $2, $3) ON CONFLICT (key) DO NOTHING`,
+			forUpdate:    " FOR UPDATE",
+		},
+		{
+			dialect:      MySQL,
+			placeholder:  "?",
+			upsert:       "INSERT INTO `c` (`key`, value, expires_at) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE value = VALUES(value), expires_at = VALUES(expires_at)",
+			insertIgnore: "INSERT IGNORE INTO `c` (`key`, value, expires_at) VALUES (?, ?, ?)",
+			forUpdate:    " FOR UPDATE",
+		},
+		{
+			dialect:      SQLite,
+			placeholder:  "?",
+			upsert:       `INSERT INTO "c" ("key", value, expires_at) VALUES (?, ?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at`,
+			insertIgnore: `INSERT INTO "c" ("key", value, expires_at) VALUES (?, ?, ?) ON CONFLICT (key) DO NOTHING`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.dialect.Name(), func(t *testing.T) {
+			assert.Equal(t, tc.placeholder, tc.dialect.Placeholder(2))
+			assert.Equal(t, tc.upsert, tc.dialect.Upsert("c"))
+			assert.Equal(t, tc.insertIgnore, tc.dialect.InsertIgnore("c"))
+			assert.Equal(t, tc.forUpdate, tc.dialect.ForUpdate())
+		})
+	}
+	assert.Contains(t, Postgres.Schema("c", true)[0], "CREATE UNLOGGED TABLE")
+	assert.NotContains(t, Postgres.Schema("c", false)[0], "UNLOGGED")
+}
+
+func TestCachePing(t *testing.T) {
+	db := openSQLite(t)
+	c := newTestCache(t, db)
+	assert.Nil(t, c.Ping(context.Background()))
+	assert.Nil(t, db.Close())
+	assert.ErrorIs(t, c.Ping(context.Background()), cache.ErrUnavailable)
+	assert.ErrorIs(t, New(CacheWithSweepInterval(0)).(*Cache).Ping(context.Background()), ErrNoDatabase)
+}
+
+func TestCacheContext(t *testing.T) {
+	c := newTestCache(t, openSQLite(t))
+	assert.Same(t, c, cache.NewContextCache(c))
+	ctx := context.Background()
+	assert.Nil(t, c.SetContext(ctx, "key1", "author", time.Minute))
+	assert.Nil(t, c.IncrementContext(ctx, "counter", 2))
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err := c.GetContext(canceled, "key1")
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = c.GetMultiContext(canceled, []string{"key1"})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, c.IncrementContext(canceled, "counter", 1), context.Canceled)
+	assert.ErrorIs(t, c.ClearContext(canceled), context.Canceled)
+	val, err := c.GetContext(ctx, "counter")
+	assert.Nil(t, err)
+	assert.Equal(t, float64(2), val)
+}
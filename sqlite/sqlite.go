@@ -0,0 +1,141 @@
+// Package sqlite provides a cache adapter storing entries in a SQLite table,
+// through the pure-Go modernc.org/sqlite driver so no cgo is needed.
+//
+// It is sqlcache with the SQLite dialect, opening its own database file.
+// Entries live in one table of key, value and expires_at, the latter
+// indexed so expired entries are swept without a table scan. The table can
+// be inspected with any SQLite tooling:
+//
+//	SELECT key, datetime(expires_at / 1e9, 'unixepoch') FROM gocache;
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg6/go-cache"
+	"github.com/pkg6/go-cache/sqlcache"
+	driver "modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+const (
+	// DefaultTable is the table entries are stored in.
+	DefaultTable = sqlcache.DefaultTable
+	// DefaultSweepInterval is how often expired entries are removed.
+	DefaultSweepInterval = sqlcache.DefaultSweepInterval
+	// DefaultBusyTimeout is how long a connection opened from Path waits
+	// for the locks of other connections.
+	DefaultBusyTimeout = 5 * time.Second
+)
+
+var (
+	DefaultPath = filepath.Join(os.TempDir(), "gocache.sqlite")
+
+	ErrInvalidTable = sqlcache.ErrInvalidTable
+)
+
+type Cache struct {
+	*sqlcache.Cache
+	// Path of the database file, used unless DB is set
+	Path string
+}
+type CacheOptions func(c *Cache)
+
+// CacheWithPath configures the database file.
+func CacheWithPath(path string) CacheOptions {
+	return func(c *Cache) {
+		c.Path = path
+	}
+}
+
+// CacheWithDB configures a database the application already owns; it must
+// be opened with the "sqlite" driver. The Cache does not close it, nor
+// change its journal mode.
+func CacheWithDB(db *sql.DB) CacheOptions {
+	return func(c *Cache) {
+		c.DB = db
+	}
+}
+
+// CacheWithTable configures the table entries are stored in.
+func CacheWithTable(table string) CacheOptions {
+	return func(c *Cache) {
+		c.Table = table
+	}
+}
+
+// CacheWithSweepInterval configures how often expired entries are removed.
+func CacheWithSweepInterval(interval time.Duration) CacheOptions {
+	return func(c *Cache) {
+		c.SweepInterval = interval
+	}
+}
+
+func CacheWithCacheItem(cacheItem cache.ICacheItem) CacheOptions {
+	return func(c *Cache) {
+		c.CacheItem = cacheItem
+	}
+}
+
+// CacheWithLogger sets the Logger of the store.
+func CacheWithLogger(logger *cache.Logger) CacheOptions {
+	return func(c *Cache) {
+		c.Logger = logger
+	}
+}
+
+// New creates a new sqlite cache. Call Close to stop its sweeper and
+// release the database.
+func New(opts ...CacheOptions) cache.Cache {
+	c := &Cache{Path: DefaultPath}
+	c.Cache = sqlcache.New(
+		sqlcache.CacheWithDialect(sqlcache.SQLite),
+		sqlcache.CacheWithOpen(c.open),
+		sqlcache.CacheWithRetryable(isBusy),
+		func(inner *sqlcache.Cache) {
+			inner.StoreName = cache.SQLiteCacheName
+			c.Cache = inner
+			for _, opt := range opts {
+				opt(c)
+			}
+		},
+	).(*sqlcache.Cache)
+	return c
+}
+
+// open opens Path, creating its directory.
+func (c *Cache) open() (*sql.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0o700); err != nil {
+		return nil, err
+	}
+	return sql.Open("sqlite", dsn(c.Path))
+}
+
+// Migrate creates the table and its expiry index if they do not exist.
+// New calls it on first use; it is exported for applications that
+// manage their schema up front.
+func Migrate(db *sql.DB, table string) error {
+	return sqlcache.Migrate(db, sqlcache.SQLite, table, false)
+}
+
+// dsn enables WAL, so readers do not block the writer, and takes the write
+// lock when a transaction begins, so counters do not deadlock upgrading.
+// busy_timeout comes first: switching to WAL needs the lock too.
+func dsn(path string) string {
+	return fmt.Sprintf("file:%s?_pragma=busy_timeout(%d)&_pragma=journal_mode(WAL)&_txlock=immediate",
+		path, DefaultBusyTimeout.Milliseconds())
+}
+
+func isBusy(err error) bool {
+	var sqliteErr *driver.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	code := sqliteErr.Code() & 0xff
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
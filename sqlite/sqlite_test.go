@@ -0,0 +1,96 @@
+package sqlite
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg6/go-cache"
+	"github.com/pkg6/go-cache/cachetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCache(t *testing.T, opts ...CacheOptions) *Cache {
+	opts = append([]CacheOptions{CacheWithPath(filepath.Join(t.TempDir(), "cache.sqlite"))}, opts...)
+	c := New(opts...).(*Cache)
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+	return c
+}
+
+func TestConformance(t *testing.T) {
+	cachetest.RunConformance(t, func(t *testing.T) cache.Cache {
+		return newTestCache(t)
+	})
+}
+
+func TestCacheLifecycle(t *testing.T) {
+	cachetest.RunLifecycle(t, func(t *testing.T) cache.Cache {
+		return newTestCache(t)
+	})
+}
+
+func TestCacheLogging(t *testing.T) {
+	cachetest.RunLogging(t, func(t *testing.T) cache.Cache {
+		return newTestCache(t)
+	})
+}
+
+func TestCacheSharedDB(t *testing.T) {
+	cachetest.RunSharedBackend(t, func(t *testing.T) (cache.Cache, cache.Cache) {
+		db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "app.sqlite"))
+		assert.Nil(t, err)
+		t.Cleanup(func() {
+			_ = db.Close()
+		})
+		return New(CacheWithDB(db), CacheWithTable("cache_a")), New(CacheWithDB(db), CacheWithTable("cache_b"))
+	})
+}
+
+func TestCacheSweep(t *testing.T) {
+	var c *Cache
+	cachetest.RunSweep(t, func(t *testing.T) cache.Cache {
+		c = newTestCache(t, CacheWithSweepInterval(0))
+		return c
+	})
+	var (
+		id, parent, unused int
+		plan               string
+	)
+	assert.Nil(t, c.DB.QueryRow(`EXPLAIN QUERY PLAN DELETE FROM gocache WHERE expires_at <= 1`).Scan(&id, &parent, &unused, &plan))
+	assert.Contains(t, plan, "gocache_expires_at")
+}
+
+func TestCacheConcurrentCountersAcrossCaches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.sqlite")
+	a := newTestCache(t, CacheWithPath(path))
+	b := newTestCache(t, CacheWithPath(path))
+	done := make(chan struct{})
+	for _, c := range []*Cache{a, b} {
+		go func(c *Cache) {
+			defer func() { done <- struct{}{} }()
+			for i := 0; i < 50; i++ {
+				assert.Nil(t, c.Increment("counter", 1))
+			}
+		}(c)
+	}
+	<-done
+	<-done
+	val, err := a.Get("counter")
+	assert.Nil(t, err)
+	assert.Equal(t, float64(100), val)
+}
+
+func TestCacheName(t *testing.T) {
+	c := newTestCache(t, CacheWithSweepInterval(0))
+	assert.Equal(t, cache.SQLiteCacheName, c.Name())
+	assert.ErrorIs(t, newTestCache(t, CacheWithTable("gocache; DROP TABLE users")).Set("key1", "author", time.Minute), ErrInvalidTable)
+}
+
+func TestCacheContext(t *testing.T) {
+	var c cache.Cache = newTestCache(t)
+	_, ok := c.(cache.ContextCache)
+	assert.True(t, ok)
+}